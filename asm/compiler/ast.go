@@ -19,7 +19,9 @@ type ByteSpec struct {
 
 type SymbolSpec struct {
 	ByteSpec
-	Name string
+	Name     string
+	Expr     Node // expression evaluated instead of Name when not nil
+	Relative bool // value is taken relative to the text address
 }
 
 type (
@@ -65,6 +67,7 @@ type (
 		lexer.Position
 		Kind  int
 		Index int
+		Name  string // as written in source
 	}
 
 	// SymbolNode represents a symbol.
@@ -85,6 +88,20 @@ type (
 		Text string
 	}
 
+	// UnaryNode represents unary operation in a constant expression.
+	UnaryNode struct {
+		lexer.Position
+		Op string
+		X  Node
+	}
+
+	// BinaryNode represents binary operation in a constant expression.
+	BinaryNode struct {
+		lexer.Position
+		Op   string
+		X, Y Node
+	}
+
 	// BlockNode represents a block of clauses.
 	BlockNode struct {
 		lexer.Position
@@ -94,15 +111,26 @@ type (
 	// TextNode is a slice of raw machine words.
 	TextNode struct {
 		lexer.Position
-		Text []machine.Word
+		Text    []machine.Word
 		Symbols []SymbolSpec
+		Address int // assigned when collecting symbols
+	}
+
+	// OriginNode moves location counter forward to Origin, or to the
+	// next multiple of Align when it is not zero.
+	OriginNode struct {
+		lexer.Position
+		Origin int
+		Align  int
+		Size   int // padding size, assigned when collecting symbols
 	}
 
 	// ErrorNode represents parse error.
 	ErrorNode struct {
 		lexer.Position
 		Message string
-		Datum Node
+		Datum   Node
+		Code    string // diagnostic code, see package diag
 	}
 )
 
@@ -115,8 +143,11 @@ func (n RegisterNode) Pos() lexer.Position    { return n.Position }
 func (n SymbolNode) Pos() lexer.Position      { return n.Position }
 func (n IntegerNode) Pos() lexer.Position     { return n.Position }
 func (n StringNode) Pos() lexer.Position      { return n.Position }
+func (n UnaryNode) Pos() lexer.Position       { return n.Position }
+func (n BinaryNode) Pos() lexer.Position      { return n.Position }
 func (n BlockNode) Pos() lexer.Position       { return n.Position }
 func (n TextNode) Pos() lexer.Position        { return n.Position }
+func (n OriginNode) Pos() lexer.Position      { return n.Position }
 func (n ErrorNode) Pos() lexer.Position       { return n.Position }
 
 // ErrorNode additionally implements error interface.
@@ -3,7 +3,10 @@ package compiler
 
 import (
 	"fmt"
+	"github.com/niksaak/rhmrm/asm/diag"
+	"github.com/niksaak/rhmrm/asm/lexer"
 	"github.com/niksaak/rhmrm/machine"
+	"strings"
 )
 
 // TODO: parse tree traversal funcs are highly unoptimized. This is not cool.
@@ -16,24 +19,51 @@ type Compiler struct {
 	// Immutable state:
 	Directives map[string]TranslatorFunc
 	Mnemonics  map[string]machine.Word
+	Immediates map[string]machine.Word // IMP subopcodes
+
+	IncludeDirs []string  // searched for included files
+	Parse       ParseFunc // parses included files
 
 	// Mutable state:
 	Macros   map[string]TranslatorFunc
 	Symbols  map[string]int
 	Comments map[int]*CommentNode
 
-	PassCount  int
-	PassMax    int // when PassCount exceeds this, compiling is stopped
-	ErrorCount int
+	defs      map[string]Node   // definitions of symbols
+	consts    map[string]Node   // constants yet to be evaluated
+	includers map[string]string // files including other files
+	labels    map[string]bool   // labels preceding clauses expanded
+	scope     string            // global label preceding clauses expanded
+
+	expansions int // macro expansions made so far
+	depth      int // nesting depth of macro expansions
+
+	PassCount int
+	PassMax   int // when PassCount exceeds this, compiling is stopped
+
+	Diagnostics diag.List // errors and warnings of the last compilation
 }
 
 // Init initializes compiler. Zero parameter sets passes cap to the default.
 func (c *Compiler) Init(maxPasses int) *Compiler {
-	c.Directives = make(map[string]TranslatorFunc)
+	c.Directives = c.directives()
 	c.Mnemonics = make(map[string]machine.Word)
+	for k, v := range mnemonics {
+		c.Mnemonics[k] = v
+	}
+	c.Immediates = make(map[string]machine.Word)
+	for k, v := range immediates {
+		c.Immediates[k] = v
+	}
 	c.Macros = make(map[string]TranslatorFunc)
 	c.Symbols = make(map[string]int)
 	c.Comments = make(map[int]*CommentNode)
+	c.defs = make(map[string]Node)
+	c.consts = make(map[string]Node)
+	c.includers = make(map[string]string)
+	c.labels = make(map[string]bool)
+	c.scope = ""
+	c.expansions, c.depth = 0, 0
 
 	c.PassCount = 0
 	if maxPasses != 0 {
@@ -41,84 +71,204 @@ func (c *Compiler) Init(maxPasses int) *Compiler {
 	} else {
 		c.PassMax = 32
 	}
-	c.ErrorCount = 0
+	c.Diagnostics = nil
 
 	return c
 }
 
-// InstructionMk returns instruction
+// ErrorCount returns number of errors of the last compilation.
+func (c *Compiler) ErrorCount() int {
+	return c.Diagnostics.ErrorCount()
+}
+
+// InstructionMk returns a function translating operands of instruction op
+// into a TextNode, or nil if op is not a known mnemonic. IMP instructions
+// take the subopcode mnemonic or constant as their first operand.
 func (c *Compiler) InstructionMk(op string) TranslatorFunc {
 	opcode, ok := c.Mnemonics[op]
-	_ = opcode // TODO
 	if !ok {
 		return nil
 	}
+	if opcode != machine.OP_IMP {
+		return c.mkTranslator(opcode, 1, ordFormat(opcode))
+	}
 	return func(operands []Node) []Node {
-		return nil // TODO
+		if len(operands) == 0 {
+			return []Node{&ErrorNode{Message: "missing IMP subopcode",
+				Code: diag.Invalid}}
+		}
+		sub, err := c.impSubopcode(operands[0])
+		if err != nil {
+			return []Node{err}
+		}
+		size, format := 2, impFormat(sub)
+		if format == nil {
+			size = 1
+		}
+		word := machine.WMkInstruction2(machine.OP_IMP, int16(sub), 0)
+		return c.mkTranslator(word, size, format)(operands[1:])
 	}
 }
 
-func (c *Compiler) MacroMk(op string, operands []Node) TranslatorFunc {
-	return nil // TODO
+// impSubopcode returns IMP subopcode named by a mnemonic or a constant.
+func (c *Compiler) impSubopcode(nd Node) (machine.Word, *ErrorNode) {
+	if sym, ok := nd.(*SymbolNode); ok {
+		if sub, ok := c.Immediates[sym.Name]; ok {
+			return sub, nil
+		}
+		if _, ok := c.Symbols[sym.Name]; !ok {
+			return 0, coded(diag.Unknown, mkErrorNodef(sym)(
+				"unknown IMP subopcode: %s", sym.Name))
+		}
+	}
+	sub, err := c.integerOperand(nd, 0, 0x1f)
+	return machine.Word(sub), err
 }
 
-// Compile takes a parse tree and returns a slice of machine Words.
-func (c *Compiler) Compile(nodes []Node) (_ []machine.Word, err error) {
+// Compile takes a parse tree and returns a slice of machine Words. When
+// there are errors, returned error is a diag.List of all diagnostics sorted
+// by position; warnings are kept in c.Diagnostics in any case.
+func (c *Compiler) Compile(nodes []Node) (ws []machine.Word, err error) {
 	defer func() {
-		e := recover()
-		if e == nil {
-			return
-		}
-		if _, ok := e.(error); !ok {
-			err = fmt.Errorf("%v", e)
+		if e := recover(); e != nil {
+			c.Diagnostics.Add(lexer.Position{}, diag.Error,
+				diag.Internal, "%v", e)
 		}
+		c.Diagnostics.Sort()
+		err = c.Diagnostics.Err()
 	}()
 	ret := make([]Node, len(nodes))
 	copy(ret, nodes)
 	ret = c.expandMacros(ret)
+	ret = append(ret, c.evalConstants()...)
 	ret = c.generateText(ret)
 	ret = c.processSymbols(ret)
-	return c.words(ret)
+	return c.words(ret), nil
 }
 
-// expandMacros expands all macro invocations in source.
+// expandMacros preprocesses source in order: it expands macro invocations,
+// included files and chosen branches of conditionals, and collects
+// constant definitions.
 func (c *Compiler) expandMacros(ns []Node) []Node {
+	ns = c.hoistErrors(ns)
 	ns = c.collectMacrodefs(ns)
-	for i, n := range ns {
-		n, ok := n.(*InstructionNode)
-		if !ok { // node must be an instruction
+	for i := 0; i < len(ns); i++ {
+		var expanded []Node
+		switch n := ns[i].(type) {
+		case *LabelNode:
+			if !localp(n.Name) {
+				c.scope = n.Name
+			}
+			c.labels[qualify(c.scope, n.Name)] = true
 			continue
-		}
-		fm, ok := c.Macros[n.Op]
-		if !ok { // instruction operator must be a macro
+		case *InstructionNode:
+			fm, ok := c.Macros[n.Op]
+			if !ok { // instruction operator must be a macro
+				continue
+			}
+			if c.depth >= c.PassMax {
+				expanded = []Node{coded(diag.Macro, mkErrorNodef(n)(
+					"macro recursion too deep: %s", n.Op))}
+				break
+			}
+			c.depth++
+			expanded = c.expandMacros(c.situate(n, fm(n.Operands)))
+			c.depth--
+		case *DirectiveNode:
+			switch n.Op {
+			case "equ", "set":
+				if err := c.defineConstant(n); err != nil {
+					expanded = []Node{err}
+				}
+			case "include":
+				expanded = c.expandMacros(c.includeFile(n))
+			case "if", "ifdef", "ifndef":
+				// conditions see constants defined so far
+				expanded = c.evalConstants()
+				body, end := c.conditional(ns, i)
+				expanded = append(expanded, c.expandMacros(body)...)
+				ns = append(ns[:i+1], ns[end:]...)
+			case "elif", "else", "endif":
+				expanded = []Node{coded(diag.Conditional,
+					mkErrorNodef(n)("misplaced .%s", n.Op))}
+			default:
+				continue
+			}
+		default:
 			continue
 		}
-		expanded := c.expandMacros(fm(n.Operands))
 		// replace n with expanded in ns
 		ns = setNode(ns, i, expanded...)
+		i += len(expanded) - 1
 	}
 	return ns
 }
 
-// generateText generates textNodes from instruction nodes.
+// generateText generates textNodes from instruction and directive nodes.
 func (c *Compiler) generateText(ns []Node) []Node {
-	for i, n := range ns {
-		errorNd := mkErrorNodef(n)
-		n, ok := n.(*InstructionNode)
-		if !ok {
+	for i := 0; i < len(ns); i++ {
+		errorNd := mkErrorNodef(ns[i])
+		var text []Node
+		switch n := ns[i].(type) {
+		case *InstructionNode:
+			fn := c.InstructionMk(n.Op)
+			if fn == nil {
+				ns[i] = coded(diag.Unknown,
+					errorNd("unknown instruction: %s", n.Op))
+				continue
+			}
+			text = fn(n.Operands)
+		case *DirectiveNode:
+			fn, ok := c.Directives[n.Op]
+			if !ok {
+				ns[i] = coded(diag.Unknown,
+					errorNd("unknown directive: %s", n.Op))
+				continue
+			}
+			text = fn(n.Operands)
+		default:
 			continue
 		}
-		// fn, ok := c.Instructions[n.Op]
-		fn := c.InstructionMk(n.Op)
-		if fn == nil {
-			ns = setNode(ns, i,
-				errorNd("unknown instruction: %s", n.Op))
-			c.ErrorCount++
-			continue
+		n := ns[i]
+		for _, t := range text {
+			switch t := t.(type) {
+			case *TextNode:
+				if !t.ValidP() {
+					t.Position = n.Pos()
+				}
+			case *ErrorNode:
+				if !t.ValidP() {
+					t.Position = n.Pos()
+				}
+				if t.Datum == nil {
+					t.Datum = n
+				}
+			}
 		}
-		text := fn(n.Operands)
 		// replace n with text in ns
 		ns = setNode(ns, i, text...)
+		i += len(text) - 1
+	}
+	return ns
+}
+
+// situate gives text and error nodes in ns produced from nd the position
+// of nd when they lack one, and counts errors.
+func (c *Compiler) situate(nd Node, ns []Node) []Node {
+	for _, n := range ns {
+		switch n := n.(type) {
+		case *TextNode:
+			if !n.ValidP() {
+				n.Position = nd.Pos()
+			}
+		case *ErrorNode:
+			if !n.ValidP() {
+				n.Position = nd.Pos()
+			}
+			if n.Datum == nil {
+				n.Datum = nd
+			}
+		}
 	}
 	return ns
 }
@@ -132,142 +282,318 @@ func (c *Compiler) processSymbols(ns []Node) []Node {
 		if !ok {
 			continue // skip non-text nodes
 		}
+		unresolved := ""
 		for _, r := range n.Symbols {
-			v, ok := c.Symbols[r.Name]
-			if !ok {
-				ns = setNode(ns, i,
-					errorNd("unresolved symbol: %s",
-						r.Name))
-				c.ErrorCount++
+			v, missing, err := c.evalSpec(r)
+			if err != nil {
+				ns[i] = err
+				break
+			}
+			if missing != nil {
+				unresolved += " " + strings.Join(missing, " ")
 				continue
 			}
+			if r.Relative {
+				v -= n.Address
+			}
+			if !specFits(r.ByteSpec, v, r.Relative) {
+				ns[i] = coded(diag.Range, errorNd(
+					"symbol %s out of range: %d", r.Name, v))
+				break
+			}
 			setSpec(n.Text, r.ByteSpec, machine.Word(v))
-			n.Symbols = n.Symbols[1:]
 		}
-		if len(n.Symbols) != 0 {
-			msg := "unresolved symbols left: "
-			for _, s := range n.Symbols {
-				msg += s.Name + " "
-			}
-			ns = setNode(ns, i, errorNd(msg))
-			c.ErrorCount++
-			continue
+		n.Symbols = nil
+		if unresolved != "" {
+			ns[i] = coded(diag.Undefined,
+				errorNd("unresolved symbols:%s", unresolved))
 		}
-		ns = setNode(ns, i, n)
 	}
 	return ns
 }
 
 // words function concatenates a slice of TextNodes and returns slice of
-// machine words. It returns error upon encountering any other kind of Node.
-func (c *Compiler) words(ns []Node) ([]machine.Word, error) {
+// machine words. Error nodes and any other kind of Node are reported to
+// c.Diagnostics.
+func (c *Compiler) words(ns []Node) []machine.Word {
 	ws := make([]machine.Word, 0, 255)
-	for i, nd := range ns {
-		text, ok := nd.(*TextNode)
-		if !ok {
-			err := fmt.Errorf("node #%d is not text-node in %v",
-				i, ns)
-			return ws, err
+	for _, nd := range ns {
+		switch n := nd.(type) {
+		case *TextNode:
+			ws = append(ws, n.Text...)
+		case *ErrorNode:
+			code := n.Code
+			if code == "" {
+				code = diag.Invalid
+			}
+			c.Diagnostics.Add(n.Position, diag.Error, code,
+				"%s", n.Message)
+		default:
+			c.Diagnostics.Add(nd.Pos(), diag.Error, diag.Internal,
+				"unexpected %T node", nd)
 		}
-		ws = append(ws, text.Text...)
 	}
-	return ws, nil
+	return ws
 }
 
-// collectMacrodefs populates compiler state with macro definitions.
-func (c *Compiler) collectMacrodefs(ns []Node) []Node {
-	for i, nd := range ns {
-		errorNd := mkErrorNodef(nd)
-		// macros are defined with the `.macro` directive.
-		md, ok := nd.(*DirectiveNode)
-		if !ok || md.Op != "macro" {
+// hoistErrors replaces clauses containing errors, such as those of
+// operands left by parser, with the errors.
+func (c *Compiler) hoistErrors(ns []Node) []Node {
+	for i := 0; i < len(ns); i++ {
+		if _, ok := ns[i].(*ErrorNode); ok {
+			continue
+		}
+		var errs []Node
+		walkNodes([]Node{ns[i]}, func(nd Node) {
+			if err, ok := nd.(*ErrorNode); ok {
+				errs = append(errs, err)
+			}
+		})
+		if errs == nil {
 			continue
 		}
-		// first operand must be a symbol naming a macro
-		ident, ok := md.Operands[0].(*SymbolNode)
+		ns = setNode(ns, i, errs...)
+		i += len(errs) - 1
+	}
+	return ns
+}
+
+// warnf reports warning with code about nd.
+func (c *Compiler) warnf(nd Node, code, format string, args ...interface{}) {
+	c.Diagnostics.Add(nd.Pos(), diag.Warning, code, format, args...)
+}
+
+// defineConstant records `.equ` or `.set` constant definition. The value
+// is evaluated by evalConstants, with local labels in it qualified like
+// references in text.
+func (c *Compiler) defineConstant(d *DirectiveNode) *ErrorNode {
+	var sym *SymbolNode
+	if len(d.Operands) == 2 {
+		sym, _ = d.Operands[0].(*SymbolNode)
+	}
+	if sym == nil {
+		return mkErrorNodef(d)("malformed constant definition, "+
+			"want .%s NAME, value", d.Op)
+	}
+	if _, ok := c.defs[sym.Name]; ok {
+		return c.redefined(sym.Name, d)
+	}
+	c.defs[sym.Name] = d
+	walkSymbols(d.Operands[1], func(s *SymbolNode) {
+		s.Name = qualify(c.scope, s.Name)
+	})
+	c.consts[sym.Name] = d.Operands[1]
+	return nil
+}
+
+// evalConstants evaluates defined constants while there is progress and
+// returns evaluation errors. Constants depending on labels are evaluated
+// later, when collecting symbols.
+func (c *Compiler) evalConstants() (errs []Node) {
+	for progress := true; progress; {
+		progress = false
+		for name, x := range c.consts {
+			v, missing, err := eval(x, c.Symbols)
+			if err != nil {
+				errs = append(errs, err)
+				delete(c.consts, name)
+				continue
+			}
+			if missing == nil {
+				c.Symbols[name] = v
+				delete(c.consts, name)
+				progress = true
+			}
+		}
+	}
+	return errs
+}
+
+// collectSymbols populates compiler state with symbol definitions and
+// assigns addresses to text nodes. Labels and comments are removed from
+// the node list.
+func (c *Compiler) collectSymbols(ns []Node) []Node {
+	c.qualifyLocals(ns)
+	// label names must be unique
+	for i, nd := range ns {
+		l, ok := nd.(*LabelNode)
 		if !ok {
-			ns = setNode(ns, i,
-				errorNd("malformed macro definition"))
-			c.ErrorCount++
 			continue
 		}
-		// macro name must be unique
-		name := ident.Name
-		if _, ok := c.Symbols[name]; ok {
-			ns = setNode(ns, i,
-				errorNd("macro already defined: %s", name))
-			c.ErrorCount++
+		if _, ok := c.defs[l.Name]; ok {
+			ns[i] = c.redefined(l.Name, l)
 			continue
 		}
-		// last operand of `.macro` is a block containing macro body
-		body, ok := md.Operands[len(md.Operands)-1].(*BlockNode)
-		if !ok {
-			ns = setNode(ns, i, errorNd("missing macro body"))
-			c.ErrorCount++
+		c.defs[l.Name] = l
+	}
+	// assign addresses until they settle
+	for settled := false; !settled; {
+		if c.PassCount >= c.PassMax {
+			ns = append(ns, &ErrorNode{Message: fmt.Sprintf(
+				"symbols unsettled after %d passes", c.PassCount),
+				Code: diag.Invalid})
+			break
+		}
+		c.PassCount++
+		settled = c.assignAddresses(ns)
+	}
+	// constants depending on undefined symbols are errors
+	for name, x := range c.consts {
+		_, missing, err := eval(x, c.Symbols)
+		switch {
+		case err != nil:
+		case missing != nil:
+			err = coded(diag.Undefined, mkErrorNodef(x)(
+				"constant %s depends on undefined symbols: %s",
+				name, strings.Join(missing, " ")))
+		default:
+			delete(c.consts, name)
 			continue
 		}
-		// other operands must be symbols
-		args := make([]*SymbolNode, len(md.Operands)-2)
-		for i, o := range md.Operands[1:len(md.Operands)-1] {
-			if sym, ok := o.(*SymbolNode); ok {
-				args[i] = sym
-			} else {
-				ns = setNode(ns, i,
-					errorNd("not a symbol: %v", o))
-				c.ErrorCount++
+		ns = append(ns, err)
+	}
+	// labels and comments are not needed anymore, text must fit in memory
+	var prev *TextNode
+	overflow := false
+	for i := 0; i < len(ns); i++ {
+		switch n := ns[i].(type) {
+		case *TextNode:
+			prev = n
+			if !overflow && textEnd(n) > 0x10000 {
+				overflow = true
+				ns[i] = c.overflowed(n, n.Address)
+			}
+			continue
+		case *OriginNode:
+			addr := textEnd(prev)
+			if n.Align == 0 && n.Origin < addr {
+				ns[i] = coded(diag.Range, mkErrorNodef(n)(
+					"origin %#x is behind location counter %#x",
+					n.Origin, addr))
 				continue
 			}
-		}
-		// if all above is ok, compile a macro func
-		fm := mkMacroExpander(args, body.Clauses)
-		if fm == nil {
-			ns = setNode(ns, i, errorNd("unable to compile macro"))
-			c.ErrorCount++
+			prev = &TextNode{
+				Position: n.Position,
+				Text:     make([]machine.Word, n.Size),
+				Address:  addr,
+			}
+			ns[i] = prev
+			if !overflow && textEnd(prev) > 0x10000 {
+				overflow = true
+				ns[i] = c.overflowed(n, addr)
+			}
+			continue
+		case *CommentNode:
+			addr := textEnd(prev)
+			if prev != nil && prev.File == n.File && prev.Line == n.Line {
+				addr = prev.Address // trailing comment
+			}
+			c.Comments[addr] = n
+		case *LabelNode:
+		default:
 			continue
 		}
-		c.Macros[name] = fm
 		ns = setNode(ns, i)
+		i--
 	}
 	return ns
 }
 
-// collectSymbols populates compiler state with symbol definitions.
-func (c *Compiler) collectSymbols(ns []Node) []Node {
-	return nil // TODO
+// qualifyLocals prefixes local label definitions and references with
+// the name of the nearest preceding global label, e.g. `_loop` following
+// `:fib` becomes `fib._loop`.
+func (c *Compiler) qualifyLocals(ns []Node) {
+	scope := ""
+	for _, nd := range ns {
+		switch n := nd.(type) {
+		case *LabelNode:
+			if !localp(n.Name) {
+				scope = n.Name
+				continue
+			}
+			if scope == "" {
+				c.warnf(n, diag.Scope, "local label %s outside "+
+					"of any global label scope", n.Name)
+			}
+			n.Name = qualify(scope, n.Name)
+		case *TextNode:
+			for i := range n.Symbols {
+				r := &n.Symbols[i]
+				if r.Expr == nil {
+					r.Name = qualify(scope, r.Name)
+					continue
+				}
+				walkSymbols(r.Expr, func(s *SymbolNode) {
+					s.Name = qualify(scope, s.Name)
+				})
+				r.Name = exprString(r.Expr)
+			}
+		}
+	}
 }
 
-// mkMacroExpander returns function which takes slice of len(operands) nodes
-// and returning body with each symbol in operands replaced by corresponding
-// node from args.
-func mkMacroExpander(
-	operands []*SymbolNode,
-	body []Node,
-) TranslatorFunc {
-	indices := make([][]int, len(operands)) // [operandIndex][_]bodyPos
-	for i, n := range body {
-		sym, ok := n.(*SymbolNode)
-		if !ok {
-			continue
-		}
-		for j, o := range operands {
-			if sym.Name == o.Name {
-				indices[j] = append(indices[j], i)
-				break
+// overflowed returns an error reporting that text of nd at addr moves
+// location counter past the end of memory.
+func (c *Compiler) overflowed(nd Node, addr int) *ErrorNode {
+	return coded(diag.Range, mkErrorNodef(nd)(
+		"text at %#x runs past the end of memory", addr))
+}
+
+// redefined returns an error reporting redefinition of symbol name by nd.
+func (c *Compiler) redefined(name string, nd Node) *ErrorNode {
+	prev := c.defs[name].Pos()
+	return coded(diag.Redefined, mkErrorNodef(nd)(
+		"%s redefined, previous definition at %v", name, &prev))
+}
+
+// evalSpec returns value of the symbol reference r.
+func (c *Compiler) evalSpec(r SymbolSpec) (int, []string, *ErrorNode) {
+	if r.Expr != nil {
+		return eval(r.Expr, c.Symbols)
+	}
+	if v, ok := c.Symbols[r.Name]; ok {
+		return v, nil, nil
+	}
+	return 0, []string{r.Name}, nil
+}
+
+// assignAddresses does a single address assignment pass and reports
+// whether symbol values stayed the same.
+func (c *Compiler) assignAddresses(ns []Node) (settled bool) {
+	settled = true
+	addr := 0
+	for _, nd := range ns {
+		switch n := nd.(type) {
+		case *LabelNode:
+			if v, ok := c.Symbols[n.Name]; !ok || v != addr {
+				c.Symbols[n.Name] = addr
+				settled = false
+			}
+		case *TextNode:
+			n.Address = addr
+			addr += len(n.Text)
+		case *OriginNode:
+			n.Size = n.Origin - addr
+			if n.Align != 0 {
+				n.Size = (n.Align - addr%n.Align) % n.Align
 			}
+			if n.Size < 0 {
+				n.Size = 0
+			}
+			addr += n.Size
 		}
 	}
-	return func(args []Node) []Node {
-		if len(args) != len(operands) {
-			panic("wrong number of arguments")
+	for name, x := range c.consts {
+		v, missing, err := eval(x, c.Symbols)
+		if err != nil || missing != nil {
+			continue
 		}
-		body := append([]Node{}, body...)
-		for i, pos := range indices {
-			for _, n := range pos {
-				body[n] = args[i]
-			}
+		if old, ok := c.Symbols[name]; !ok || old != v {
+			c.Symbols[name] = v
+			settled = false
 		}
-		return body
 	}
+	return settled
 }
 
 // mkErrorNodef creates a function which takes format args and returns an
@@ -275,18 +601,26 @@ func mkMacroExpander(
 func mkErrorNodef(datum Node) func(string, ...interface{}) *ErrorNode {
 	return func(msg string, args ...interface{}) *ErrorNode {
 		return &ErrorNode{
-			datum.Pos(),
-			fmt.Sprintf(msg, args),
-			datum,
+			Position: datum.Pos(),
+			Message:  fmt.Sprintf(msg, args...),
+			Datum:    datum,
 		}
 	}
 }
 
+// coded sets diagnostic code of err unless it already has one.
+func coded(code string, err *ErrorNode) *ErrorNode {
+	if err.Code == "" {
+		err.Code = code
+	}
+	return err
+}
+
 // setNode function replaces slice[n] with nodes or, when no nodes
 // are supplied, deletes slice[n] from slice.
 func setNode(slice []Node, n int, nodes ...Node) []Node {
 	if len(nodes) == 0 {
-		copy(slice[:n], slice[:n+1])
+		copy(slice[n:], slice[n+1:])
 		return slice[:len(slice)-1]
 	}
 	if len(nodes) == 1 { // no need to extend the slice in this case
@@ -297,10 +631,48 @@ func setNode(slice []Node, n int, nodes ...Node) []Node {
 	}
 }
 
+// textEnd returns address following text, or zero if text is nil.
+func textEnd(text *TextNode) int {
+	if text == nil {
+		return 0
+	}
+	return text.Address + len(text.Text)
+}
+
+// localp reports whether name is a local label name.
+func localp(name string) bool {
+	return strings.HasPrefix(name, "_")
+}
+
+// qualify returns local name prefixed with scope. Global names and names
+// outside of any scope are returned as is.
+func qualify(scope, name string) string {
+	if scope == "" || !localp(name) {
+		return name
+	}
+	return scope + "." + name
+}
+
+// specFits reports whether val fits into bits defined by spec. Signed values
+// must fit as two's complement, others may be either signed or unsigned.
+func specFits(spec ByteSpec, val int, signed bool) bool {
+	if spec.Size == 0 || spec.Size >= 16 {
+		return val >= -0x8000 && val <= 0xffff
+	}
+	lo, hi := -1<<(spec.Size-1), 1<<spec.Size-1
+	if signed {
+		hi = 1<<(spec.Size-1) - 1
+	}
+	return val >= lo && val <= hi
+}
+
 // setSpec replaces range of bits in text defined by spec with those of val.
 func setSpec(text []machine.Word, spec ByteSpec, val machine.Word) {
 	mask := machine.Word(1<<spec.Size - 1)
+	if spec.Size == 0 {
+		mask = 0xffff
+	}
 	val &= mask
-	text[spec.Offset] &^= mask
-	text[spec.Offset] &= val << uint(spec.Position)
+	text[spec.Offset] &^= mask << uint(spec.Position)
+	text[spec.Offset] |= val << uint(spec.Position)
 }
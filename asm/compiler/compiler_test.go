@@ -0,0 +1,826 @@
+package compiler_test
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/niksaak/rhmrm/asm/compiler"
+	"github.com/niksaak/rhmrm/asm/diag"
+	"github.com/niksaak/rhmrm/asm/lexer"
+	"github.com/niksaak/rhmrm/asm/parser"
+	"github.com/niksaak/rhmrm/machine"
+)
+
+// convenient abbreviations
+var i1, i2 = machine.WMkInstruction1, machine.WMkInstruction2
+
+// parse returns clauses of src, reporting every parse error to test runner.
+func parse(t *testing.T, src string) []compiler.Node {
+	eh := func(p lexer.Position, msg string) {
+		t.Errorf("%v: %s", &p, msg)
+	}
+	l := new(lexer.Lexer).Init([]byte(src), "", eh)
+	p := new(parser.Parser).Init(l)
+	prog := p.ParseProgram().(*compiler.ProgramNode)
+	if n := p.ErrorCount(); n > 0 {
+		t.Fatalf("got %d parse errors in %q", n, src)
+	}
+	return prog.Clauses
+}
+
+// translate translates a single instruction with c.
+func translate(t *testing.T, c *compiler.Compiler, src string) []compiler.Node {
+	n, ok := parse(t, src)[0].(*compiler.InstructionNode)
+	if !ok {
+		t.Fatalf("%q is not an instruction", src)
+	}
+	fn := c.InstructionMk(n.Op)
+	if fn == nil {
+		t.Fatalf("no translator for %q", n.Op)
+	}
+	return fn(n.Operands)
+}
+
+func TestInstructionMk(t *testing.T) {
+	t.Parallel()
+	c := new(compiler.Compiler).Init(0)
+	checks := []struct {
+		src  string
+		text []machine.Word
+	}{
+		{"mov r1, r2", []machine.Word{i2(machine.OP_MOV, 1, 2)}},
+		{"add t0, v1", []machine.Word{
+			i2(machine.OP_ADD, machine.T+0, machine.V+1)}},
+		{"inc a0, 3", []machine.Word{
+			i2(machine.OP_INC, machine.A+0, 3)}},
+		{"mtc &fl, r1", []machine.Word{
+			i2(machine.OP_MTC, machine.AM_AND<<3|machine.FL, 1)}},
+		{"mtc ia, r1", []machine.Word{
+			i2(machine.OP_MTC, machine.IA, 1)}},
+		{"mfc r2, ^ex", []machine.Word{
+			i2(machine.OP_MFC, 2, machine.AM_XOR<<3|machine.EX)}},
+		{"inc a0, -1", []machine.Word{
+			i2(machine.OP_INC, machine.A+0, -1)}},
+		{"imp mov r1, 1<<12 | 0x0f", []machine.Word{
+			i2(machine.OP_IMP, machine.IMP_MOV, 1), 0x100f}},
+		{"hwi 9", []machine.Word{i1(machine.OP_HWI, 9)}},
+		{"imp mov a0, 9", []machine.Word{
+			i2(machine.OP_IMP, machine.IMP_MOV, machine.A+0), 9}},
+		{"imp mtc |fl, 0x8000", []machine.Word{
+			i2(machine.OP_IMP, machine.IMP_MTC,
+				machine.AM_IOR<<3|machine.FL), 0x8000}},
+		{"imp brk", []machine.Word{
+			i2(machine.OP_IMP, machine.IMP_BRK, 0)}},
+	}
+	for _, ck := range checks {
+		ns := translate(t, c, ck.src)
+		if len(ns) != 1 {
+			t.Errorf("%q: got %d nodes, want 1", ck.src, len(ns))
+			continue
+		}
+		text, ok := ns[0].(*compiler.TextNode)
+		if !ok {
+			t.Errorf("%q: got %v, want text", ck.src, ns[0])
+			continue
+		}
+		if len(text.Text) != len(ck.text) {
+			t.Errorf("%q: got %v, want %v", ck.src, text.Text, ck.text)
+			continue
+		}
+		for i := range ck.text {
+			if text.Text[i] != ck.text[i] {
+				t.Errorf("%q: got %v, want %v",
+					ck.src, text.Text, ck.text)
+				break
+			}
+		}
+	}
+}
+
+func TestInstructionMkSymbols(t *testing.T) {
+	t.Parallel()
+	c := new(compiler.Compiler).Init(0)
+	text := translate(t, c, "jne _ret")[0].(*compiler.TextNode)
+	if len(text.Symbols) != 1 || !text.Symbols[0].Relative {
+		t.Errorf("jne _ret: got symbols %v, want one relative",
+			text.Symbols)
+	}
+	text = translate(t, c, "jgt 7")[0].(*compiler.TextNode)
+	if len(text.Symbols) != 1 || !text.Symbols[0].Relative {
+		t.Errorf("jgt 7: got symbols %v, want one relative",
+			text.Symbols)
+	}
+	text = translate(t, c, "imp srl ra, fib")[0].(*compiler.TextNode)
+	if len(text.Symbols) != 1 || text.Symbols[0].Offset != 1 {
+		t.Errorf("imp srl ra, fib: got symbols %v, want one at 1",
+			text.Symbols)
+	}
+}
+
+func TestInstructionMkErrors(t *testing.T) {
+	t.Parallel()
+	c := new(compiler.Compiler).Init(0)
+	if fn := c.InstructionMk("mvo"); fn != nil {
+		t.Errorf("got translator for unknown instruction mvo")
+	}
+	for src, want := range map[string]string{
+		"mov r1":         "wrong number of operands",
+		"mov r1, ia":     "expected general register: ia",
+		"mtc r1, r2":     "expected control register: r1",
+		"mtc &fl, ^ia":   "expected general register: ^ia",
+		"inc r1, 32":     "integer out of range",
+		"imp foo r1, 2":  "unknown IMP subopcode: foo",
+		"imp mov r1":     "wrong number of operands",
+		"imp mov r1, r2": "expected integer or symbol: r2",
+		"mov r1, \"s\"":  "expected integer or symbol: \"s\"",
+	} {
+		ns := translate(t, c, src)
+		e, ok := ns[0].(*compiler.ErrorNode)
+		if !ok {
+			t.Errorf("%q: got %v, want error", src, ns[0])
+			continue
+		}
+		if !strings.Contains(e.Message, want) {
+			t.Errorf("%q: got error %q, want one mentioning %q",
+				src, e.Message, want)
+		}
+	}
+}
+
+// compile compiles src with a fresh compiler.
+func compile(t *testing.T, src string) (*compiler.Compiler, []machine.Word, error) {
+	c := new(compiler.Compiler).Init(0)
+	text, err := c.Compile(parse(t, src))
+	return c, text, err
+}
+
+// run loads text into a fresh machine in supervisor mode and runs it
+// until the first interrupt, but no more than stepMax steps.
+func run(text []machine.Word, stepMax int) *machine.Machine {
+	m := new(machine.Machine)
+	(*machine.FlagsRegister)(m.C(machine.FL)).SetS(true)
+	m.Load(text)
+	m.Run(context.Background(),
+		machine.RunOptions{Steps: uint64(stepMax)})
+	return m
+}
+
+var fib = `
+;;;; Startup
+    imp mov a0, 9
+    imp srl ra, fib
+        hwi 9
+
+;;;; Fibonacci function
+:fib    mov v0, zr
+        mov t0, zr
+    imp mov v1, 1
+        cmp a0, zr
+        jeq _ret
+:_loop  mov t0, v0
+        add t0, v1
+        mov v0, v1
+        mov v1, t0
+    imp sub a0, 1
+        cmp a0, zr
+        jgt _loop
+:_ret   srl zr, ra
+`
+
+func TestCompileFib(t *testing.T) {
+	t.Parallel()
+	c, text, err := compile(t, fib)
+	if err != nil {
+		t.Fatalf("compile error: %v", err)
+	}
+	for name, want := range map[string]int{
+		"fib": 5, "fib._loop": 11, "fib._ret": 19,
+	} {
+		if v := c.Symbols[name]; v != want {
+			t.Errorf("%s is %d, want %d", name, v, want)
+		}
+	}
+	m := run(text, 200)
+	if ret := *m.R(machine.V + 0); ret != 34 {
+		t.Errorf("fib(9) returns %d, want %d", ret, 34)
+	}
+}
+
+func TestCompileSymbolErrors(t *testing.T) {
+	t.Parallel()
+	for _, src := range []string{
+		"jmp nowhere\n",
+		":foo mov r1, r2\n:foo mov r2, r1\n",
+	} {
+		c, _, err := compile(t, src)
+		if err == nil || c.ErrorCount() == 0 {
+			t.Errorf("%q: compiled without errors", src)
+		}
+	}
+}
+
+func TestCompileLocalLabels(t *testing.T) {
+	t.Parallel()
+	src := `
+:foo    jmp _ret
+:_ret   srl zr, ra
+:bar    jmp _ret
+        mov r1, r2
+:_ret   srl zr, ra
+`
+	c, _, err := compile(t, src)
+	if err != nil {
+		t.Fatalf("compile error: %v", err)
+	}
+	for name, want := range map[string]int{"foo._ret": 1, "bar._ret": 4} {
+		if v, ok := c.Symbols[name]; !ok || v != want {
+			t.Errorf("%s is %d, want %d", name, v, want)
+		}
+	}
+	if _, ok := c.Symbols["_ret"]; ok {
+		t.Errorf("local label _ret is defined globally")
+	}
+	_, _, err = compile(t, ":fib jmp _loop\n")
+	if err == nil || !strings.Contains(err.Error(), "fib._loop") {
+		t.Errorf("got error %v, want one mentioning fib._loop", err)
+	}
+}
+
+func TestCompileDataDirectives(t *testing.T) {
+	t.Parallel()
+	src := `
+        .word 1, table, 0xbeef
+:table  .string "Hi!"
+        .asciz "ab"
+        .fill 2, 7
+        .align 4
+        .org 12
+        .word table
+`
+	_, text, err := compile(t, src)
+	if err != nil {
+		t.Fatalf("compile error: %v", err)
+	}
+	want := []machine.Word{
+		1, 3, 0xbeef,
+		'H' | 'i'<<8, '!',
+		'a' | 'b'<<8, 0,
+		7, 7,
+		0, 0, 0,
+		3,
+	}
+	if len(text) != len(want) {
+		t.Fatalf("got %v, want %v", text, want)
+	}
+	for i := range want {
+		if text[i] != want[i] {
+			t.Errorf("word %d is %v, want %v", i, text[i], want[i])
+		}
+	}
+}
+
+func TestCompileOrgBackwards(t *testing.T) {
+	t.Parallel()
+	_, _, err := compile(t, ".fill 4\n.org 2\n")
+	if err == nil {
+		t.Errorf("compiled .org moving backwards without errors")
+	}
+}
+
+func TestCompileOverflow(t *testing.T) {
+	t.Parallel()
+	for src, want := range map[string]string{
+		".org 0x10000\n":                       "out of range",
+		".fill 0x10001\n":                      "out of range",
+		".fill 0x10000\n.word 1\n":             "text at 0x10000 runs past",
+		".org 0xfffe\n.word 1, 2, 3\n":         "text at 0xfffe runs past",
+		".org 0xfff0\n.fill 0x11\n.word 1\n":   "text at 0xfff0 runs past",
+		".word 1\n.align 0x10000\n.word 1\n":   "text at 0x10000 runs past",
+		".org 0xffff\n.word 1\n:end .word 2\n": "text at 0x10000 runs past",
+	} {
+		_, _, err := compile(t, src)
+		if err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("%q: got error %v, want one mentioning %q",
+				src, err, want)
+		}
+	}
+	_, text, err := compile(t, ".org 0xfffe\n.word 1, 2\n:end\n")
+	if err != nil || len(text) != 0x10000 || text[0xffff] != 2 {
+		t.Errorf("got %d words and error %v filling memory", len(text), err)
+	}
+}
+
+func TestCompileExpressions(t *testing.T) {
+	t.Parallel()
+	src := `
+:start  .word table+4, (end-start)/2, 1<<12, -table, ~0 & 0xff
+:table  .word 2*3+1, (2+3)*-1
+:end    jmp _x-1
+:_x     jmp start+(end-start)/7
+`
+	_, text, err := compile(t, src)
+	if err != nil {
+		t.Fatalf("compile error: %v", err)
+	}
+	want := []machine.Word{
+		9, 3, 0x1000, 0xfffb, 0xff,
+		7, 0xfffb,
+		i1(machine.OP_JMP, 0), i1(machine.OP_JMP, -7),
+	}
+	if len(text) != len(want) {
+		t.Fatalf("got %v, want %v", text, want)
+	}
+	for i := range want {
+		if text[i] != want[i] {
+			t.Errorf("word %d is %v, want %v", i, text[i], want[i])
+		}
+	}
+}
+
+func TestCompileJumps(t *testing.T) {
+	t.Parallel()
+	src := `
+.equ THREE, 3
+:start  jmp THREE
+        jne start
+        jgt end
+        jmp 2+1
+:end    jle THREE-start
+        jeq LATER
+.equ LATER, end+1
+`
+	_, text, err := compile(t, src)
+	if err != nil {
+		t.Fatalf("compile error: %v", err)
+	}
+	want := []machine.Word{
+		i1(machine.OP_JMP, 3), i1(machine.OP_JNE, -1),
+		i1(machine.OP_JGT, 2), i1(machine.OP_JMP, 0),
+		i1(machine.OP_JLE, -1), i1(machine.OP_JEQ, 0),
+	}
+	if len(text) != len(want) {
+		t.Fatalf("got %v, want %v", text, want)
+	}
+	for i := range want {
+		if text[i] != want[i] {
+			t.Errorf("word %d is %v, want %v", i, text[i], want[i])
+		}
+	}
+	for _, src := range []string{"jmp 512\n", ".fill 600\njmp 0\n"} {
+		_, _, err := compile(t, src)
+		if err == nil || !strings.Contains(err.Error(), "out of range") {
+			t.Errorf("%q: got error %v, want one out of range", src, err)
+		}
+	}
+}
+
+func TestCompileExpressionErrors(t *testing.T) {
+	t.Parallel()
+	for _, src := range []string{
+		".word 1/0\n",
+		".word foo/(bar-bar)\n:foo\n:bar\n",
+		".fill foo\n:foo\n",
+		"inc r1, 1<<5\n",
+		".word r1+1\n",
+	} {
+		if _, _, err := compile(t, src); err == nil {
+			t.Errorf("%q: compiled without errors", src)
+		}
+	}
+}
+
+func TestCompileConstants(t *testing.T) {
+	t.Parallel()
+	src := `
+.equ MSG, 9
+.set SCRATCH, T+2     ; forward reference to another constant
+.equ T, 10
+.equ MOV, 1
+.equ SIZE, end-start  ; depends on labels
+:start  mov SCRATCH, r1
+        inc r1, MSG-10
+    imp MOV r2, MSG<<8
+        .fill MSG-7, SIZE
+        hwi MSG
+:end
+`
+	c, text, err := compile(t, src)
+	if err != nil {
+		t.Fatalf("compile error: %v", err)
+	}
+	if v := c.Symbols["SCRATCH"]; v != 12 {
+		t.Errorf("SCRATCH is %d, want %d", v, 12)
+	}
+	want := []machine.Word{
+		i2(machine.OP_MOV, 12, 1),
+		i2(machine.OP_INC, 1, -1),
+		i2(machine.OP_IMP, machine.IMP_MOV, 2), 0x900,
+		7, 7,
+		i1(machine.OP_HWI, 9),
+	}
+	if len(text) != len(want) {
+		t.Fatalf("got %v, want %v", text, want)
+	}
+	for i := range want {
+		if text[i] != want[i] {
+			t.Errorf("word %d is %v, want %v", i, text[i], want[i])
+		}
+	}
+}
+
+func TestCompileLocalConstants(t *testing.T) {
+	t.Parallel()
+	src := `
+:fib    mov r1, r2
+        mov r2, r1
+:_end
+.equ FIB, _end-fib
+:bar    .word FIB
+        .word BAR
+:_end
+.set BAR, _end-fib
+`
+	c, text, err := compile(t, src)
+	if err != nil {
+		t.Fatalf("compile error: %v", err)
+	}
+	if v := c.Symbols["FIB"]; v != 2 {
+		t.Errorf("FIB is %d, want 2", v)
+	}
+	if len(text) != 4 || text[2] != 2 || text[3] != 4 {
+		t.Errorf("got %v, want data words 2 and 4", text)
+	}
+}
+
+func TestCompileConstantErrors(t *testing.T) {
+	t.Parallel()
+	_, _, err := compile(t, ".equ FOO, 1\n.equ FOO, 2\n")
+	if err == nil || !strings.Contains(err.Error(), "at 1:") {
+		t.Errorf("got error %v, want one mentioning the first definition",
+			err)
+	}
+	for _, src := range []string{
+		":FOO\n.equ FOO, 2\n",
+		".equ FOO, BAR\n",
+		".equ FOO\n",
+		".equ FOO, 1/0\n",
+	} {
+		if _, _, err := compile(t, src); err == nil {
+			t.Errorf("%q: compiled without errors", src)
+		}
+	}
+}
+
+// writeFiles creates files with contents in a temporary directory and
+// returns its name.
+func writeFiles(t *testing.T, files map[string]string) string {
+	dir := t.TempDir()
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+// compileFile compiles file path with includes searched in dirs.
+func compileFile(t *testing.T, path string, dirs ...string) (*compiler.Compiler, []machine.Word, error) {
+	src, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := new(compiler.Compiler).Init(0)
+	c.IncludeDirs = dirs
+	c.Parse = parser.ParseFile
+	text, err := c.Compile(parser.ParseFile(src, path))
+	return c, text, err
+}
+
+func TestCompileInclude(t *testing.T) {
+	t.Parallel()
+	dir := writeFiles(t, map[string]string{
+		"main.s": `
+:main   imp srl ra, util
+        hwi MSG
+        .include "lib/util.s"
+`,
+		"lib/util.s": `
+        .include "consts.s"   ; found in include dirs
+:util   imp mov v0, MSG
+        srl zr, ra
+`,
+		"inc/consts.s": ".equ MSG, 9\n",
+	})
+	_, text, err := compileFile(t, filepath.Join(dir, "main.s"),
+		filepath.Join(dir, "inc"))
+	if err != nil {
+		t.Fatalf("compile error: %v", err)
+	}
+	m := run(text, 20)
+	if v := *m.R(machine.V + 0); v != 9 {
+		t.Errorf("v0 is %d, want %d", v, 9)
+	}
+}
+
+func TestCompileIncbin(t *testing.T) {
+	t.Parallel()
+	dir := writeFiles(t, map[string]string{
+		"main.s": `
+        .incbin "font.bin"
+        .incbin "font.bin", big
+`,
+		"font.bin": "\x01\x02\x03",
+	})
+	_, text, err := compileFile(t, filepath.Join(dir, "main.s"))
+	if err != nil {
+		t.Fatalf("compile error: %v", err)
+	}
+	want := []machine.Word{0x0201, 0x0003, 0x0102, 0x0300}
+	if len(text) != len(want) {
+		t.Fatalf("got %v, want %v", text, want)
+	}
+	for i := range want {
+		if text[i] != want[i] {
+			t.Errorf("word %d is %v, want %v", i, text[i], want[i])
+		}
+	}
+}
+
+func TestCompileIncludeErrors(t *testing.T) {
+	t.Parallel()
+	dir := writeFiles(t, map[string]string{
+		"self.s":   ".include \"self.s\"\n",
+		"a.s":      ".include \"b.s\"\n",
+		"b.s":      ".include \"a.s\"\n",
+		"main.s":   ".include \"bad.s\"\n",
+		"bad.s":    "\n\nmvo r1, r2\n",
+		"none.s":   ".include \"nonexistent.s\"\n",
+		"nobin.s":  ".incbin \"nonexistent.bin\"\n",
+		"order.s":  ".incbin \"main.s\", middle\n",
+		"notstr.s": ".include 42\n",
+	})
+	for name, want := range map[string]string{
+		"self.s":   "include cycle",
+		"a.s":      "include cycle",
+		"main.s":   "bad.s:3:1",
+		"none.s":   "file not found",
+		"nobin.s":  "file not found",
+		"order.s":  "word order",
+		"notstr.s": "expected file name",
+	} {
+		_, _, err := compileFile(t, filepath.Join(dir, name))
+		if err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("%s: got error %v, want one mentioning %q",
+				name, err, want)
+		}
+	}
+}
+
+// compileWith compiles src with a fresh compiler with predefined symbols.
+func compileWith(t *testing.T, src string, syms map[string]int) ([]machine.Word, error) {
+	c := new(compiler.Compiler).Init(0)
+	for k, v := range syms {
+		c.Symbols[k] = v
+	}
+	return c.Compile(parse(t, src))
+}
+
+var conditionals = `
+.if DEBUG {
+        .equ LEVEL, 2
+}
+.elif VERBOSE {
+        .equ LEVEL, 1
+}
+.else {
+        .equ LEVEL, 0
+}
+        .word LEVEL
+.ifdef TRACE
+        .word 0x7ace
+.if LEVEL & 2
+        .word 0xdeb9
+.else
+        .word 0xf00d
+.endif
+.endif
+.ifndef TRACE {
+        .word 0
+}
+`
+
+func TestCompileConditionals(t *testing.T) {
+	t.Parallel()
+	checks := []struct {
+		syms map[string]int
+		want []machine.Word
+	}{
+		{map[string]int{"DEBUG": 1}, []machine.Word{2, 0}},
+		{map[string]int{"DEBUG": 0, "VERBOSE": 1}, []machine.Word{1, 0}},
+		{map[string]int{"DEBUG": 0, "VERBOSE": 0, "TRACE": 1},
+			[]machine.Word{0, 0x7ace, 0xf00d}},
+		{map[string]int{"DEBUG": 1, "TRACE": 1},
+			[]machine.Word{2, 0x7ace, 0xdeb9}},
+	}
+	for _, ck := range checks {
+		text, err := compileWith(t, conditionals, ck.syms)
+		if err != nil {
+			t.Errorf("%v: compile error: %v", ck.syms, err)
+			continue
+		}
+		if len(text) != len(ck.want) {
+			t.Errorf("%v: got %v, want %v", ck.syms, text, ck.want)
+			continue
+		}
+		for i := range ck.want {
+			if text[i] != ck.want[i] {
+				t.Errorf("%v: got %v, want %v",
+					ck.syms, text, ck.want)
+				break
+			}
+		}
+	}
+}
+
+func TestCompileConditionalLabels(t *testing.T) {
+	t.Parallel()
+	src := `
+:foo
+.ifdef foo {
+	.word 1
+}
+:_x
+.ifdef _x {
+	.word 2
+}
+.ifdef bar {
+	.word 3
+}
+:bar
+.ifndef baz {
+	.word 4
+}
+:baz
+.ifdef _x {
+	.word 5
+}
+`
+	text, err := compileWith(t, src, nil)
+	if err != nil {
+		t.Fatalf("compile error: %v", err)
+	}
+	want := []machine.Word{1, 2, 4}
+	if len(text) != len(want) {
+		t.Fatalf("got %v, want %v", text, want)
+	}
+	for i := range want {
+		if text[i] != want[i] {
+			t.Errorf("word %d is %v, want %v", i, text[i], want[i])
+		}
+	}
+}
+
+func TestCompileConditionalErrors(t *testing.T) {
+	t.Parallel()
+	for src, want := range map[string]string{
+		".if DEBUG {\n}\n":                "undefined symbols in condition: DEBUG",
+		".if 1\n.word 1\n":                "missing .endif",
+		".else\n":                         "misplaced .else",
+		".if 1\n.else\n.elif 1\n.endif\n": ".elif after .else",
+		".if 1 {\n}\n.else\n":             "missing block after .else",
+		".ifdef 1\n.endif\n":              "expected symbol",
+		".if 1, 2\n.endif\n":              "wrong number of operands",
+	} {
+		_, err := compileWith(t, src, nil)
+		if err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("%q: got error %v, want one mentioning %q",
+				src, err, want)
+		}
+	}
+}
+
+var macros = `
+.macro pair a, b=7 {
+	.word a, b + 1
+}
+.macro words first, rest... {
+	.word first * 2, rest
+}
+.macro here {
+	:_here .word _here
+}
+.macro pick x {
+	.if x {
+		.word 0xaa
+	}
+	.else {
+		.word 0xcc
+	}
+}
+:start
+	pair 1, 2
+	pair 3
+	words 4
+	words 5, 6, 7
+	here
+	here
+	pick 1
+	pick 0
+`
+
+func TestCompileMacros(t *testing.T) {
+	t.Parallel()
+	want := []machine.Word{1, 3, 3, 8, 8, 10, 6, 7, 8, 9, 0xaa, 0xcc}
+	_, text, err := compile(t, macros)
+	if err != nil {
+		t.Fatalf("compile error: %v", err)
+	}
+	if len(text) != len(want) {
+		t.Fatalf("got %v, want %v", text, want)
+	}
+	for i := range want {
+		if text[i] != want[i] {
+			t.Fatalf("got %v, want %v", text, want)
+		}
+	}
+}
+
+func TestCompileMacroErrors(t *testing.T) {
+	t.Parallel()
+	for src, want := range map[string]string{
+		".macro m a {\n}\nm\n":                    "macro m: wrong number of arguments: have 0, want 1",
+		".macro m a, b=1 {\n}\nm 1, 2, 3\n":       "want 1 to 2",
+		".macro m a, b... {\n}\nm\n":              "want at least 1",
+		".macro m a... , b {\n}\n":                "variadic parameter a is not the last one",
+		".macro m a=1, b {\n}\n":                  "required parameter b follows optional ones",
+		".macro m a, a {\n}\n":                    "duplicate macro parameter: a",
+		".macro m 1 {\n}\n":                       "bad macro parameter: 1",
+		".macro m a\n":                            "missing macro body",
+		".macro m {\n}\n.macro m {\n}\n":          "macro already defined: m",
+		".macro m a... {\n.word a+1\n}\nm 1, 2\n": "variadic parameter a used as a single value",
+		".macro m {\nm\n}\nm\n":                   "macro recursion too deep: m",
+	} {
+		_, _, err := compile(t, src)
+		if err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("%q: got error %v, want one mentioning %q",
+				src, err, want)
+		}
+	}
+}
+
+func TestCompileDiagnostics(t *testing.T) {
+	t.Parallel()
+	src := ":_orphan mov r1, r2\n" +
+		":start\tmvo r1, r2\n" +
+		"\t.word 1 / 0\n" +
+		"\tjmp nowhere\n" +
+		"\t.word 1q\n"
+	c := new(compiler.Compiler).Init(0)
+	_, err := c.Compile(parser.ParseFile([]byte(src), "a.s"))
+	l, ok := err.(diag.List)
+	if !ok {
+		t.Fatalf("got error %#v, want diag.List", err)
+	}
+	want := []struct {
+		line, col int
+		sev       diag.Severity
+		code, msg string
+	}{
+		{1, 1, diag.Warning, diag.Scope, "local label _orphan"},
+		{2, 8, diag.Error, diag.Unknown, "unknown instruction: mvo"},
+		{3, 10, diag.Error, diag.Invalid, "division by zero"},
+		{4, 2, diag.Error, diag.Undefined, "unresolved symbols: nowhere"},
+		{5, 8, diag.Error, diag.Syntax, "bad integer: 1q"},
+	}
+	if len(l) != len(want) {
+		t.Fatalf("got diagnostics:\n%v", l)
+	}
+	for i, w := range want {
+		d := l[i]
+		if d.Pos.File != "a.s" || d.Pos.Line != w.line ||
+			d.Pos.Column != w.col || d.Severity != w.sev ||
+			d.Code != w.code || !strings.Contains(d.Message, w.msg) {
+			t.Errorf("got %v (%s), want %d:%d: %v: %s (%s)",
+				d, d.Code, w.line, w.col, w.sev, w.msg, w.code)
+		}
+	}
+	if len(c.Diagnostics) != len(want) {
+		t.Errorf("compiler keeps %d diagnostics, want %d",
+			len(c.Diagnostics), len(want))
+	}
+	// warnings alone are not errors
+	c = new(compiler.Compiler).Init(0)
+	_, err = c.Compile(parse(t, ":_x mov r1, r2\n"))
+	if err != nil || len(c.Diagnostics) != 1 {
+		t.Errorf("got error %v and diagnostics %v, want one warning",
+			err, c.Diagnostics)
+	}
+}
@@ -0,0 +1,163 @@
+package compiler
+
+import (
+	"strings"
+
+	"github.com/niksaak/rhmrm/asm/diag"
+)
+
+// branch is a single branch of a conditional.
+type branch struct {
+	cond *DirectiveNode // .if, .ifdef, .ifndef, .elif or .else
+	body []Node
+}
+
+// conditional evaluates conditional starting at ns[i] and returns a copy
+// of the chosen branch clauses and index of the node following the
+// conditional. Conditionals come in two forms:
+//
+//	.if COND {          .if COND
+//	  ...                 ...
+//	}                   .elif COND
+//	.elif COND {          ...
+//	  ...               .else
+//	}                     ...
+//	.else {             .endif
+//	  ...
+//	}
+func (c *Compiler) conditional(ns []Node, i int) (body []Node, end int) {
+	branches, end, err := splitConditional(ns, i)
+	if err != nil {
+		return []Node{coded(diag.Conditional, err)}, end
+	}
+	for _, b := range branches {
+		ok, err := c.condition(b.cond)
+		if err != nil {
+			return []Node{coded(diag.Conditional, err)}, end
+		}
+		if ok {
+			return append([]Node{}, b.body...), end
+		}
+	}
+	return nil, end
+}
+
+// splitConditional splits conditional starting at ns[i] into branches.
+func splitConditional(ns []Node, i int) ([]branch, int, *ErrorNode) {
+	d := ns[i].(*DirectiveNode)
+	if blk := condBlock(d); blk != nil {
+		branches := []branch{{d, blk.Clauses}}
+		end := i + 1
+		for j := i + 1; j < len(ns); j++ {
+			if _, ok := ns[j].(*CommentNode); ok {
+				continue
+			}
+			n, ok := ns[j].(*DirectiveNode)
+			if !ok || (n.Op != "elif" && n.Op != "else") {
+				break
+			}
+			blk := condBlock(n)
+			if blk == nil {
+				return nil, j + 1, mkErrorNodef(n)(
+					"missing block after .%s", n.Op)
+			}
+			branches = append(branches, branch{n, blk.Clauses})
+			end = j + 1
+			if n.Op == "else" {
+				break
+			}
+		}
+		return branches, end, nil
+	}
+	branches := []branch{{cond: d}}
+	depth := 0
+	for j := i + 1; j < len(ns); j++ {
+		n, ok := ns[j].(*DirectiveNode)
+		switch {
+		case !ok:
+		case n.Op == "if" || n.Op == "ifdef" || n.Op == "ifndef":
+			if condBlock(n) == nil {
+				depth++
+			}
+		case depth > 0:
+			if n.Op == "endif" {
+				depth--
+			}
+		case n.Op == "endif":
+			return branches, j + 1, nil
+		case n.Op == "elif" || n.Op == "else":
+			if last := branches[len(branches)-1]; last.cond.Op == "else" {
+				return nil, j + 1, mkErrorNodef(n)(
+					".%s after .else", n.Op)
+			}
+			branches = append(branches, branch{cond: n})
+			continue
+		}
+		b := &branches[len(branches)-1]
+		b.body = append(b.body, ns[j])
+	}
+	return nil, len(ns), mkErrorNodef(d)("missing .endif")
+}
+
+// condBlock returns block of a block form conditional directive or nil.
+func condBlock(d *DirectiveNode) *BlockNode {
+	if len(d.Operands) == 0 {
+		return nil
+	}
+	blk, _ := d.Operands[len(d.Operands)-1].(*BlockNode)
+	return blk
+}
+
+// condition evaluates condition of a conditional directive. Conditions of
+// `.if` and `.elif` are constant expressions which are true when not zero,
+// `.ifdef` and `.ifndef` test whether a symbol or macro is defined. Labels
+// count as defined once they precede the conditional.
+func (c *Compiler) condition(d *DirectiveNode) (bool, *ErrorNode) {
+	operands := d.Operands
+	if condBlock(d) != nil {
+		operands = operands[:len(operands)-1]
+	}
+	if d.Op == "else" {
+		if err := operandCount(operands, 0, 0); err != nil {
+			return false, mkErrorNodef(d)("%s", err.Message)
+		}
+		return true, nil
+	}
+	if err := operandCount(operands, 1, 1); err != nil {
+		return false, mkErrorNodef(d)("%s", err.Message)
+	}
+	if d.Op == "ifdef" || d.Op == "ifndef" {
+		sym, ok := operands[0].(*SymbolNode)
+		if !ok {
+			return false, mkErrorNodef(operands[0])(
+				"expected symbol: %s", exprString(operands[0]))
+		}
+		return c.definedp(sym.Name) == (d.Op == "ifdef"), nil
+	}
+	v, missing, err := eval(operands[0], c.Symbols)
+	switch {
+	case err != nil:
+		return false, err
+	case missing != nil:
+		return false, coded(diag.Undefined, mkErrorNodef(operands[0])(
+			"undefined symbols in condition: %s",
+			strings.Join(missing, " ")))
+	}
+	return v != 0, nil
+}
+
+// definedp reports whether name is a defined symbol, constant or macro,
+// or a label preceding the conditional.
+func (c *Compiler) definedp(name string) bool {
+	if _, ok := c.Symbols[name]; ok {
+		return true
+	}
+	if c.labels[qualify(c.scope, name)] {
+		return true
+	}
+	if _, ok := c.defs[name]; ok {
+		return true
+	}
+	_, ok := c.Macros[name]
+	return ok
+}
@@ -0,0 +1,140 @@
+package compiler
+
+import (
+	"fmt"
+	"github.com/niksaak/rhmrm/asm/diag"
+	"github.com/niksaak/rhmrm/machine"
+	"strconv"
+)
+
+// directives returns translators of data directives.
+func (c *Compiler) directives() map[string]TranslatorFunc {
+	return map[string]TranslatorFunc{
+		"word":   c.dirWord,
+		"string": c.dirString,
+		"asciz":  c.dirString,
+		"fill":   c.dirFill,
+		"org":    c.dirOrg,
+		"align":  c.dirAlign,
+		"incbin": c.dirIncbin,
+	}
+}
+
+// dirWord translates `.word value {, value}` into a word per value.
+func (c *Compiler) dirWord(operands []Node) []Node {
+	text := &TextNode{Text: make([]machine.Word, len(operands))}
+	for i, o := range operands {
+		field := ByteSpec{Offset: i, Size: 16}
+		if err := text.encode(o, operand{wordOperand, field}, c.Symbols); err != nil {
+			return []Node{err}
+		}
+	}
+	return []Node{text}
+}
+
+// dirString translates `.string str {, str}` into zero-terminated strings
+// of octets packed two per word, the first one in the low octet.
+func (c *Compiler) dirString(operands []Node) []Node {
+	var octets []byte
+	for _, o := range operands {
+		s, ok := o.(*StringNode)
+		if !ok {
+			return []Node{mkErrorNodef(o)("expected string: %v", o)}
+		}
+		str, err := strconv.Unquote(`"` + s.Text + `"`)
+		if err != nil {
+			return []Node{mkErrorNodef(o)("bad string: %v", err)}
+		}
+		octets = append(octets, str...)
+		octets = append(octets, 0)
+	}
+	text := &TextNode{Text: make([]machine.Word, (len(octets)+1)/2)}
+	for i, b := range octets {
+		text.Text[i/2] |= machine.Word(b) << uint(i%2*8)
+	}
+	return []Node{text}
+}
+
+// dirFill translates `.fill count [, value]` into count words of value,
+// which is zero by default.
+func (c *Compiler) dirFill(operands []Node) []Node {
+	if err := operandCount(operands, 1, 2); err != nil {
+		return []Node{err}
+	}
+	count, err := c.integerOperand(operands[0], 0, 0x10000)
+	if err != nil {
+		return []Node{err}
+	}
+	text := &TextNode{Text: make([]machine.Word, count)}
+	if len(operands) == 1 {
+		return []Node{text}
+	}
+	for i := range text.Text {
+		field := ByteSpec{Offset: i, Size: 16}
+		err := text.encode(operands[1], operand{wordOperand, field},
+			c.Symbols)
+		if err != nil {
+			return []Node{err}
+		}
+	}
+	return []Node{text}
+}
+
+// dirOrg translates `.org address` into an OriginNode.
+func (c *Compiler) dirOrg(operands []Node) []Node {
+	if err := operandCount(operands, 1, 1); err != nil {
+		return []Node{err}
+	}
+	org, err := c.integerOperand(operands[0], 0, 0xffff)
+	if err != nil {
+		return []Node{err}
+	}
+	return []Node{&OriginNode{Position: operands[0].Pos(), Origin: org}}
+}
+
+// dirAlign translates `.align n` into an OriginNode aligning location
+// counter to a multiple of n words.
+func (c *Compiler) dirAlign(operands []Node) []Node {
+	if err := operandCount(operands, 1, 1); err != nil {
+		return []Node{err}
+	}
+	align, err := c.integerOperand(operands[0], 1, 0x10000)
+	if err != nil {
+		return []Node{err}
+	}
+	return []Node{&OriginNode{Position: operands[0].Pos(), Align: align}}
+}
+
+// operandCount checks that there are from min to max operands.
+func operandCount(operands []Node, min, max int) *ErrorNode {
+	if len(operands) >= min && len(operands) <= max {
+		return nil
+	}
+	want := fmt.Sprint(min)
+	if min != max {
+		want += fmt.Sprintf(" to %d", max)
+	}
+	err := &ErrorNode{Message: fmt.Sprintf(
+		"wrong number of operands: have %d, want %s",
+		len(operands), want)}
+	if len(operands) > 0 {
+		err.Position = operands[0].Pos()
+	}
+	return err
+}
+
+// integerOperand returns value of a constant expression in range [lo, hi].
+func (c *Compiler) integerOperand(nd Node, lo, hi int) (int, *ErrorNode) {
+	v, missing, err := eval(nd, c.Symbols)
+	switch {
+	case err != nil:
+		return 0, err
+	case missing != nil:
+		return 0, mkErrorNodef(nd)("expression is not constant: %s",
+			exprString(nd))
+	case v < lo || v > hi:
+		return 0, coded(diag.Range, mkErrorNodef(nd)(
+			"integer out of range [%d, %d]: %d", lo, hi, v))
+	}
+	return v, nil
+}
@@ -0,0 +1,118 @@
+package compiler
+
+import "fmt"
+
+// eval evaluates constant expression nd, looking symbols up in syms.
+// Names of symbols missing from syms are returned in missing, in which case
+// the value is meaningless.
+func eval(nd Node, syms map[string]int) (v int, missing []string, err *ErrorNode) {
+	errorNd := mkErrorNodef(nd)
+	switch n := nd.(type) {
+	case *IntegerNode:
+		return n.Value, nil, nil
+	case *SymbolNode:
+		v, ok := syms[n.Name]
+		if !ok {
+			return 0, []string{n.Name}, nil
+		}
+		return v, nil, nil
+	case *UnaryNode:
+		x, missing, err := eval(n.X, syms)
+		if err != nil || missing != nil {
+			return 0, missing, err
+		}
+		switch n.Op {
+		case "-":
+			return -x, nil, nil
+		case "+":
+			return x, nil, nil
+		case "~":
+			return ^x, nil, nil
+		}
+		return 0, nil, errorNd("unknown unary operator: %s", n.Op)
+	case *BinaryNode:
+		x, xmissing, err := eval(n.X, syms)
+		if err != nil {
+			return 0, nil, err
+		}
+		y, ymissing, err := eval(n.Y, syms)
+		if err != nil {
+			return 0, nil, err
+		}
+		if missing = append(xmissing, ymissing...); missing != nil {
+			return 0, missing, nil
+		}
+		return binary(n, x, y)
+	}
+	return 0, nil, errorNd("expected integer or symbol: %s", exprString(nd))
+}
+
+// binary applies binary operator of n to x and y.
+func binary(n *BinaryNode, x, y int) (int, []string, *ErrorNode) {
+	errorNd := mkErrorNodef(n)
+	switch n.Op {
+	case "+":
+		return x + y, nil, nil
+	case "-":
+		return x - y, nil, nil
+	case "*":
+		return x * y, nil, nil
+	case "/", "%":
+		if y == 0 {
+			return 0, nil, errorNd("division by zero")
+		}
+		if n.Op == "/" {
+			return x / y, nil, nil
+		}
+		return x % y, nil, nil
+	case "<<", ">>":
+		if y < 0 || y > 31 {
+			return 0, nil, errorNd("bad shift count: %d", y)
+		}
+		if n.Op == "<<" {
+			return x << uint(y), nil, nil
+		}
+		return x >> uint(y), nil, nil
+	case "&":
+		return x & y, nil, nil
+	case "|":
+		return x | y, nil, nil
+	case "^":
+		return x ^ y, nil, nil
+	}
+	return 0, nil, errorNd("unknown binary operator: %s", n.Op)
+}
+
+// walkSymbols calls fn for every symbol in expression nd.
+func walkSymbols(nd Node, fn func(*SymbolNode)) {
+	switch n := nd.(type) {
+	case *SymbolNode:
+		fn(n)
+	case *UnaryNode:
+		walkSymbols(n.X, fn)
+	case *BinaryNode:
+		walkSymbols(n.X, fn)
+		walkSymbols(n.Y, fn)
+	}
+}
+
+// exprString returns string representation of expression nd.
+func exprString(nd Node) string {
+	switch n := nd.(type) {
+	case *IntegerNode:
+		return fmt.Sprint(n.Value)
+	case *SymbolNode:
+		return n.Name
+	case *StringNode:
+		return fmt.Sprintf("%q", n.Text)
+	case *RegisterNode:
+		return n.Name
+	case *UnaryNode:
+		return n.Op + exprString(n.X)
+	case *BinaryNode:
+		return "(" + exprString(n.X) + n.Op + exprString(n.Y) + ")"
+	case *BlockNode:
+		return "{...}"
+	}
+	return fmt.Sprintf("%T", nd)
+}
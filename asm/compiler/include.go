@@ -0,0 +1,126 @@
+package compiler
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/niksaak/rhmrm/asm/diag"
+	"github.com/niksaak/rhmrm/machine"
+)
+
+// ParseFunc parses src read from file filename into a slice of clauses.
+type ParseFunc func(src []byte, filename string) []Node
+
+// includeFile returns clauses parsed from the file named by `.include`
+// directive d.
+func (c *Compiler) includeFile(d *DirectiveNode) []Node {
+	errorNd := mkErrorNodef(d)
+	if err := operandCount(d.Operands, 1, 1); err != nil {
+		return []Node{errorNd("%s", err.Message)}
+	}
+	path, src, err := c.readFile(d.Operands[0])
+	if err != nil {
+		return []Node{err}
+	}
+	if c.includedp(path, d.File) {
+		return []Node{coded(diag.Include,
+			errorNd("include cycle: %s", path))}
+	}
+	if c.Parse == nil {
+		return []Node{coded(diag.Include,
+			errorNd("no parser to include %s", path))}
+	}
+	c.includers[path] = d.File
+	return c.Parse(src, path)
+}
+
+// includedp reports whether path is already being included by includer
+// or any of the files including it.
+func (c *Compiler) includedp(path, includer string) bool {
+	for f, ok := includer, true; ok; f, ok = c.includers[f] {
+		if samefilep(path, f) {
+			return true
+		}
+	}
+	return false
+}
+
+// samefilep reports whether names a and b refer to the same file.
+func samefilep(a, b string) bool {
+	if a == "" || b == "" {
+		return false
+	}
+	if a, err := filepath.Abs(a); err == nil {
+		if b, err := filepath.Abs(b); err == nil {
+			return a == b
+		}
+	}
+	return filepath.Clean(a) == filepath.Clean(b)
+}
+
+// readFile reads file named by string node nd. Relative names are searched
+// in the directory of the file containing nd, then in IncludeDirs.
+func (c *Compiler) readFile(nd Node) (string, []byte, *ErrorNode) {
+	s, ok := nd.(*StringNode)
+	if !ok {
+		return "", nil, mkErrorNodef(nd)("expected file name: %v", nd)
+	}
+	name := s.Text
+	if filepath.IsAbs(name) {
+		src, err := ioutil.ReadFile(name)
+		if err != nil {
+			return "", nil, coded(diag.Include,
+				mkErrorNodef(nd)("%v", err))
+		}
+		return name, src, nil
+	}
+	dirs := append([]string{filepath.Dir(s.File)}, c.IncludeDirs...)
+	for _, dir := range dirs {
+		path := filepath.Join(dir, name)
+		src, err := ioutil.ReadFile(path)
+		if err == nil {
+			return path, src, nil
+		}
+		if !os.IsNotExist(err) {
+			return "", nil, coded(diag.Include,
+				mkErrorNodef(nd)("%v", err))
+		}
+	}
+	return "", nil, coded(diag.Include,
+		mkErrorNodef(nd)("file not found: %s", name))
+}
+
+// dirIncbin translates `.incbin "file" [, big | little]` into words of raw
+// file contents, pairing octets in little-endian order by default.
+func (c *Compiler) dirIncbin(operands []Node) []Node {
+	if err := operandCount(operands, 1, 2); err != nil {
+		return []Node{err}
+	}
+	shift := [2]uint{0, 8}
+	if len(operands) == 2 {
+		order, _ := operands[1].(*SymbolNode)
+		switch {
+		case order != nil && order.Name == "little":
+		case order != nil && order.Name == "big":
+			shift = [2]uint{8, 0}
+		default:
+			return []Node{mkErrorNodef(operands[1])(
+				"expected word order, big or little: %v",
+				operands[1])}
+		}
+	}
+	_, src, err := c.readFile(operands[0])
+	if err != nil {
+		return []Node{err}
+	}
+	if len(src) > 0x20000 {
+		return []Node{mkErrorNodef(operands[0])(
+			"file is too large: %d octets", len(src))}
+	}
+	text := &TextNode{Text: make([]machine.Word, (len(src)+1)/2)}
+	for i, b := range src {
+		text.Text[i/2] |= machine.Word(b) << shift[i%2]
+	}
+	return []Node{text}
+}
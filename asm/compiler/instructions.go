@@ -0,0 +1,237 @@
+package compiler
+
+import (
+	"github.com/niksaak/rhmrm/asm/diag"
+	"github.com/niksaak/rhmrm/asm/util"
+	"github.com/niksaak/rhmrm/machine"
+)
+
+// Operand kinds.
+const (
+	gregOperand  = iota // general register
+	cregOperand         // control register with access mode
+	smallOperand        // 5 bit integer
+	jumpOperand         // target address, 10 bit signed offset from it
+	codeOperand         // 10 bit unsigned integer
+	wordOperand         // 16 bit integer in the next word
+)
+
+// Instruction fields.
+var (
+	aField   = ByteSpec{Offset: 0, Size: 5, Position: 6}
+	bField   = ByteSpec{Offset: 0, Size: 5, Position: 11}
+	cField   = ByteSpec{Offset: 0, Size: 10, Position: 6}
+	immField = ByteSpec{Offset: 1, Size: 16, Position: 0}
+)
+
+// operand describes which kind of operand goes into which field.
+type operand struct {
+	kind  int
+	field ByteSpec
+}
+
+// mnemonics maps ordinary and unary mnemonics to their opcodes.
+var mnemonics = map[string]machine.Word{
+	"imp": machine.OP_IMP,
+	"mov": machine.OP_MOV,
+	"mtc": machine.OP_MTC,
+	"mfc": machine.OP_MFC,
+
+	"str": machine.OP_STR,
+	"psh": machine.OP_PSH,
+	"loa": machine.OP_LOA,
+	"pop": machine.OP_POP,
+	"mom": machine.OP_MOM,
+
+	"srl": machine.OP_SRL,
+
+	"add": machine.OP_ADD,
+	"adx": machine.OP_ADX,
+	"sub": machine.OP_SUB,
+	"sbx": machine.OP_SBX,
+	"mul": machine.OP_MUL,
+	"mli": machine.OP_MLI,
+	"div": machine.OP_DIV,
+	"dvi": machine.OP_DVI,
+	"mod": machine.OP_MOD,
+	"mdi": machine.OP_MDI,
+	"inc": machine.OP_INC,
+	"gbs": machine.OP_GBS,
+
+	"and": machine.OP_AND,
+	"ior": machine.OP_IOR,
+	"xor": machine.OP_XOR,
+	"bic": machine.OP_BIC,
+	"shl": machine.OP_SHL,
+	"asr": machine.OP_ASR,
+	"shr": machine.OP_SHR,
+	"rol": machine.OP_ROL,
+	"ror": machine.OP_ROR,
+
+	"tst": machine.OP_TST,
+	"teq": machine.OP_TEQ,
+	"cmp": machine.OP_CMP,
+	"cmn": machine.OP_CMN,
+
+	"jmp": machine.OP_JMP,
+	"jlt": machine.OP_JLT,
+	"jle": machine.OP_JLE,
+	"jgt": machine.OP_JGT,
+	"jge": machine.OP_JGE,
+	"jeq": machine.OP_JEQ,
+	"jne": machine.OP_JNE,
+
+	"swi": machine.OP_SWI,
+	"hwi": machine.OP_HWI,
+	"ire": machine.OP_IRE,
+}
+
+// immediates maps IMP mnemonics to their subopcodes.
+var immediates = map[string]machine.Word{
+	"brk": machine.IMP_BRK,
+	"mov": machine.IMP_MOV,
+	"mtc": machine.IMP_MTC,
+
+	"str": machine.IMP_STR,
+	"psh": machine.IMP_PSH,
+
+	"srl": machine.IMP_SRL,
+
+	"add": machine.IMP_ADD,
+	"adx": machine.IMP_ADX,
+	"sub": machine.IMP_SUB,
+	"sbx": machine.IMP_SBX,
+	"mul": machine.IMP_MUL,
+	"mli": machine.IMP_MLI,
+	"div": machine.IMP_DIV,
+	"dvi": machine.IMP_DVI,
+	"mod": machine.IMP_MOD,
+	"mdi": machine.IMP_MDI,
+	"inc": machine.IMP_INC,
+
+	"and": machine.IMP_AND,
+	"ior": machine.IMP_IOR,
+	"xor": machine.IMP_XOR,
+	"bic": machine.IMP_BIC,
+	"shl": machine.IMP_SHL,
+	"asr": machine.IMP_ASR,
+	"shr": machine.IMP_SHR,
+	"rol": machine.IMP_ROL,
+	"ror": machine.IMP_ROR,
+
+	"tst": machine.IMP_TST,
+	"teq": machine.IMP_TEQ,
+	"cmp": machine.IMP_CMP,
+	"cmn": machine.IMP_CMN,
+}
+
+// ordFormat returns operand layout of an ordinary or unary instruction.
+func ordFormat(op machine.Word) []operand {
+	switch {
+	case op == machine.OP_MTC:
+		return []operand{{cregOperand, aField}, {gregOperand, bField}}
+	case op == machine.OP_MFC:
+		return []operand{{gregOperand, aField}, {cregOperand, bField}}
+	case op == machine.OP_INC, op == machine.OP_ROL, op == machine.OP_ROR:
+		return []operand{{gregOperand, aField}, {smallOperand, bField}}
+	case op >= machine.OP_JMP && op <= machine.OP_JNE:
+		return []operand{{jumpOperand, cField}}
+	case op >= machine.OP_SWI && op <= machine.OP_IRE:
+		return []operand{{codeOperand, cField}}
+	}
+	return []operand{{gregOperand, aField}, {gregOperand, bField}}
+}
+
+// impFormat returns operand layout of an IMP instruction.
+func impFormat(sub machine.Word) []operand {
+	switch sub {
+	case machine.IMP_BRK:
+		return nil
+	case machine.IMP_MTC:
+		return []operand{{cregOperand, bField}, {wordOperand, immField}}
+	}
+	return []operand{{gregOperand, bField}, {wordOperand, immField}}
+}
+
+// mkTranslator returns a function encoding operands into size words of text
+// with the first word initialized to op.
+func (c *Compiler) mkTranslator(
+	op machine.Word,
+	size int,
+	format []operand,
+) TranslatorFunc {
+	return func(operands []Node) []Node {
+		text := &TextNode{Text: make([]machine.Word, size)}
+		text.Text[0] = op
+		err := operandCount(operands, len(format), len(format))
+		if err != nil {
+			return []Node{err}
+		}
+		for i, o := range format {
+			if err := text.encode(operands[i], o, c.Symbols); err != nil {
+				return []Node{err}
+			}
+		}
+		return []Node{text}
+	}
+}
+
+// encode puts value of nd into the field described by o. Register fields
+// also accept integer expressions. Symbols missing from syms are recorded
+// to be resolved later, as are jump targets, which are encoded relative to
+// the address of the text.
+func (t *TextNode) encode(nd Node, o operand, syms map[string]int) *ErrorNode {
+	errorNd := mkErrorNodef(nd)
+	r, ok := nd.(*RegisterNode)
+	switch {
+	case !ok:
+		// not a register, encode as an integer
+	case o.kind == gregOperand && r.Kind == util.GeneralRegisterKind:
+		setSpec(t.Text, o.field, machine.Word(r.Index))
+		return nil
+	case o.kind == cregOperand &&
+		r.Kind&^util.ControlModeMask == util.ControlRegisterKind:
+		mode := r.Kind & util.ControlModeMask
+		setSpec(t.Text, o.field, machine.Word(mode<<3|r.Index))
+		return nil
+	case o.kind == gregOperand:
+		return errorNd("expected general register: %s", exprString(nd))
+	case o.kind == cregOperand:
+		return errorNd("expected control register: %s", exprString(nd))
+	}
+	v, missing, err := eval(nd, syms)
+	switch {
+	case err != nil:
+		return err
+	case missing != nil || o.kind == jumpOperand:
+		t.Symbols = append(t.Symbols, SymbolSpec{
+			ByteSpec: o.field,
+			Name:     exprString(nd),
+			Expr:     nd,
+			Relative: o.kind == jumpOperand,
+		})
+		return nil
+	}
+	lo, hi := operandRange(o.kind)
+	if v < lo || v > hi {
+		return coded(diag.Range, errorNd(
+			"integer out of range [%d, %d]: %d", lo, hi, v))
+	}
+	setSpec(t.Text, o.field, machine.Word(v))
+	return nil
+}
+
+// operandRange returns the range of integers accepted by operand kind.
+func operandRange(kind int) (lo, hi int) {
+	switch kind {
+	case gregOperand, cregOperand:
+		return 0, 0x1f
+	case smallOperand:
+		return -0x10, 0x1f
+	case jumpOperand:
+		return -0x200, 0x1ff
+	case codeOperand:
+		return 0, 0x3ff
+	}
+	return -0x8000, 0xffff
+}
@@ -0,0 +1,308 @@
+package compiler
+
+import (
+	"fmt"
+
+	"github.com/niksaak/rhmrm/asm/diag"
+)
+
+// macroParam is a parameter of a macro.
+type macroParam struct {
+	name     string
+	def      Node // default value, nil when the parameter is required
+	variadic bool // parameter takes all the remaining arguments
+}
+
+// macroDef parses operands of `.macro name params... { body }` following
+// the name into parameters and body.
+func macroDef(operands []Node) ([]macroParam, *BlockNode, *ErrorNode) {
+	if len(operands) == 0 {
+		return nil, nil, &ErrorNode{Message: "missing macro body"}
+	}
+	// last operand of `.macro` is a block containing macro body
+	last := operands[len(operands)-1]
+	body, ok := last.(*BlockNode)
+	if !ok {
+		return nil, nil, mkErrorNodef(last)("missing macro body")
+	}
+	// other operands are parameters: `name`, `name=default` or `name...`
+	params := make([]macroParam, len(operands)-1)
+	optional := false
+	for i, o := range operands[:len(operands)-1] {
+		p := &params[i]
+		switch n := o.(type) {
+		case *SymbolNode:
+			p.name = n.Name
+		case *BinaryNode:
+			if sym, ok := n.X.(*SymbolNode); ok && n.Op == "=" {
+				p.name, p.def = sym.Name, n.Y
+			}
+		case *UnaryNode:
+			if sym, ok := n.X.(*SymbolNode); ok && n.Op == "..." {
+				p.name, p.variadic = sym.Name, true
+			}
+		}
+		errorNd := mkErrorNodef(o)
+		switch {
+		case p.name == "":
+			return nil, nil, errorNd("bad macro parameter: %s",
+				exprString(o))
+		case p.variadic && i != len(params)-1:
+			return nil, nil, errorNd("variadic parameter %s "+
+				"is not the last one", p.name)
+		case p.def == nil && !p.variadic && optional:
+			return nil, nil, errorNd("required parameter %s "+
+				"follows optional ones", p.name)
+		}
+		optional = p.def != nil
+		for _, q := range params[:i] {
+			if q.name == p.name {
+				return nil, nil, errorNd(
+					"duplicate macro parameter: %s", p.name)
+			}
+		}
+	}
+	return params, body, nil
+}
+
+// MacroMk returns a function expanding invocations of macro op, defined
+// with parameters and body in operands, or nil if the definition is
+// malformed.
+func (c *Compiler) MacroMk(op string, operands []Node) TranslatorFunc {
+	params, body, err := macroDef(operands)
+	if err != nil {
+		return nil
+	}
+	return c.mkMacroExpander(op, params, body.Clauses)
+}
+
+// collectMacrodefs populates compiler state with macro definitions.
+func (c *Compiler) collectMacrodefs(ns []Node) []Node {
+	for i := 0; i < len(ns); i++ {
+		errorNd := mkErrorNodef(ns[i])
+		// macros are defined with the `.macro` directive.
+		md, ok := ns[i].(*DirectiveNode)
+		if !ok || md.Op != "macro" {
+			continue
+		}
+		// first operand must be a symbol naming a macro
+		var ident *SymbolNode
+		if len(md.Operands) > 0 {
+			ident, _ = md.Operands[0].(*SymbolNode)
+		}
+		if ident == nil {
+			ns[i] = coded(diag.Macro,
+				errorNd("malformed macro definition"))
+			continue
+		}
+		// macro name must be unique
+		name := ident.Name
+		if _, ok := c.Macros[name]; ok {
+			ns[i] = coded(diag.Redefined,
+				errorNd("macro already defined: %s", name))
+			continue
+		}
+		params, body, err := macroDef(md.Operands[1:])
+		if err != nil {
+			if !err.ValidP() {
+				err.Position = md.Position
+			}
+			ns[i] = coded(diag.Macro, err)
+			continue
+		}
+		// if all above is ok, compile a macro func
+		c.Macros[name] = c.mkMacroExpander(name, params, body.Clauses)
+		ns = setNode(ns, i)
+		i--
+	}
+	return ns
+}
+
+// mkMacroExpander returns function which takes macro arguments and returns
+// a copy of body with parameters replaced by corresponding arguments and
+// local labels renamed to be unique for every expansion.
+func (c *Compiler) mkMacroExpander(
+	name string,
+	params []macroParam,
+	body []Node,
+) TranslatorFunc {
+	// local labels defined in the body
+	var locals []string
+	walkNodes(body, func(nd Node) {
+		if l, ok := nd.(*LabelNode); ok && localp(l.Name) {
+			locals = append(locals, l.Name)
+		}
+	})
+	return func(args []Node) []Node {
+		e := &expansion{
+			args:   make(map[string][]Node),
+			labels: make(map[string]string),
+		}
+		if err := e.bind(name, params, args); err != nil {
+			return []Node{coded(diag.Macro, err)}
+		}
+		c.expansions++
+		for _, l := range locals {
+			e.labels[l] = fmt.Sprintf("%s.%s.%d", l, name, c.expansions)
+		}
+		expanded, err := e.nodes(body)
+		if err != nil {
+			return []Node{coded(diag.Macro, err)}
+		}
+		return expanded
+	}
+}
+
+// expansion holds state of a single macro expansion.
+type expansion struct {
+	args   map[string][]Node // arguments bound to parameters
+	labels map[string]string // local labels renamed
+}
+
+// bind binds args to params of macro name.
+func (e *expansion) bind(name string, params []macroParam, args []Node) *ErrorNode {
+	min, max := 0, len(params)
+	for _, p := range params {
+		if p.def == nil && !p.variadic {
+			min++
+		}
+		if p.variadic {
+			max = -1
+		}
+	}
+	if len(args) < min || (max >= 0 && len(args) > max) {
+		want := fmt.Sprint(min)
+		switch {
+		case max < 0:
+			want = fmt.Sprintf("at least %d", min)
+		case min != max:
+			want = fmt.Sprintf("%d to %d", min, max)
+		}
+		err := &ErrorNode{Message: fmt.Sprintf(
+			"macro %s: wrong number of arguments: have %d, want %s",
+			name, len(args), want)}
+		if len(args) > 0 {
+			err.Position = args[0].Pos()
+		}
+		return err
+	}
+	for i, p := range params {
+		switch {
+		case p.variadic:
+			e.args[p.name] = args[i:]
+		case i < len(args):
+			e.args[p.name] = args[i : i+1]
+		default:
+			e.args[p.name] = []Node{p.def}
+		}
+	}
+	return nil
+}
+
+// nodes returns copy of ns with substitutions made. Parameters used as
+// list elements expand into all of their arguments.
+func (e *expansion) nodes(ns []Node) (ret []Node, err *ErrorNode) {
+	for _, nd := range ns {
+		if sym, ok := nd.(*SymbolNode); ok && e.args[sym.Name] != nil {
+			for _, arg := range e.args[sym.Name] {
+				arg, _ = copyNode(arg)
+				ret = append(ret, arg)
+			}
+			continue
+		}
+		nd, err = e.node(nd)
+		if err != nil {
+			return nil, err
+		}
+		ret = append(ret, nd)
+	}
+	return ret, nil
+}
+
+// node returns copy of nd with substitutions made.
+func (e *expansion) node(nd Node) (Node, *ErrorNode) {
+	var err *ErrorNode
+	switch n := nd.(type) {
+	case *SymbolNode:
+		if args, ok := e.args[n.Name]; ok {
+			if len(args) != 1 {
+				return nil, mkErrorNodef(n)("variadic parameter "+
+					"%s used as a single value", n.Name)
+			}
+			return copyNode(args[0])
+		}
+		sym := *n
+		if l, ok := e.labels[n.Name]; ok {
+			sym.Name = l
+		}
+		return &sym, nil
+	case *LabelNode:
+		l := *n
+		if name, ok := e.labels[n.Name]; ok {
+			l.Name = name
+		}
+		return &l, nil
+	case *InstructionNode:
+		in := *n
+		if args := e.args[n.Op]; len(args) == 1 {
+			if sym, ok := args[0].(*SymbolNode); ok {
+				in.Op = sym.Name
+			}
+		}
+		in.Operands, err = e.nodes(n.Operands)
+		return &in, err
+	case *DirectiveNode:
+		d := *n
+		d.Operands, err = e.nodes(n.Operands)
+		return &d, err
+	case *BlockNode:
+		b := *n
+		b.Clauses, err = e.nodes(n.Clauses)
+		return &b, err
+	case *UnaryNode:
+		u := *n
+		u.X, err = e.node(n.X)
+		return &u, err
+	case *BinaryNode:
+		b := *n
+		if b.X, err = e.node(n.X); err != nil {
+			return nil, err
+		}
+		b.Y, err = e.node(n.Y)
+		return &b, err
+	case *RegisterNode:
+		r := *n
+		return &r, nil
+	case *IntegerNode:
+		i := *n
+		return &i, nil
+	case *StringNode:
+		s := *n
+		return &s, nil
+	}
+	return nd, nil
+}
+
+// copyNode returns a deep copy of nd.
+func copyNode(nd Node) (Node, *ErrorNode) {
+	return new(expansion).node(nd)
+}
+
+// walkNodes calls fn for every node in ns and their descendants.
+func walkNodes(ns []Node, fn func(Node)) {
+	for _, nd := range ns {
+		fn(nd)
+		switch n := nd.(type) {
+		case *InstructionNode:
+			walkNodes(n.Operands, fn)
+		case *DirectiveNode:
+			walkNodes(n.Operands, fn)
+		case *BlockNode:
+			walkNodes(n.Clauses, fn)
+		case *UnaryNode:
+			walkNodes([]Node{n.X}, fn)
+		case *BinaryNode:
+			walkNodes([]Node{n.X, n.Y}, fn)
+		}
+	}
+}
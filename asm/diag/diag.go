@@ -0,0 +1,214 @@
+// Package diag implements diagnostics reported by the assembler.
+package diag
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"sort"
+	"strings"
+
+	"github.com/niksaak/rhmrm/asm/lexer"
+)
+
+// Severity of a diagnostic.
+type Severity int
+
+const (
+	Error Severity = iota
+	Warning
+)
+
+var severityStrings = [...]string{
+	Error:   "error",
+	Warning: "warning",
+}
+
+func (s Severity) String() string {
+	if s < 0 || int(s) >= len(severityStrings) {
+		return fmt.Sprintf("severity(%d)", int(s))
+	}
+	return severityStrings[s]
+}
+
+// MarshalText implements encoding.TextMarshaler.
+func (s Severity) MarshalText() ([]byte, error) {
+	return []byte(s.String()), nil
+}
+
+// Diagnostic codes.
+const (
+	Lexical     = "lexical"     // malformed lexeme
+	Syntax      = "syntax"      // parse error
+	Invalid     = "invalid"     // invalid clause or operand
+	Unknown     = "unknown"     // unknown instruction, directive or macro
+	Undefined   = "undefined"   // reference to undefined symbol
+	Redefined   = "redefined"   // symbol or macro defined twice
+	Range       = "range"       // value out of range
+	Include     = "include"     // file inclusion failed
+	Macro       = "macro"       // macro definition or invocation error
+	Conditional = "conditional" // malformed conditional
+	Scope       = "scope"       // local label outside of any scope
+	Internal    = "internal"    // assembler failure
+)
+
+// Diagnostic is a single error or warning at a position in source.
+type Diagnostic struct {
+	Pos      lexer.Position
+	Severity Severity
+	Code     string
+	Message  string
+}
+
+// Error implements error interface, formatting d like:
+//
+//	file:line:column: error: message
+func (d *Diagnostic) Error() string {
+	return fmt.Sprintf("%v: %v: %s", &d.Pos, d.Severity, d.Message)
+}
+
+// MarshalJSON implements json.Marshaler.
+func (d *Diagnostic) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		File     string   `json:"file,omitempty"`
+		Line     int      `json:"line,omitempty"`
+		Column   int      `json:"column,omitempty"`
+		Offset   int      `json:"offset"`
+		Severity Severity `json:"severity"`
+		Code     string   `json:"code"`
+		Message  string   `json:"message"`
+	}{
+		d.Pos.File, d.Pos.Line, d.Pos.Column, d.Pos.Offset,
+		d.Severity, d.Code, d.Message,
+	})
+}
+
+// List is a list of diagnostics. List of errors is an error itself.
+type List []*Diagnostic
+
+// Add appends diagnostic with formatted message to l.
+func (l *List) Add(pos lexer.Position, sev Severity, code, format string, args ...interface{}) {
+	*l = append(*l, &Diagnostic{pos, sev, code, fmt.Sprintf(format, args...)})
+}
+
+// Len, Swap and Less implement sort.Interface.
+func (l List) Len() int      { return len(l) }
+func (l List) Swap(i, j int) { l[i], l[j] = l[j], l[i] }
+
+func (l List) Less(i, j int) bool {
+	a, b := &l[i].Pos, &l[j].Pos
+	if a.File != b.File {
+		return a.File < b.File
+	}
+	if a.Line != b.Line {
+		return a.Line < b.Line
+	}
+	return a.Column < b.Column
+}
+
+// Sort sorts l by position, keeping diagnostics at the same position in
+// order.
+func (l List) Sort() {
+	sort.Stable(l)
+}
+
+// ErrorCount returns number of errors in l.
+func (l List) ErrorCount() (n int) {
+	for _, d := range l {
+		if d.Severity == Error {
+			n++
+		}
+	}
+	return n
+}
+
+// Err returns l as an error if it contains any errors, and nil otherwise.
+func (l List) Err() error {
+	if l.ErrorCount() == 0 {
+		return nil
+	}
+	return l
+}
+
+// Error implements error interface, one diagnostic per line.
+func (l List) Error() string {
+	lines := make([]string, len(l))
+	for i, d := range l {
+		lines[i] = d.Error()
+	}
+	return strings.Join(lines, "\n")
+}
+
+// ReadFunc returns contents of file name.
+type ReadFunc func(name string) ([]byte, error)
+
+// Fprint writes diagnostics in l to w, each followed by the source line it
+// refers to and a caret pointing at the column:
+//
+//	file:line:column: error: message
+//	    source line
+//	    ^
+//
+// Sources are read with read, or from disk when read is nil. Source lines
+// are omitted when they can't be read.
+func (l List) Fprint(w io.Writer, read ReadFunc) error {
+	if read == nil {
+		read = ioutil.ReadFile
+	}
+	srcs := make(map[string][]byte)
+	for _, d := range l {
+		if _, err := fmt.Fprintln(w, d.Error()); err != nil {
+			return err
+		}
+		if !d.Pos.ValidP() || d.Pos.File == "" {
+			continue
+		}
+		src, ok := srcs[d.Pos.File]
+		if !ok {
+			src, _ = read(d.Pos.File)
+			srcs[d.Pos.File] = src
+		}
+		if snippet := Snippet(src, d.Pos); snippet != "" {
+			if _, err := io.WriteString(w, snippet); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Snippet returns line of src at pos followed by a line with a caret under
+// pos column, or an empty string when src has no such line.
+func Snippet(src []byte, pos lexer.Position) string {
+	if pos.Line < 1 {
+		return ""
+	}
+	lines := bytes.Split(src, []byte("\n"))
+	if pos.Line > len(lines) {
+		return ""
+	}
+	line := strings.TrimRight(string(lines[pos.Line-1]), "\r")
+	// keep tabs so that the caret lines up with the source
+	var pad []rune
+	for i, r := range []rune(line) {
+		if i >= pos.Column-1 {
+			break
+		}
+		if r != '\t' {
+			r = ' '
+		}
+		pad = append(pad, r)
+	}
+	return line + "\n" + string(pad) + "^\n"
+}
+
+// WriteJSON writes diagnostics in l to w as a JSON array of objects with
+// file, line, column, offset, severity, code and message fields.
+func (l List) WriteJSON(w io.Writer) error {
+	if l == nil {
+		l = List{}
+	}
+	return json.NewEncoder(w).Encode(l)
+}
@@ -0,0 +1,112 @@
+package diag
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+
+	"github.com/niksaak/rhmrm/asm/lexer"
+)
+
+func pos(file string, line, col int) lexer.Position {
+	return lexer.Position{File: file, Line: line, Column: col}
+}
+
+func TestListSort(t *testing.T) {
+	t.Parallel()
+	var l List
+	l.Add(pos("b.s", 1, 1), Error, Invalid, "b")
+	l.Add(pos("a.s", 2, 1), Error, Invalid, "a2")
+	l.Add(pos("a.s", 1, 7), Warning, Scope, "a1w")
+	l.Add(pos("a.s", 1, 7), Error, Invalid, "a1e")
+	l.Sort()
+	want := []string{"a1w", "a1e", "a2", "b"}
+	for i, d := range l {
+		if d.Message != want[i] {
+			t.Errorf("#%d is %q, want %q", i, d.Message, want[i])
+		}
+	}
+}
+
+func TestListErr(t *testing.T) {
+	t.Parallel()
+	var l List
+	if l.Err() != nil {
+		t.Errorf("empty list is an error")
+	}
+	l.Add(pos("a.s", 1, 1), Warning, Scope, "just a warning")
+	if l.Err() != nil {
+		t.Errorf("list of warnings is an error")
+	}
+	l.Add(pos("a.s", 3, 5), Error, Unknown, "unknown instruction: mvo")
+	err := l.Err()
+	if err == nil {
+		t.Fatalf("list with errors is not an error")
+	}
+	want := "a.s:1:1: warning: just a warning\n" +
+		"a.s:3:5: error: unknown instruction: mvo"
+	if s := err.Error(); s != want {
+		t.Errorf("got %q, want %q", s, want)
+	}
+}
+
+func TestFprint(t *testing.T) {
+	t.Parallel()
+	src := ":start\n\tmvo r1, r2\n    mov r1, 1q\n"
+	var l List
+	l.Add(pos("a.s", 2, 2), Error, Unknown, "unknown instruction: mvo")
+	l.Add(pos("a.s", 3, 13), Error, Syntax, "bad integer: 1q")
+	l.Add(pos("", 0, 0), Error, Internal, "no position")
+	read := func(name string) ([]byte, error) {
+		return []byte(src), nil
+	}
+	var buf bytes.Buffer
+	if err := l.Fprint(&buf, read); err != nil {
+		t.Fatal(err)
+	}
+	want := "a.s:2:2: error: unknown instruction: mvo\n" +
+		"\tmvo r1, r2\n" +
+		"\t^\n" +
+		"a.s:3:13: error: bad integer: 1q\n" +
+		"    mov r1, 1q\n" +
+		"            ^\n" +
+		"???: error: no position\n"
+	if s := buf.String(); s != want {
+		t.Errorf("got:\n%s\nwant:\n%s", s, want)
+	}
+}
+
+func TestWriteJSON(t *testing.T) {
+	t.Parallel()
+	var l List
+	var buf bytes.Buffer
+	if err := l.WriteJSON(&buf); err != nil {
+		t.Fatal(err)
+	}
+	if s := buf.String(); s != "[]\n" {
+		t.Errorf("empty list: got %q, want %q", s, "[]\n")
+	}
+	buf.Reset()
+	l.Add(lexer.Position{File: "a.s", Offset: 8, Line: 2, Column: 2},
+		Warning, Scope, "local label _x outside of any global label scope")
+	if err := l.WriteJSON(&buf); err != nil {
+		t.Fatal(err)
+	}
+	var got []map[string]interface{}
+	if err := json.Unmarshal(buf.Bytes(), &got); err != nil {
+		t.Fatalf("%s: %v", buf.Bytes(), err)
+	}
+	want := map[string]interface{}{
+		"file": "a.s", "line": 2.0, "column": 2.0, "offset": 8.0,
+		"severity": "warning", "code": "scope",
+		"message": "local label _x outside of any global label scope",
+	}
+	if len(got) != 1 || len(got[0]) != len(want) {
+		t.Fatalf("got %s", buf.Bytes())
+	}
+	for k, v := range want {
+		if got[0][k] != v {
+			t.Errorf("%s is %v, want %v", k, got[0][k], v)
+		}
+	}
+}
@@ -20,6 +20,9 @@ const (
 	INTEGER  // = <decimal> { <letter> | <decimal> } .
 	RUNE     // = "'" <rune or character code> "'" .
 	COMMENT  // = ";" <anything> .
+	SHL      // = "<<" .
+	SHR      // = ">>" .
+	ELLIPSIS // = "..." .
 )
 
 var lxStrings = map[rune]string{
@@ -31,6 +34,9 @@ var lxStrings = map[rune]string{
 	INTEGER:  "integer",
 	RUNE:     "rune",
 	COMMENT:  "comment",
+	SHL:      "<<",
+	SHR:      ">>",
+	ELLIPSIS: "...",
 }
 
 func LexemeString(lm rune) (s string) {
@@ -104,7 +110,7 @@ func (l *Lexer) Init(src []byte, filename string, err ErrorHandler) *Lexer {
 		File:   filename,
 		Offset: 0,
 		Line:   1,
-		Column: 1,
+		Column: 0, // incremented when reading the first character
 	}
 
 	l.next()
@@ -181,6 +187,27 @@ func (l *Lexer) Scan() (pos Position, lm rune, lit string) {
 		case ';':
 			lit = l.scanComment()
 			lm = COMMENT
+		case '.':
+			lit, lm = ".", '.'
+			l.next()
+			if l.ch == '.' && l.rd_off < len(l.src) &&
+				l.src[l.rd_off] == '.' {
+				lit, lm = "...", ELLIPSIS
+				l.next()
+				l.next()
+			}
+		case '<', '>':
+			ch := l.ch
+			lit, lm = string(ch), ch
+			l.next()
+			if l.ch == ch { // shift operator
+				lit += string(ch)
+				lm = SHL
+				if ch == '>' {
+					lm = SHR
+				}
+				l.next()
+			}
 		default:
 			lit = string(l.ch)
 			lm = l.ch
@@ -41,7 +41,13 @@ var checks = []ck_lexeme{
 	{RUNE, `'\x40'`, `\x40`},
 	{RUNE, `'\u0407'`, `\u0407`},
 
+	{SHL, "<<", "<<"},
+	{SHR, ">>", ">>"},
+	{'<', "< <", "<"},
+
 	{'.', ".", "."},
+	{'.', "..", "."},
+	{ELLIPSIS, "...", "..."},
 	{':', ":", ""},
 	{'{', "{", ""},
 	{'}', "}", "}"},
@@ -94,6 +100,23 @@ func TestLexerNewline(t *testing.T) {
 	test_expect_lexeme(lx.Scan, EOF, t)
 }
 
+func TestLexerPosition(t *testing.T) {
+	t.Parallel()
+	src := []byte("mov r1\n\tfoo")
+	lx := new(Lexer).Init(src, "", mkteh(t))
+	for _, want := range []Position{
+		{Offset: 0, Line: 1, Column: 1},
+		{Offset: 4, Line: 1, Column: 5},
+		{Offset: 6, Line: 1, Column: 7},
+		{Offset: 8, Line: 2, Column: 2},
+	} {
+		pos, _, lit := lx.Scan()
+		if pos != want {
+			t.Errorf("%q is at %v, want %v", lit, &pos, &want)
+		}
+	}
+}
+
 func TestLexer(t *testing.T) {
 	t.Parallel()
 	lx := new(Lexer)
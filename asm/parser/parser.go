@@ -4,6 +4,7 @@ package parser
 import (
 	"fmt"
 	"github.com/niksaak/rhmrm/asm/compiler"
+	"github.com/niksaak/rhmrm/asm/diag"
 	"github.com/niksaak/rhmrm/asm/lexer"
 	"github.com/niksaak/rhmrm/asm/util"
 	"unicode"
@@ -17,20 +18,38 @@ type lexeme struct {
 
 // Parser implements building abstract syntax tree from lexeme stream.
 type Parser struct {
-	lexer      *lexer.Lexer
-	lexeme     // current lexeme
-	ErrorCount int
+	lexer       *lexer.Lexer
+	lexeme                // current lexeme
+	Diagnostics diag.List // errors found while parsing
 }
 
 func (p *Parser) Init(lx *lexer.Lexer) *Parser {
 	// initialize values
 	p.lexer = lx
-	p.ErrorCount = 0
+	p.Diagnostics = nil
 
 	p.next() // fetch the first token
 	return p
 }
 
+// ParseFile parses src read from file filename and returns its clauses.
+// Lexer errors are reported as ErrorNodes following the clauses. It can
+// be used as compiler.ParseFunc.
+func ParseFile(src []byte, filename string) []compiler.Node {
+	var errs []compiler.Node
+	eh := func(pos lexer.Position, msg string) {
+		errs = append(errs,
+			&compiler.ErrorNode{Position: pos, Message: msg,
+				Code: diag.Lexical})
+	}
+	l := new(lexer.Lexer).Init(src, filename, eh)
+	prog := new(Parser).Init(l).ParseProgram()
+	if prog, ok := prog.(*compiler.ProgramNode); ok {
+		return append(prog.Clauses, errs...)
+	}
+	return append([]compiler.Node{prog}, errs...)
+}
+
 func (p *Parser) next() {
 	p.pos, p.k, p.lit = p.lexer.Scan()
 }
@@ -75,7 +94,7 @@ func (p *Parser) parseClause() (nodes []compiler.Node) {
 			return
 		}
 		nodes = append(nodes,
-			p.errorf("unrecognized lexeme: "+p.lit))
+			p.errorf("unrecognized lexeme: %s", p.lit))
 		p.next()
 		return p.parseClause()
 	}
@@ -95,7 +114,7 @@ func (p *Parser) parseLabel() compiler.Node {
 	}
 	name := p.lit
 	p.next()
-	return &compiler.LabelNode{pos, name}
+	return &compiler.LabelNode{Position: pos, Name: name}
 }
 
 // directive = "." symbol [ operands ] .
@@ -116,10 +135,10 @@ func (p *Parser) parseDirective() compiler.Node {
 	// operands
 	operands := p.parseOperands()
 
-	return &compiler.DirectiveNode{pos, sym, operands}
+	return &compiler.DirectiveNode{Position: pos, Op: sym, Operands: operands}
 }
 
-// instruction = symbol [ operands ] .
+// instruction = symbol [ "imp" symbol ] [ operands ] .
 func (p *Parser) parseInstruction() compiler.Node {
 	if p.k != lexer.SYMBOL { // instructions start with a symbol
 		return nil
@@ -130,10 +149,19 @@ func (p *Parser) parseInstruction() compiler.Node {
 	sym := p.lit
 	p.next()
 
+	// IMP subopcode is not an expression, so `imp mtc |fl, 1` does not
+	// become an operation on mtc
+	var operands []compiler.Node
+	if sym == "imp" {
+		if sub := p.parseSymbol(); sub != nil {
+			operands = append(operands, sub)
+		}
+	}
+
 	// operands
-	operands := p.parseOperands()
+	operands = append(operands, p.parseOperands()...)
 
-	return &compiler.InstructionNode{pos, sym, operands}
+	return &compiler.InstructionNode{Position: pos, Op: sym, Operands: operands}
 }
 
 // comment = <comment-line-token> .
@@ -153,7 +181,7 @@ func (p *Parser) parseComment() (c compiler.Node) {
 	for i < len(p.lit) && unicode.IsSpace(rune(p.lit[i])) {
 		i++
 	}
-	c = &compiler.CommentNode{p.pos, level, p.lit[i:]}
+	c = &compiler.CommentNode{Position: p.pos, Level: level, Comment: p.lit[i:]}
 	p.next()
 	return
 }
@@ -171,15 +199,91 @@ func (p *Parser) parseOperands() (operands []compiler.Node) {
 	return
 }
 
-// operand = register | symbol | integer | string | block .
+// operand = expression [ "..." | "=" expression ] | block .
 func (p *Parser) parseOperand() (o compiler.Node) {
+	if o = p.parseBlock(); o != nil {
+		return
+	}
+	if o = p.parseExpression(1); o == nil {
+		return
+	}
+	// variadic and default macro parameters
+	switch pos, op := p.pos, p.lit; p.k {
+	case lexer.ELLIPSIS:
+		p.next()
+		o = &compiler.UnaryNode{Position: pos, Op: op, X: o}
+	case '=':
+		p.next()
+		y := p.parseExpression(1)
+		if y == nil {
+			return p.errorf("missing operand after %s", op)
+		}
+		o = &compiler.BinaryNode{Position: pos, Op: op, X: o, Y: y}
+	}
+	return
+}
+
+// binary operator precedences, greater binds tighter
+var precedences = map[rune]int{
+	'*': 2, '/': 2, '%': 2, lexer.SHL: 2, lexer.SHR: 2, '&': 2,
+	'+': 1, '-': 1, '|': 1, '^': 1,
+}
+
+// expression = unary { binary-op unary } .
+func (p *Parser) parseExpression(prec1 int) (x compiler.Node) {
+	if x = p.parseUnary(); x == nil {
+		return nil
+	}
+	for {
+		prec, ok := precedences[p.k]
+		if !ok || prec < prec1 {
+			return
+		}
+		pos, op := p.pos, p.lit
+		p.next()
+		y := p.parseExpression(prec + 1)
+		if y == nil {
+			return p.errorf("missing operand after %s", op)
+		}
+		x = &compiler.BinaryNode{Position: pos, Op: op, X: x, Y: y}
+	}
+}
+
+// unary = ( "-" | "+" | "~" ) unary | primary .
+func (p *Parser) parseUnary() compiler.Node {
+	switch p.k {
+	case '-', '+', '~':
+		pos, op := p.pos, p.lit
+		p.next()
+		x := p.parseUnary()
+		if x == nil {
+			return p.errorf("missing operand after %s", op)
+		}
+		return &compiler.UnaryNode{Position: pos, Op: op, X: x}
+	}
+	return p.parsePrimary()
+}
+
+// primary = register | symbol | integer | string | "(" expression ")" .
+func (p *Parser) parsePrimary() (o compiler.Node) {
+	if p.k == '(' {
+		p.next()
+		o = p.parseExpression(1)
+		if o == nil {
+			return p.error("missing expression")
+		}
+		if err := p.lmexpect(')'); err != nil {
+			return err
+		}
+		p.next()
+		return
+	}
 	// pro'lly there's a better way, but this looks kinda cool too
 	for _, fn := range []func() compiler.Node{
 		p.parseRegister,
 		p.parseSymbol,
 		p.parseInteger,
 		p.parseString,
-		p.parseBlock,
 	} {
 		o = fn()
 		if o != nil {
@@ -196,7 +300,8 @@ func (p *Parser) parseRegister() (er compiler.Node) {
 	switch p.k {
 	case '&', '|', '^':
 		// access modes for control register.
-		r.Kind = util.ControlRegisterKind & util.ControlModes[p.k]
+		r.Kind = util.ControlRegisterKind | util.ControlModes[p.k]
+		r.Name = string(p.k)
 		p.next()
 		if err := p.lmexpect(lexer.REGISTER); err != nil {
 			return nil
@@ -211,7 +316,9 @@ func (p *Parser) parseRegister() (er compiler.Node) {
 			return
 		}
 		r.Index = n
+		r.Name += p.lit
 	case '=':
+		r.Name = "="
 		p.next()
 		fallthrough
 	default:
@@ -226,6 +333,7 @@ func (p *Parser) parseRegister() (er compiler.Node) {
 		}
 		r.Kind = k
 		r.Index = n
+		r.Name += p.lit
 	}
 	p.next()
 	return r
@@ -236,7 +344,7 @@ func (p *Parser) parseSymbol() (n compiler.Node) {
 	if p.k != lexer.SYMBOL {
 		return nil
 	}
-	n = &compiler.SymbolNode{p.pos, p.lit}
+	n = &compiler.SymbolNode{Position: p.pos, Name: p.lit}
 	p.next()
 	return
 }
@@ -246,12 +354,15 @@ func (p *Parser) parseInteger() compiler.Node {
 	if p.k != lexer.INTEGER {
 		return nil
 	}
-	n, err := util.Atoi(p.lit)
+	pos, lit := p.pos, p.lit
+	n, err := util.Atoi(lit)
 	if err != nil {
-		return p.errorf("bad integer: %s (%s)", p.lit, err)
+		er := p.errorf("bad integer: %s (%s)", lit, err)
+		p.next()
+		return er
 	}
 	p.next()
-	return &compiler.IntegerNode{p.pos, n}
+	return &compiler.IntegerNode{Position: pos, Value: n}
 }
 
 // string = '"' <anything> '"'
@@ -259,7 +370,7 @@ func (p *Parser) parseString() (n compiler.Node) {
 	if p.k != lexer.STRING {
 		return nil
 	}
-	n = &compiler.StringNode{p.pos, p.lit}
+	n = &compiler.StringNode{Position: p.pos, Text: p.lit}
 	p.next()
 	return
 }
@@ -292,10 +403,16 @@ func (p *Parser) parseBlock() (n compiler.Node) {
 	return b
 }
 
+// ErrorCount returns number of errors found while parsing.
+func (p *Parser) ErrorCount() int {
+	return p.Diagnostics.ErrorCount()
+}
+
 // error returns Error compiler.Node with current position and supplied message.
 func (p *Parser) error(msg string) *compiler.ErrorNode {
-	p.ErrorCount++
-	return &compiler.ErrorNode{p.pos, msg, nil}
+	p.Diagnostics.Add(p.pos, diag.Error, diag.Syntax, "%s", msg)
+	return &compiler.ErrorNode{Position: p.pos, Message: msg,
+		Code: diag.Syntax}
 }
 
 // errorf is like error with format.
@@ -1,6 +1,7 @@
 package parser
 
 import (
+	"github.com/niksaak/rhmrm/asm/compiler"
 	"github.com/niksaak/rhmrm/asm/lexer"
 	"testing"
 )
@@ -36,7 +37,35 @@ func TestParseProgram(t *testing.T) {
 	if program == nil {
 		t.Errorf("program was not parsed")
 	}
-	if n := p.ErrorCount; n > 0 {
+	if n := p.ErrorCount(); n > 0 {
 		t.Errorf("got %d parse errors", n)
 	}
 }
+
+func TestParseExpression(t *testing.T) {
+	t.Parallel()
+	err := mkErrFunction(t)
+	l := new(lexer.Lexer).Init([]byte(".word 1 + 2*-(3 << 4)\n"), "", err)
+	p := new(Parser).Init(l)
+
+	prog := p.ParseProgram().(*compiler.ProgramNode)
+	if n := p.ErrorCount(); n > 0 {
+		t.Fatalf("got %d parse errors", n)
+	}
+	d := prog.Clauses[0].(*compiler.DirectiveNode)
+	sum, ok := d.Operands[0].(*compiler.BinaryNode)
+	if !ok || sum.Op != "+" {
+		t.Fatalf("got %v, want sum", d.Operands[0])
+	}
+	prod, ok := sum.Y.(*compiler.BinaryNode)
+	if !ok || prod.Op != "*" {
+		t.Fatalf("got %v, want product", sum.Y)
+	}
+	neg, ok := prod.Y.(*compiler.UnaryNode)
+	if !ok || neg.Op != "-" {
+		t.Fatalf("got %v, want negation", prod.Y)
+	}
+	if shl, ok := neg.X.(*compiler.BinaryNode); !ok || shl.Op != "<<" {
+		t.Errorf("got %v, want shift", neg.X)
+	}
+}
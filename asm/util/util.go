@@ -22,6 +22,9 @@ const (
 	ControlXORMode
 )
 
+// ControlModeMask extracts control register access mode from register kind.
+const ControlModeMask = 3
+
 var ControlModes = map[rune]int{
 	'=': ControlSETMode,
 	'&': ControlANDMode,
@@ -0,0 +1,63 @@
+// Command translate translates an RHMRM image into a Go package.
+//
+// Usage:
+//
+//	translate [-p package] [-o file] image
+//
+// The image is read as little-endian words, as .incbin includes files, and
+// is loaded at address zero. The package declares Translation, which is
+// passed to Machine.Run in RunOptions.
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+
+	"github.com/niksaak/rhmrm/machine"
+	"github.com/niksaak/rhmrm/translate"
+)
+
+var (
+	pkg = flag.String("p", "image", "package `name`")
+	out = flag.String("o", "", "output `file`, standard output by default")
+)
+
+func main() {
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: translate [-p package] [-o file] image\n")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() != 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+	src, err := ioutil.ReadFile(flag.Arg(0))
+	if err != nil {
+		fail(err)
+	}
+	text := make([]machine.Word, (len(src)+1)/2)
+	for i, b := range src {
+		text[i/2] |= machine.Word(b) << (8 * uint(i%2))
+	}
+	var buf bytes.Buffer
+	if err := translate.Translate(&buf, *pkg, text); err != nil {
+		fail(err)
+	}
+	if *out == "" {
+		_, err = os.Stdout.Write(buf.Bytes())
+	} else {
+		err = ioutil.WriteFile(*out, buf.Bytes(), 0666)
+	}
+	if err != nil {
+		fail(err)
+	}
+}
+
+func fail(err error) {
+	fmt.Fprintln(os.Stderr, "translate:", err)
+	os.Exit(1)
+}
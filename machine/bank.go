@@ -0,0 +1,211 @@
+package machine
+
+import (
+	"encoding/binary"
+	"errors"
+)
+
+// BANK_SIZE is the number of words in a memory bank and a bank window.
+const BANK_SIZE = 0x1000
+
+// BANK_NONE is not a bank. Interrupts selecting it only query a window.
+const BANK_NONE = 0xffff
+
+// Banks is a bank-switching memory controller. It keeps a backing store of
+// up to 0xffff banks, and maps some of them into windows of machine memory
+// aligned to BANK_SIZE. Every bank is mapped into at most one window. When
+// a window is switched to another bank, its memory is saved to the backing
+// store of the old bank and the new bank is copied in, so Mem, Text and
+// instructions see the mapped banks as ordinary memory. Initially window k
+// maps bank k, holding whatever memory the window had.
+//
+// A hardware interrupt selects bank a1 into window a0, and sets a0 to the
+// bank mapped before or to BANK_NONE if the bank can't be selected, and a1
+// to the number of banks. Banks may also be mapped to memory with Machine.Map,
+// one word per window: loads read the mapped bank, stores select one.
+//
+// Bank switches made by steps are kept by History and stepped back, while
+// those made by the host with Select, like other host writes, are not.
+// Event logs don't record switches, which are replayed from the state of
+// the controller saved in the snapshot of the log. Machine.Reset maps
+// bank k into window k again and clears all banks.
+type Banks struct {
+	windows []Word // addresses of windows
+	banks   []Word // banks mapped into windows
+	store   []Word
+}
+
+// NewBanks returns memory controller with n banks mapped into windows at
+// addresses windows. NewBanks panics if there are more windows than banks,
+// or windows are not aligned or repeated.
+func NewBanks(n int, windows ...Word) *Banks {
+	if n > BANK_NONE || n < len(windows) {
+		panic("banks: bad number of banks")
+	}
+	b := &Banks{
+		windows: append([]Word(nil), windows...),
+		banks:   make([]Word, len(windows)),
+		store:   make([]Word, n*BANK_SIZE),
+	}
+	for k, w := range windows {
+		if w%BANK_SIZE != 0 {
+			panic("banks: window is not aligned")
+		}
+		for _, v := range windows[:k] {
+			if v == w {
+				panic("banks: repeated window")
+			}
+		}
+		b.banks[k] = Word(k)
+	}
+	return b
+}
+
+// Info returns device identification.
+func (b *Banks) Info() DeviceInfo {
+	return DeviceInfo{Vendor: 0x726d, ID: 0xba4c, Version: 1}
+}
+
+// Interrupt selects bank a1 into window a0.
+func (b *Banks) Interrupt(m *Machine) {
+	w, n := int(*m.R(A + 0)), *m.R(A + 1)
+	old := b.Bank(w)
+	if n != BANK_NONE && !b.Select(m, w, n) {
+		old = BANK_NONE
+	}
+	*m.R(A + 0), *m.R(A + 1) = old, Word(b.Len())
+}
+
+// Load returns bank mapped into window i.
+func (b *Banks) Load(m *Machine, i Word) Word {
+	return b.Bank(int(i))
+}
+
+// Store selects bank w into window i.
+func (b *Banks) Store(m *Machine, i, w Word) {
+	b.Select(m, int(i), w)
+}
+
+// Len returns number of banks.
+func (b *Banks) Len() int {
+	return len(b.store) / BANK_SIZE
+}
+
+// Windows returns addresses of windows.
+func (b *Banks) Windows() []Word {
+	return append([]Word(nil), b.windows...)
+}
+
+// Bank returns bank mapped into window w, or BANK_NONE if there is no such
+// window.
+func (b *Banks) Bank(w int) Word {
+	if w < 0 || w >= len(b.banks) {
+		return BANK_NONE
+	}
+	return b.banks[w]
+}
+
+// Select maps bank n into window w of m, reporting false if there is no
+// such window or bank, or the bank is mapped into another window.
+func (b *Banks) Select(m *Machine, w int, n Word) bool {
+	if w < 0 || w >= len(b.banks) || int(n) >= b.Len() {
+		return false
+	}
+	if b.banks[w] == n {
+		return true
+	}
+	for _, k := range b.banks {
+		if k == n {
+			return false
+		}
+	}
+	old := b.banks[w]
+	window := m.text[b.windows[w]:][:BANK_SIZE]
+	if m.history != nil && m.stepping {
+		saved := append([]Word(nil), b.bank(old)...)
+		m.history.keep(func() {
+			copy(window, b.bank(old))
+			copy(b.bank(old), saved)
+			b.banks[w] = old
+		})
+	}
+	copy(b.bank(old), window)
+	copy(window, b.bank(n))
+	b.banks[w] = n
+	return true
+}
+
+// Reset maps bank k into window k and clears the backing store. Memory of
+// the windows is left to the machine.
+func (b *Banks) Reset(m *Machine) {
+	for w := range b.banks {
+		b.banks[w] = Word(w)
+	}
+	for i := range b.store {
+		b.store[i] = 0
+	}
+}
+
+// Mem returns pointer to word i of bank n, which is in memory of m if the
+// bank is mapped. Mem panics if there is no such word.
+func (b *Banks) Mem(m *Machine, n, i Word) *Word {
+	if i >= BANK_SIZE {
+		panic("banks: address out of bank")
+	}
+	for w, k := range b.banks {
+		if k == n {
+			return m.Mem(b.windows[w] + i)
+		}
+	}
+	return &b.bank(n)[i]
+}
+
+// bank returns backing store of bank n.
+func (b *Banks) bank(n Word) []Word {
+	return b.store[int(n)*BANK_SIZE:][:BANK_SIZE]
+}
+
+// MarshalBinary returns state of b: number of windows and banks, banks
+// mapped into windows and the backing store, as little-endian words.
+// Backing store of mapped banks is kept in machine memory instead.
+func (b *Banks) MarshalBinary() ([]byte, error) {
+	data := []Word{Word(len(b.banks)), Word(b.Len())}
+	data = append(data, b.banks...)
+	data = append(data, b.store...)
+	buf := make([]byte, 2*len(data))
+	for k, w := range data {
+		binary.LittleEndian.PutUint16(buf[2*k:], uint16(w))
+	}
+	return buf, nil
+}
+
+// UnmarshalBinary restores state of b saved by MarshalBinary. The state
+// must have the same number of windows and banks.
+func (b *Banks) UnmarshalBinary(data []byte) error {
+	if len(data) != 2*(2+len(b.banks)+len(b.store)) {
+		return errors.New("banks: bad state size")
+	}
+	word := func(k int) Word {
+		return Word(binary.LittleEndian.Uint16(data[2*k:]))
+	}
+	if int(word(0)) != len(b.banks) || int(word(1)) != b.Len() {
+		return errors.New("banks: different number of windows or banks")
+	}
+	banks := make([]Word, len(b.banks))
+	for w := range banks {
+		banks[w] = word(2 + w)
+		if int(banks[w]) >= b.Len() {
+			return errors.New("banks: bad bank")
+		}
+		for _, k := range banks[:w] {
+			if k == banks[w] {
+				return errors.New("banks: bank mapped twice")
+			}
+		}
+	}
+	copy(b.banks, banks)
+	for k := range b.store {
+		b.store[k] = word(2 + len(banks) + k)
+	}
+	return nil
+}
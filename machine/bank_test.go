@@ -0,0 +1,187 @@
+package machine
+
+import (
+	"bytes"
+	"testing"
+)
+
+var switching = []Word{
+	i2(OP_IMP, IMP_MOV, A+0),
+	0,
+	i2(OP_IMP, IMP_MOV, A+1),
+	5,
+	i1(OP_HWI, 0), // bank 5 into window 0
+	i2(OP_MOV, T+0, A+0),
+	i2(OP_IMP, IMP_MOV, S+2),
+	0x8003,
+	i2(OP_LOA, S+0, S+2),
+	i2(OP_IMP, IMP_MOV, S+2),
+	0x8000,
+	i2(OP_IMP, IMP_MOV, S+3),
+	0x1234,
+	i2(OP_STR, S+2, S+3),
+	i2(OP_IMP, IMP_MOV, S+2),
+	0xff00,
+	i2(OP_IMP, IMP_MOV, S+3),
+	7,
+	i2(OP_STR, S+2, S+3), // bank 7 into window 0
+	i2(OP_LOA, S+1, S+2),
+	i2(OP_IMP, IMP_MOV, A+0),
+	0,
+	i2(OP_IMP, IMP_MOV, A+1),
+	1,
+	i1(OP_HWI, 0), // bank 1 is mapped into window 1
+	i1(OP_HWI, 9),
+}
+
+func TestBanks(t *testing.T) {
+	t.Parallel()
+	m := mk_machine()
+	b := NewBanks(16, 0x8000, 0x9000)
+	m.Attach(b)
+	m.Map(0xff00, 2, b)
+	*b.Mem(m, 5, 3) = 0x55
+	*m.Mem(0x9001) = 0x11
+	m.Load(switching)
+	if msg, _ := exec_until_interrupt(m, 100); msg != 9 {
+		t.Fatalf("stopped with %v, want 9", msg)
+	}
+	if t0, s0, s1 := *m.R(T + 0), *m.R(S + 0), *m.R(S + 1); t0 != 0 ||
+		s0 != 0x55 || s1 != 7 {
+		t.Errorf("t0, s0, s1 are %v, %v, %v, want 0, 55, 7", t0, s0, s1)
+	}
+	if a0, a1 := *m.R(A + 0), *m.R(A + 1); a0 != BANK_NONE || a1 != 16 {
+		t.Errorf("a0, a1 are %v, %v, want ffff, 16", a0, a1)
+	}
+	if b.Bank(0) != 7 || b.Bank(1) != 1 || b.Bank(2) != BANK_NONE {
+		t.Errorf("banks are %v, %v, %v", b.Bank(0), b.Bank(1), b.Bank(2))
+	}
+	if *b.Mem(m, 5, 0) != 0x1234 || *b.Mem(m, 0, 0) != 0 ||
+		b.Mem(m, 1, 1) != m.Mem(0x9001) || *m.Mem(0x8000) != 0 {
+		t.Error("bank memory is wrong")
+	}
+
+	var buf bytes.Buffer
+	if err := m.Snapshot(&buf, true); err != nil {
+		t.Fatal(err)
+	}
+	n := mk_machine()
+	c := NewBanks(16, 0x8000, 0x9000)
+	n.Attach(c)
+	if err := n.Restore(bytes.NewReader(buf.Bytes())); err != nil {
+		t.Fatal(err)
+	}
+	if !c.Select(n, 0, 5) || *n.Mem(0x8000) != 0x1234 || *n.Mem(0x8003) != 0x55 {
+		t.Error("banks aren't restored")
+	}
+	n = mk_machine()
+	n.Attach(NewBanks(16, 0x8000))
+	if err := n.Restore(bytes.NewReader(buf.Bytes())); err == nil {
+		t.Error("restored banks with different windows")
+	}
+}
+
+func TestNewBanks(t *testing.T) {
+	t.Parallel()
+	checks := []struct {
+		n       int
+		windows []Word
+	}{
+		{1, []Word{0x1000, 0x2000}},
+		{4, []Word{0x1800}},
+		{4, []Word{0x1000, 0x1000}},
+		{0x10000, nil},
+	}
+	for _, ck := range checks {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("%v, %v: no panic", ck.n, ck.windows)
+				}
+			}()
+			NewBanks(ck.n, ck.windows...)
+		}()
+	}
+}
+
+// banked returns machine running switching with banks attached and mapped.
+func banked() (*Machine, *Banks) {
+	m := mk_machine()
+	b := NewBanks(16, 0x8000, 0x9000)
+	m.Attach(b)
+	m.Map(0xff00, 2, b)
+	*b.Mem(m, 5, 3) = 0x55
+	m.Load(switching)
+	return m, b
+}
+
+func TestBanksHistory(t *testing.T) {
+	t.Parallel()
+	m, b := banked()
+	before := state_of(m)
+	saved := append([]Word(nil), b.store...)
+	h := m.Track(0)
+	if msg, _ := exec_until_interrupt(m, 100); msg != 9 {
+		t.Fatalf("stopped with %v, want 9", msg)
+	}
+	for h.Back() {
+	}
+	if !state_of(m).equal(before) {
+		t.Error("memory or registers aren't stepped back")
+	}
+	if b.Bank(0) != 0 || b.Bank(1) != 1 {
+		t.Errorf("banks are %v, %v, want 0, 1", b.Bank(0), b.Bank(1))
+	}
+	for i := range saved {
+		if b.store[i] != saved[i] {
+			t.Fatalf("backing store differs at %04x", i)
+		}
+	}
+}
+
+func TestBanksReplay(t *testing.T) {
+	t.Parallel()
+	m, b := banked()
+	var log bytes.Buffer
+	rec, err := m.Record(&log)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if msg, _ := exec_until_interrupt(m, 100); msg != 9 {
+		t.Fatalf("stopped with %v, want 9", msg)
+	}
+	if err := rec.Close(); err != nil {
+		t.Fatal(err)
+	}
+	n := new(Machine)
+	c := NewBanks(16, 0x8000, 0x9000)
+	n.Attach(c)
+	n.Map(0xff00, 2, c)
+	if err := n.Replay(bytes.NewReader(log.Bytes())); err != nil {
+		t.Fatal(err)
+	}
+	for n.Replaying() {
+		if _, _, err := n.Step(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if !same_state(m, n) || c.Bank(0) != b.Bank(0) ||
+		*c.Mem(n, 5, 0) != 0x1234 {
+		t.Error("replayed switches differ")
+	}
+}
+
+func TestBanksReset(t *testing.T) {
+	t.Parallel()
+	m, b := banked()
+	if msg, _ := exec_until_interrupt(m, 100); msg != 9 {
+		t.Fatalf("stopped with %v, want 9", msg)
+	}
+	m.Reset()
+	if b.Bank(0) != 0 || b.Bank(1) != 1 {
+		t.Errorf("banks are %v, %v, want 0, 1", b.Bank(0), b.Bank(1))
+	}
+	if *b.Mem(m, 5, 0) != 0 || *b.Mem(m, 5, 3) != 0 {
+		t.Error("banks aren't cleared")
+	}
+}
@@ -0,0 +1,104 @@
+package machine
+
+import "sync"
+
+// Translation is an image translated to Go by package translate. Passed
+// to Run, its blocks are run instead of stepping through the instructions
+// they translate.
+type Translation struct {
+	Text   []Word  // image translated, loaded at address zero
+	Blocks []Block // basic blocks of the image
+
+	once  sync.Once
+	index []int32 // numbers of blocks by address, -1 if there is none
+}
+
+// Block is a basic block of a translated image.
+type Block struct {
+	Addr   Word   // address of the first instruction
+	Size   Word   // words of code, including immediate words
+	Steps  uint64 // number of instructions
+	Cycles uint64 // cycles taken by the instructions, counting a jump taken
+
+	// Run executes instructions of the block on c as Step would and
+	// returns the number of instructions executed. Instructions which
+	// access memory-mapped words or store into the block end it early,
+	// with PC at the next instruction.
+	Run func(c *Core) uint64
+}
+
+// Core is the state of a machine accessed by translated blocks.
+type Core struct {
+	R      *[32]Word // general registers
+	C      *[8]Word  // control registers
+	Cycles *uint64   // cycles elapsed
+	m      *Machine
+}
+
+// Load reads memory at i for an instruction. It reports false if the
+// word is memory-mapped, and the device may have changed the machine.
+func (c *Core) Load(i Word) (Word, bool) {
+	if c.m.mmio != nil {
+		if r := c.m.mmio.find(i); r != nil {
+			return r.h.Load(c.m, i-r.base), false
+		}
+	}
+	return c.m.text[i], true
+}
+
+// Store writes w to memory at i for an instruction, and reports false like
+// Load.
+func (c *Core) Store(i, w Word) bool {
+	if c.m.mmio != nil {
+		if r := c.m.mmio.find(i); r != nil {
+			r.h.Store(c.m, i-r.base, w)
+			return false
+		}
+	}
+	c.m.text[i] = w
+	return true
+}
+
+// find returns block at address i, or nil.
+func (t *Translation) find(i Word) *Block {
+	t.once.Do(func() {
+		t.index = make([]int32, 0x10000)
+		for i := range t.index {
+			t.index[i] = -1
+		}
+		for n, b := range t.Blocks {
+			t.index[b.Addr] = int32(n)
+		}
+	})
+	if n := t.index[i]; n >= 0 {
+		return &t.Blocks[n]
+	}
+	return nil
+}
+
+// block returns block of t to run at PC, or nil if the machine has to
+// step. Blocks are not run when the step would dispatch an interrupt, hit
+// a breakpoint, be recorded, replayed or kept in history, or be checked by
+// memory protection, and when code of the block is changed in memory.
+func (m *Machine) block(t *Translation) *Block {
+	if m.debug != nil || m.journal != nil || m.history != nil ||
+		m.pending() || m.mpu != nil && m.mpu.active(m) {
+		return nil
+	}
+	b := t.find(*m.PC())
+	if b == nil {
+		return nil
+	}
+	text := m.text[b.Addr:]
+	for i, w := range t.Text[b.Addr:][:b.Size] {
+		if text[i] != w {
+			return nil
+		}
+	}
+	return b
+}
+
+// core returns state of the machine for translated blocks.
+func (m *Machine) core() *Core {
+	return &Core{&m.regs, &m.ctrl, &m.cycles, m}
+}
@@ -0,0 +1,219 @@
+package machine
+
+import "fmt"
+
+// BreakKind tells what triggers a breakpoint.
+type BreakKind int
+
+const (
+	BreakExec  BreakKind = iota // instruction at Addr is to be executed
+	BreakCond                   // any instruction is to be executed
+	BreakRead                   // instruction reads memory word Addr
+	BreakWrite                  // instruction writes memory word Addr
+	BreakReg                    // general register Addr changes
+	BreakCtrl                   // control register Addr changes
+)
+
+// Breakpoint stops the machine when triggered, if Cond is nil or returns
+// true. Breakpoints of kinds BreakExec and BreakCond trigger before the
+// instruction is executed, and the others, also known as watchpoints,
+// after. Only instructions are watched: memory accessed by devices and the
+// host and immediate words fetched by IMP instructions are not.
+type Breakpoint struct {
+	Kind BreakKind
+	Addr Word
+	Cond func(m *Machine) bool
+}
+
+func (bp *Breakpoint) String() string {
+	switch bp.Kind {
+	case BreakExec:
+		return fmt.Sprintf("breakpoint at %04x", uint16(bp.Addr))
+	case BreakCond:
+		return "conditional breakpoint"
+	case BreakRead:
+		return fmt.Sprintf("read watchpoint at %04x", uint16(bp.Addr))
+	case BreakWrite:
+		return fmt.Sprintf("write watchpoint at %04x", uint16(bp.Addr))
+	case BreakReg:
+		return fmt.Sprintf("watchpoint on r%d", bp.Addr)
+	case BreakCtrl:
+		return fmt.Sprintf("watchpoint on c%d", bp.Addr)
+	}
+	return fmt.Sprintf("BreakKind(%d) breakpoint", int(bp.Kind))
+}
+
+// Hit is returned by Step when a breakpoint is hit. The instruction is not
+// executed if the breakpoint triggers before execution; stepping again
+// executes it without hitting the breakpoint.
+type Hit struct {
+	Breakpoint *Breakpoint
+	PC         Word // address of the instruction
+}
+
+func (h *Hit) Error() string {
+	return fmt.Sprintf("%v hit at %04x", h.Breakpoint, uint16(h.PC))
+}
+
+// debugger keeps breakpoints of the machine, indexed by kind.
+type debugger struct {
+	all    []*Breakpoint
+	exec   map[Word][]*Breakpoint
+	read   map[Word][]*Breakpoint
+	write  map[Word][]*Breakpoint
+	conds  []*Breakpoint
+	regs   []*Breakpoint // BreakReg and BreakCtrl
+	before struct {
+		regs [32]Word
+		ctrl [8]Word
+	}
+	hit    *Hit
+	skip   bool // the next step resumes from breakpoint at skipPC
+	skipPC Word
+}
+
+// Break sets breakpoint bp.
+func (m *Machine) Break(bp *Breakpoint) {
+	switch bp.Kind {
+	case BreakReg:
+		m.R(bp.Addr) // check register
+	case BreakCtrl:
+		m.C(bp.Addr)
+	}
+	var all []*Breakpoint
+	if m.debug != nil {
+		all = m.debug.all
+	}
+	m.setBreakpoints(append(all, bp))
+}
+
+// Unbreak removes breakpoint bp, reporting whether it was set.
+func (m *Machine) Unbreak(bp *Breakpoint) bool {
+	if m.debug == nil {
+		return false
+	}
+	for i, b := range m.debug.all {
+		if b == bp {
+			all := append([]*Breakpoint(nil), m.debug.all[:i]...)
+			m.setBreakpoints(append(all, m.debug.all[i+1:]...))
+			return true
+		}
+	}
+	return false
+}
+
+// Breakpoints returns breakpoints set.
+func (m *Machine) Breakpoints() []*Breakpoint {
+	if m.debug == nil {
+		return nil
+	}
+	return append([]*Breakpoint(nil), m.debug.all...)
+}
+
+// setBreakpoints indexes breakpoints all.
+func (m *Machine) setBreakpoints(all []*Breakpoint) {
+	if len(all) == 0 {
+		m.debug = nil
+		return
+	}
+	d := &debugger{
+		all:   all,
+		exec:  make(map[Word][]*Breakpoint),
+		read:  make(map[Word][]*Breakpoint),
+		write: make(map[Word][]*Breakpoint),
+	}
+	if m.debug != nil {
+		d.skip, d.skipPC = m.debug.skip, m.debug.skipPC
+	}
+	for _, bp := range all {
+		switch bp.Kind {
+		case BreakExec:
+			d.exec[bp.Addr] = append(d.exec[bp.Addr], bp)
+		case BreakCond:
+			d.conds = append(d.conds, bp)
+		case BreakRead:
+			d.read[bp.Addr] = append(d.read[bp.Addr], bp)
+		case BreakWrite:
+			d.write[bp.Addr] = append(d.write[bp.Addr], bp)
+		case BreakReg, BreakCtrl:
+			d.regs = append(d.regs, bp)
+		}
+	}
+	m.debug = d
+}
+
+// triggered returns the first of bps whose condition holds.
+func triggered(m *Machine, bps []*Breakpoint) *Breakpoint {
+	for _, bp := range bps {
+		if bp.Cond == nil || bp.Cond(m) {
+			return bp
+		}
+	}
+	return nil
+}
+
+// begin is called before the step, and returns hit of a breakpoint
+// triggered by the instruction to be executed.
+func (d *debugger) begin(m *Machine) *Hit {
+	d.hit = nil
+	pc := *m.PC()
+	if m.pending() {
+		pc = *m.C(IA)
+	}
+	if d.skip && d.skipPC == pc {
+		d.skip = false
+	} else {
+		d.skip = false
+		bp := triggered(m, d.exec[pc])
+		if bp == nil {
+			bp = triggered(m, d.conds)
+		}
+		if bp != nil {
+			d.skip, d.skipPC = true, pc
+			return &Hit{bp, pc}
+		}
+	}
+	if len(d.regs) != 0 {
+		d.before.regs, d.before.ctrl = m.regs, m.ctrl
+	}
+	return nil
+}
+
+// access is called when an instruction reads or writes memory word i.
+func (d *debugger) access(m *Machine, i Word, write bool) {
+	bps := d.read[i]
+	if write {
+		bps = d.write[i]
+	}
+	if d.hit == nil && len(bps) != 0 {
+		if bp := triggered(m, bps); bp != nil {
+			d.hit = &Hit{bp, m.fault.addr}
+		}
+	}
+}
+
+// end is called after the step, and returns hit of a watchpoint
+// triggered by the instruction.
+func (d *debugger) end(m *Machine) error {
+	hit := d.hit
+	d.hit = nil
+	for _, bp := range d.regs {
+		if hit != nil {
+			break
+		}
+		var changed bool
+		switch bp.Kind {
+		case BreakReg:
+			changed = d.before.regs[bp.Addr] != m.regs[bp.Addr]
+		case BreakCtrl:
+			changed = d.before.ctrl[bp.Addr] != m.ctrl[bp.Addr]
+		}
+		if changed && (bp.Cond == nil || bp.Cond(m)) {
+			hit = &Hit{bp, m.fault.addr}
+		}
+	}
+	if hit == nil {
+		return nil
+	}
+	return hit
+}
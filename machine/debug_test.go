@@ -0,0 +1,140 @@
+package machine
+
+import (
+	"context"
+	"testing"
+)
+
+// fib_text computes fib(a0) into v0 and sends hardware interrupt 9.
+var fib_text = []Word{
+	i2(OP_IMP, IMP_MOV, A+0),
+	9,
+	i2(OP_IMP, IMP_SRL, RA),
+	5,
+	i1(OP_HWI, 9),
+	i2(OP_MOV, V+0, ZR), // :fib
+	i2(OP_IMP, IMP_MOV, V+1),
+	1,
+	i2(OP_CMP, A+0, ZR),
+	i1(OP_JEQ, 8),
+	i2(OP_MOV, T+0, V+0), // :_loop
+	i2(OP_ADD, T+0, V+1),
+	i2(OP_MOV, V+0, V+1),
+	i2(OP_MOV, V+1, T+0),
+	i2(OP_INC, A+0, -1),
+	i2(OP_CMP, A+0, ZR),
+	i1(OP_JGT, -7),
+	i2(OP_SRL, ZR, RA), // :_ret
+}
+
+func TestBreakpoint(t *testing.T) {
+	t.Parallel()
+	m := mk_machine()
+	m.Load(fib_text)
+	bp := &Breakpoint{Kind: BreakExec, Addr: 10}
+	m.Break(bp)
+	for _, v0 := range []Word{0, 1, 1, 2, 3, 5, 8, 13, 21} {
+		r := m.Run(context.Background(), RunOptions{Steps: 100})
+		if r.Kind != StopBreakpoint || r.PC != 10 {
+			t.Fatalf("stopped with %v, want breakpoint at 10", r)
+		}
+		if hit := r.Err.(*Hit); hit.Breakpoint != bp || hit.PC != 10 {
+			t.Fatalf("hit is %v", hit)
+		}
+		if v := *m.R(V + 0); v != v0 {
+			t.Fatalf("v0 is %v at the breakpoint, want %v", v, v0)
+		}
+	}
+	if !m.Unbreak(bp) || m.Unbreak(bp) || len(m.Breakpoints()) != 0 {
+		t.Error("breakpoint isn't removed once")
+	}
+	if r := m.Run(context.Background(), RunOptions{Steps: 100}); r.Kind != StopSteps {
+		t.Errorf("stopped with %v after removing breakpoint", r)
+	}
+}
+
+func TestBreakpointImmediate(t *testing.T) {
+	t.Parallel()
+	m := mk_machine()
+	m.Load(fib_text)
+	m.Break(&Breakpoint{Kind: BreakExec, Addr: 1})
+	m.Break(&Breakpoint{Kind: BreakRead, Addr: 7})
+	if r := m.Run(context.Background(), RunOptions{}); r.Kind != StopInterrupt {
+		t.Fatalf("stopped with %v, want interrupt", r)
+	}
+	// jumping into the immediate word executes it
+	*m.PC() = 1
+	if r := m.Run(context.Background(), RunOptions{}); r.Kind != StopBreakpoint {
+		t.Fatalf("stopped with %v, want breakpoint", r)
+	}
+}
+
+func TestBreakpointCondition(t *testing.T) {
+	t.Parallel()
+	m := mk_machine()
+	m.Load(fib_text)
+	// r22 == 0 && pc in fib
+	m.Break(&Breakpoint{Kind: BreakCond, Cond: func(m *Machine) bool {
+		pc := *m.PC()
+		return *m.R(22) == 0 && pc >= 5 && pc < 18
+	}})
+	r := m.Run(context.Background(), RunOptions{})
+	if r.Kind != StopBreakpoint || r.PC != 15 {
+		t.Fatalf("stopped with %v, want breakpoint at 15", r)
+	}
+	if r := m.Run(context.Background(), RunOptions{}); r.PC != 16 {
+		t.Fatalf("resumed run stopped with %v, want breakpoint at 16", r)
+	}
+}
+
+func TestWatchpoint(t *testing.T) {
+	t.Parallel()
+	text := []Word{
+		i2(OP_IMP, IMP_MOV, S+0),
+		0x100,
+		i2(OP_STR, S+0, S+0),
+		i2(OP_LOA, S+1, S+0),
+		i2(OP_IMP, IMP_MTC, IA),
+		0x20,
+		i2(OP_MOM, S+0, S+0),
+		i1(OP_HWI, 9),
+	}
+	checks := []struct {
+		bp  *Breakpoint
+		pcs []Word
+	}{
+		{&Breakpoint{Kind: BreakWrite, Addr: 0x100}, []Word{2, 6}},
+		{&Breakpoint{Kind: BreakRead, Addr: 0x100}, []Word{3, 6}},
+		{&Breakpoint{Kind: BreakReg, Addr: S + 1}, []Word{3}},
+		{&Breakpoint{Kind: BreakCtrl, Addr: IA}, []Word{4}},
+		{&Breakpoint{Kind: BreakWrite, Addr: 0x100, Cond: func(m *Machine) bool {
+			return *m.C(IA) != 0
+		}}, []Word{6}},
+	}
+	for _, ck := range checks {
+		m := mk_machine()
+		m.Load(text)
+		m.Break(ck.bp)
+		var pcs []Word
+		r := m.Run(context.Background(), RunOptions{})
+		for ; r.Kind == StopBreakpoint; r = m.Run(context.Background(), RunOptions{}) {
+			hit := r.Err.(*Hit)
+			pcs = append(pcs, hit.PC)
+			// watchpoints stop after the instruction
+			if want := hit.PC + m.Text(hit.PC).Size(); r.PC != want {
+				t.Errorf("%v: stopped at %v, want %v", ck.bp, r.PC, want)
+			}
+		}
+		if r.Kind != StopInterrupt || len(pcs) != len(ck.pcs) {
+			t.Errorf("%v: hit at %v, then %v, want hits at %v",
+				ck.bp, pcs, r, ck.pcs)
+			continue
+		}
+		for i := range pcs {
+			if pcs[i] != ck.pcs[i] {
+				t.Errorf("%v: hit at %v, want %v", ck.bp, pcs, ck.pcs)
+				break
+			}
+		}
+	}
+}
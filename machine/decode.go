@@ -0,0 +1,42 @@
+package machine
+
+// decoded is an instruction predecoded for execution.
+type decoded struct {
+	f      OpFunc // operator, nil for illegal opcodes
+	text   Word   // instruction word decoded
+	x, y   Word   // operands
+	cycles Word
+}
+
+// decodeCache keeps predecoded instructions by address.
+type decodeCache [0x10000]decoded
+
+// predecode decodes instruction i.
+func predecode(i Instruction) decoded {
+	d := decoded{text: Word(i), cycles: Word(i.Cycles())}
+	op := i.Op()
+	if int(op) < len(op_funcs) {
+		d.f = op_funcs[op]
+	}
+	if op >= OP_IMP && op <= OP_CMN {
+		d.x, d.y = i.A(), i.B()
+	} else {
+		d.x = i.C()
+	}
+	return d
+}
+
+// decode returns predecoded instruction at address i. Cached instructions
+// are decoded again when the word at i is no longer the one they were
+// decoded from, so writes to code by instructions, devices and the host
+// invalidate them however memory is accessed.
+func (m *Machine) decode(i Word) *decoded {
+	if m.cache == nil {
+		m.cache = new(decodeCache)
+	}
+	d := &m.cache[i]
+	if d.text != m.text[i] || d.f == nil {
+		*d = predecode(Instruction(m.text[i]))
+	}
+	return d
+}
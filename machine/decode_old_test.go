@@ -0,0 +1,553 @@
+package machine
+
+// Operators as they were before predecoding, called with operands
+// decoupled into a slice on every step. They are the baseline of
+// TestDecodeSame and BenchmarkStepDecouple.
+
+type old_op_func func(m *Machine, args ...Word)
+
+// get 1 arg
+func old_get1(args []Word) Word {
+	return args[0]
+}
+
+// get 2 args
+func old_get2(args []Word) (Word, Word) {
+	return args[0], args[1]
+}
+
+// get 2 registers
+func old_get2r(args []Word, m *Machine) (*Word, *Word) {
+	return m.R(args[0]), m.R(args[1])
+}
+
+// get register and the next word
+func old_get2rn(args []Word, m *Machine) (*Word, Word) {
+	return m.R(args[0]), *m.Mem(*m.PC())
+}
+
+// Ordinary operators.
+var old_op_funcs = []old_op_func{
+	OP_IMP: func(m *Machine, args ...Word) {
+		a, b := old_get2(args)
+		if int(a) >= len(old_imp_funcs) || old_imp_funcs[a] == nil {
+			m.trap(TRAP_SUBOPCODE)
+			return
+		}
+		old_imp_funcs[a](m, b)
+		*m.PC()++
+	},
+	OP_MOV: func(m *Machine, args ...Word) {
+		a, b := old_get2r(args, m)
+		*a = *b
+	},
+	OP_MTC: func(m *Machine, args ...Word) {
+		k, b := old_get2(args)
+		md := k >> 3
+		kr := k & 7
+		if m.reservedp(kr) {
+			m.trap(TRAP_RESERVED)
+			return
+		}
+		if privilegedp(kr) && !m.supervisor() {
+			return
+		}
+
+		m.mpuRead(kr)
+		switch md {
+		case AM_SET:
+			*m.C(kr) = *m.R(b)
+		case AM_AND:
+			*m.C(kr) &= *m.R(b)
+		case AM_IOR:
+			*m.C(kr) |= *m.R(b)
+		case AM_XOR:
+			*m.C(kr) ^= *m.R(b)
+		}
+		m.mpuWrite(kr)
+	},
+	OP_MFC: func(m *Machine, args ...Word) {
+		a, k := old_get2(args)
+		md := k >> 3
+		kr := k & 7
+		if m.reservedp(kr) {
+			m.trap(TRAP_RESERVED)
+			return
+		}
+
+		m.mpuRead(kr)
+		switch md {
+		case AM_SET:
+			*m.R(a) = *m.C(kr)
+		case AM_AND:
+			*m.R(a) &= *m.C(kr)
+		case AM_IOR:
+			*m.R(a) |= *m.C(kr)
+		case AM_XOR:
+			*m.R(a) ^= *m.C(kr)
+		}
+	},
+	OP_STR: func(m *Machine, args ...Word) {
+		a, b := old_get2r(args, m)
+		m.store(*a, *b)
+	},
+	OP_PSH: func(m *Machine, args ...Word) {
+		a, b := old_get2r(args, m)
+		*a--
+		m.store(*a, *b)
+	},
+	OP_LOA: func(m *Machine, args ...Word) {
+		a, b := old_get2r(args, m)
+		*a = m.load(*b)
+	},
+	OP_POP: func(m *Machine, args ...Word) {
+		a, b := old_get2r(args, m)
+		*a = m.load(*b)
+		*b++
+	},
+	OP_MOM: func(m *Machine, args ...Word) {
+		a, b := old_get2r(args, m)
+		m.store(*a, m.load(*b))
+		*a++
+		*b++
+	},
+
+	OP_SRL: func(m *Machine, args ...Word) {
+		a, b := old_get2r(args, m)
+		*a = *m.PC()
+		*m.PC() = *b
+	},
+
+	OP_ADD: func(m *Machine, args ...Word) {
+		a, b := old_get2r(args, m)
+		ex := m.C(EX)
+		r := uint32(*a) + uint32(*b)
+		*ex = Word(r >> 16)
+		*a = Word(r)
+	},
+	OP_ADX: func(m *Machine, args ...Word) {
+		a, b := old_get2r(args, m)
+		ex := m.C(EX)
+		r := uint32(*a) + uint32(*b) + uint32(*ex)
+		*ex = Word(r >> 16)
+		*a = Word(r)
+	},
+	OP_SUB: func(m *Machine, args ...Word) {
+		a, b := old_get2r(args, m)
+		ex := m.C(EX)
+		r := uint32(*a) - uint32(*b)
+		*ex = Word(r >> 16)
+		*a = Word(r)
+	},
+	OP_SBX: func(m *Machine, args ...Word) {
+		a, b := old_get2r(args, m)
+		ex := m.C(EX)
+		r := uint32(*a) - uint32(*b) + uint32(*ex)
+		*ex = Word(r >> 16)
+		*a = Word(r)
+	},
+	OP_MUL: func(m *Machine, args ...Word) {
+		a, b := old_get2r(args, m)
+		ex := m.C(EX)
+		r := uint32(*a) * uint32(*b)
+		*ex = Word(r >> 16)
+		*a = Word(r)
+	},
+	OP_MLI: func(m *Machine, args ...Word) {
+		a, b := old_get2r(args, m)
+		ex := m.C(EX)
+		r := int32(*a) * int32(*b)
+		*ex = Word(r >> 16)
+		*a = Word(r)
+	},
+	OP_DIV: func(m *Machine, args ...Word) {
+		a, b := old_get2r(args, m)
+		ex := m.C(EX)
+		if *b == 0 {
+			*a, *ex = 0xffff, 0
+			return
+		}
+		r := uint32(*a) << 16 / uint32(*b)
+		*ex = Word(r)
+		*a = Word(r >> 16)
+	},
+	OP_DVI: func(m *Machine, args ...Word) {
+		a, b := old_get2r(args, m)
+		ex := m.C(EX)
+		if *b == 0 {
+			*a, *ex = 0xffff, 0
+			return
+		}
+		r := int32(*a) << 16 / int32(*b)
+		*ex = Word(r)
+		*a = Word(r >> 16)
+	},
+	OP_MOD: func(m *Machine, args ...Word) {
+		a, b := old_get2r(args, m)
+		if *b == 0 {
+			*a = *b
+			return
+		}
+		*a %= *b
+	},
+	OP_MDI: func(m *Machine, args ...Word) {
+		a, b := old_get2r(args, m)
+		if *b == 0 {
+			*a = *b
+			return
+		}
+		*a = Word(int16(*a) % int16(*b))
+	},
+	OP_INC: func(m *Machine, args ...Word) {
+		a, b := m.R(args[0]), args[1]
+		*a += b
+	},
+	OP_GBS: func(m *Machine, args ...Word) {
+		a, b := old_get2r(args, m)
+		*a = fls(*b)
+	},
+
+	OP_AND: func(m *Machine, args ...Word) {
+		a, b := old_get2r(args, m)
+		*a &= *b
+	},
+	OP_IOR: func(m *Machine, args ...Word) {
+		a, b := old_get2r(args, m)
+		*a |= *b
+	},
+	OP_XOR: func(m *Machine, args ...Word) {
+		a, b := old_get2r(args, m)
+		*a ^= *b
+	},
+	OP_BIC: func(m *Machine, args ...Word) {
+		a, b := old_get2r(args, m)
+		*a &^= *b
+	},
+	OP_SHL: func(m *Machine, args ...Word) {
+		a, b := old_get2r(args, m)
+		ex := m.C(EX)
+		r := uint32(*a) << uint32(*b)
+		*ex = Word(r >> 16)
+		*a = Word(r)
+	},
+	OP_ASR: func(m *Machine, args ...Word) {
+		a, b := old_get2r(args, m)
+		ex := m.C(EX)
+		r := int32(*a) << 16 >> uint32(*b)
+		*ex = Word(r)
+		*a = Word(r >> 16)
+	},
+	OP_SHR: func(m *Machine, args ...Word) {
+		a, b := old_get2r(args, m)
+		ex := m.C(EX)
+		r := uint32(*a) << 16 >> uint32(*b)
+		*ex = Word(r)
+		*a = Word(r >> 16)
+	},
+	OP_ROL: func(m *Machine, args ...Word) {
+		a, b := m.R(args[0]), args[1]
+		r := uint32(*a) << uint32(b)
+		*a = Word(r) | Word(r>>16)
+	},
+	OP_ROR: func(m *Machine, args ...Word) {
+		a, b := m.R(args[0]), args[1]
+		r := uint32(*a) << 16 >> uint32(b)
+		*a = Word(r) | Word(r>>16)
+	},
+
+	OP_TST: func(m *Machine, args ...Word) {
+		a, b := old_get2r(args, m)
+		ex := m.C(EX)
+		*ex = *a & *b
+	},
+	OP_TEQ: func(m *Machine, args ...Word) {
+		a, b := old_get2r(args, m)
+		ex := m.C(EX)
+		*ex = *a ^ *b
+	},
+	OP_CMP: func(m *Machine, args ...Word) {
+		a, b := old_get2r(args, m)
+		ex := m.C(EX)
+		*ex = *a - *b
+	},
+	OP_CMN: func(m *Machine, args ...Word) {
+		a, b := old_get2r(args, m)
+		ex := m.C(EX)
+		*ex = *a + *b
+	},
+
+	OP_JMP: func(m *Machine, args ...Word) {
+		c := args[0]
+		m.jump(c)
+	},
+	OP_JLT: func(m *Machine, args ...Word) {
+		c := args[0]
+		ex := m.C(EX)
+		if *ex < 0 {
+			m.jump(c)
+		}
+	},
+	OP_JLE: func(m *Machine, args ...Word) {
+		c := args[0]
+		ex := m.C(EX)
+		if *ex <= 0 {
+			m.jump(c)
+		}
+	},
+	OP_JGT: func(m *Machine, args ...Word) {
+		c := args[0]
+		ex := m.C(EX)
+		if *ex > 0 {
+			m.jump(c)
+		}
+	},
+	OP_JGE: func(m *Machine, args ...Word) {
+		c := args[0]
+		ex := m.C(EX)
+		if *ex >= 0 {
+			m.jump(c)
+		}
+	},
+	OP_JEQ: func(m *Machine, args ...Word) {
+		c := args[0]
+		ex := m.C(EX)
+		if *ex == 0 {
+			m.jump(c)
+		}
+	},
+	OP_JNE: func(m *Machine, args ...Word) {
+		c := args[0]
+		ex := m.C(EX)
+		if *ex != 0 {
+			m.jump(c)
+		}
+	},
+
+	OP_SWI: func(m *Machine, args ...Word) {
+		*m.C(IR) = *m.PC()
+		m.vector(args[0])
+	},
+	OP_HWI: func(m *Machine, args ...Word) {
+		if !m.supervisor() {
+			return
+		}
+		*m.C(IM) = args[0]
+		if m.hwi(args[0]) {
+			return
+		}
+		// no device to accept the interrupt, let the caller handle it
+		m.interrupt.trigger = true
+		m.interrupt.message = args[0]
+	},
+	OP_IRE: func(m *Machine, args ...Word) {
+		if !m.supervisor() {
+			return
+		}
+		*m.PC() = *m.C(IR)
+		(*FlagsRegister)(m.C(FL)).SetI(false)
+		if args[0] != 0 {
+			(*FlagsRegister)(m.C(FL)).SetS(false)
+		}
+	},
+}
+
+// Immediate operand operators
+var old_imp_funcs = []old_op_func{
+	IMP_BRK: func(m *Machine, args ...Word) {
+		*m.C(IR) = *m.PC()
+		m.vector(0xffff)
+		*m.PC()-- // compensate OP_IMP incrementing PC by one
+		m.brk = true
+	},
+	IMP_MOV: func(m *Machine, args ...Word) {
+		a, n := old_get2rn(args, m)
+		*a = n
+	},
+	IMP_MTC: func(m *Machine, args ...Word) {
+		k, n := args[0], *m.Mem(*m.PC())
+		md := k >> 3
+		kr := k & 7
+		if m.reservedp(kr) {
+			m.trap(TRAP_RESERVED)
+			return
+		}
+		if privilegedp(kr) && !m.supervisor() {
+			return
+		}
+
+		m.mpuRead(kr)
+		switch md {
+		case AM_SET:
+			*m.C(kr) = n
+		case AM_AND:
+			*m.C(kr) &= n
+		case AM_IOR:
+			*m.C(kr) |= n
+		case AM_XOR:
+			*m.C(kr) ^= n
+		}
+		m.mpuWrite(kr)
+	},
+
+	IMP_STR: func(m *Machine, args ...Word) {
+		a, n := old_get2rn(args, m)
+		m.store(*a, n)
+	},
+	IMP_PSH: func(m *Machine, args ...Word) {
+		a, n := old_get2rn(args, m)
+		*a--
+		m.store(*a, n)
+	},
+
+	IMP_SRL: func(m *Machine, args ...Word) {
+		a, n := old_get2rn(args, m)
+		*a = *m.PC()
+		*m.PC() = n - 1 // compensate OP_IMP incrementing PC by one
+	},
+
+	IMP_ADD: func(m *Machine, args ...Word) {
+		a, n := old_get2rn(args, m)
+		ex := m.C(EX)
+		r := uint32(*a) + uint32(n)
+		*a, *ex = Word(r), Word(r>>16)
+	},
+	IMP_ADX: func(m *Machine, args ...Word) {
+		a, n := old_get2rn(args, m)
+		ex := m.C(EX)
+		r := uint32(*a) + uint32(n) + uint32(*ex)
+		*a, *ex = Word(r), Word(r>>16)
+	},
+	IMP_SUB: func(m *Machine, args ...Word) {
+		a, n := old_get2rn(args, m)
+		ex := m.C(EX)
+		r := uint32(*a) - uint32(n)
+		*a, *ex = Word(r), Word(r>>16)
+	},
+	IMP_SBX: func(m *Machine, args ...Word) {
+		a, n := old_get2rn(args, m)
+		ex := m.C(EX)
+		r := uint32(*a) - uint32(n) + uint32(*ex)
+		*a, *ex = Word(r), Word(r>>16)
+	},
+	IMP_MUL: func(m *Machine, args ...Word) {
+		a, n := old_get2rn(args, m)
+		ex := m.C(EX)
+		r := uint32(*a) * uint32(n)
+		*a, *ex = Word(r), Word(r>>16)
+	},
+	IMP_MLI: func(m *Machine, args ...Word) {
+		a, n := old_get2rn(args, m)
+		ex := m.C(EX)
+		r := int32(*a) * int32(n)
+		*a, *ex = Word(r), Word(r>>16)
+	},
+	IMP_DIV: func(m *Machine, args ...Word) {
+		a, n := old_get2rn(args, m)
+		ex := m.C(EX)
+		if n == 0 {
+			*a, *ex = 0xffff, 0
+			return
+		}
+		r := uint32(*a) << 16 / uint32(n)
+		*a, *ex = Word(r>>16), Word(r)
+	},
+	IMP_DVI: func(m *Machine, args ...Word) {
+		a, n := old_get2rn(args, m)
+		ex := m.C(EX)
+		if n == 0 {
+			*a, *ex = 0xffff, 0
+			return
+		}
+		r := int32(*a) << 16 / int32(n)
+		*a, *ex = Word(r>>16), Word(r)
+	},
+	IMP_MOD: func(m *Machine, args ...Word) {
+		a, n := old_get2rn(args, m)
+		if n == 0 {
+			*a = n
+			return
+		}
+		*a %= n
+	},
+	IMP_MDI: func(m *Machine, args ...Word) {
+		a, n := old_get2rn(args, m)
+		if n == 0 {
+			*a = n
+			return
+		}
+		*a = Word(int16(*a) % int16(n))
+	},
+	IMP_INC: func(m *Machine, args ...Word) {
+		a, n := old_get2rn(args, m)
+		*a += n
+	},
+
+	IMP_AND: func(m *Machine, args ...Word) {
+		a, n := old_get2rn(args, m)
+		*a &= n
+	},
+	IMP_IOR: func(m *Machine, args ...Word) {
+		a, n := old_get2rn(args, m)
+		*a |= n
+	},
+	IMP_XOR: func(m *Machine, args ...Word) {
+		a, n := old_get2rn(args, m)
+		*a ^= n
+	},
+	IMP_BIC: func(m *Machine, args ...Word) {
+		a, n := old_get2rn(args, m)
+		*a &^= n
+	},
+	IMP_SHL: func(m *Machine, args ...Word) {
+		a, n := old_get2rn(args, m)
+		ex := m.C(EX)
+		r := uint32(*a) << uint32(n)
+		*ex = Word(r >> 16)
+		*a = Word(r)
+	},
+	IMP_ASR: func(m *Machine, args ...Word) {
+		a, n := old_get2rn(args, m)
+		ex := m.C(EX)
+		r := int32(*a) << 16 >> uint32(n)
+		*ex = Word(r)
+		*a = Word(r >> 16)
+	},
+	IMP_SHR: func(m *Machine, args ...Word) {
+		a, n := old_get2rn(args, m)
+		ex := m.C(EX)
+		r := uint32(*a) << 16 >> uint32(n)
+		*ex = Word(r)
+		*a = Word(r >> 16)
+	},
+	IMP_ROL: func(m *Machine, args ...Word) {
+		a, n := old_get2rn(args, m)
+		r := uint32(*a) << uint32(n)
+		*a = Word(r) | Word(r>>16)
+	},
+	IMP_ROR: func(m *Machine, args ...Word) {
+		a, n := old_get2rn(args, m)
+		r := uint32(*a) << 16 >> uint32(n)
+		*a = Word(r) | Word(r>>16)
+	},
+
+	IMP_TST: func(m *Machine, args ...Word) {
+		a, n := old_get2rn(args, m)
+		ex := m.C(EX)
+		*ex = *a & n
+	},
+	IMP_TEQ: func(m *Machine, args ...Word) {
+		a, n := old_get2rn(args, m)
+		ex := m.C(EX)
+		*ex = *a ^ n
+	},
+	IMP_CMP: func(m *Machine, args ...Word) {
+		a, n := old_get2rn(args, m)
+		ex := m.C(EX)
+		*ex = *a - n
+	},
+	IMP_CMN: func(m *Machine, args ...Word) {
+		a, n := old_get2rn(args, m)
+		ex := m.C(EX)
+		*ex = *a + n
+	},
+}
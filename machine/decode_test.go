@@ -0,0 +1,167 @@
+package machine
+
+import "testing"
+
+// decouple extracts operator and operands from an instruction, as Step
+// did before predecoding.
+func (i Instruction) decouple() (op Word, args []Word) {
+	op = i.Op()
+	if op >= OP_IMP && op <= OP_CMN {
+		args = []Word{i.A(), i.B()}
+	} else {
+		args = []Word{i.C()}
+	}
+	return
+}
+
+// old_step is Step as it was before predecoding, decoupling the
+// instruction and calling old_op_funcs with operands in a slice.
+func old_step(m *Machine) (interrupt Word, trigger bool, err error) {
+	if m.debug != nil {
+		if hit := m.debug.begin(m); hit != nil {
+			return 0, false, hit
+		}
+	}
+	if m.journal != nil {
+		if err = m.journal.begin(m); err != nil {
+			return 0, false, err
+		}
+	}
+	if m.history != nil {
+		m.history.begin(m)
+	}
+	m.dispatch()
+	if m.journal != nil {
+		if err = m.journal.fetch(m, *m.PC()); err != nil {
+			return 0, false, err
+		}
+	}
+	m.brk = false
+	m.fault.addr = *m.PC()
+	m.cycles += m.Text(*m.PC()).Cycles()
+	if m.mpu != nil {
+		m.mpu.fetch(m, *m.PC())
+	}
+	o, args := (*m.Text(*m.PC())).decouple()
+	*m.R(0) = 0
+	*m.PC()++
+	m.stepping = true
+	switch {
+	case m.fault.trigger:
+	case int(o) < len(old_op_funcs) && old_op_funcs[o] != nil:
+		old_op_funcs[o](m, args...)
+	default:
+		m.trap(TRAP_OPCODE)
+	}
+	m.stepping = false
+	if m.fault.trigger && m.fault.message == TRAP_PROTECTION {
+		m.mpu.rollback(m)
+	}
+	if m.history != nil {
+		m.history.end(m)
+	}
+	if m.journal != nil {
+		if err = m.journal.end(m); err != nil {
+			return 0, false, err
+		}
+	}
+	if m.fault.trigger {
+		return 0, false, m.fail()
+	}
+	if m.debug != nil {
+		err = m.debug.end(m)
+	}
+	if m.interrupt.trigger {
+		m.interrupt.trigger = false
+		return m.interrupt.message, true, err
+	}
+	return 0, false, err
+}
+
+func TestDecodeCache(t *testing.T) {
+	t.Parallel()
+	text := []Word{
+		i2(OP_IMP, IMP_MOV, S+0),
+		4,
+		i2(OP_IMP, IMP_MOV, S+2),
+		i2(OP_INC, S+1, 2),
+		i2(OP_INC, S+1, 1), // :loop
+		i2(OP_STR, S+0, S+2),
+		i2(OP_INC, S+3, 1),
+		i2(OP_IMP, IMP_CMP, S+3),
+		2,
+		i1(OP_JNE, -5),
+		i1(OP_HWI, 9),
+	}
+	m := mk_machine()
+	m.Load(text)
+	if msg, _ := exec_until_interrupt(m, 100); msg != 9 {
+		t.Fatalf("stopped with %v, want 9", msg)
+	}
+	if s1 := *m.R(S + 1); s1 != 3 {
+		t.Errorf("s1 is %v after overwriting code, want 3", s1)
+	}
+	*m.Mem(4) = i2(OP_INC, S+1, 4)
+	*m.PC() = 4
+	m.Step()
+	if s1 := *m.R(S + 1); s1 != 7 {
+		t.Errorf("s1 is %v after host overwrites code, want 7", s1)
+	}
+}
+
+func TestDecodeSame(t *testing.T) {
+	t.Parallel()
+	m, n := mk_machine(), mk_machine()
+	m.Load(fib_text)
+	n.Load(fib_text)
+	for i := 0; i < 1000; i++ {
+		mi, mt, merr := m.Step()
+		ni, nt, nerr := old_step(n)
+		if mi != ni || mt != nt || merr != nil || nerr != nil {
+			t.Fatalf("step %d: got %v, %v, %v, want %v, %v, %v",
+				i, mi, mt, merr, ni, nt, nerr)
+		}
+		if !same_state(m, n) {
+			t.Fatalf("step %d: states differ", i)
+		}
+		if mt {
+			*m.PC(), *n.PC() = 0, 0
+		}
+	}
+}
+
+func TestStepAllocs(t *testing.T) {
+	m := mk_machine()
+	m.Load(fib_text)
+	allocs := testing.AllocsPerRun(1000, func() {
+		if _, t, _ := m.Step(); t {
+			*m.PC() = 0
+		}
+	})
+	if allocs != 0 {
+		t.Errorf("step allocates %v times", allocs)
+	}
+}
+
+// bench_fib executes b.N instructions of fib_text with step.
+func bench_fib(b *testing.B, step func(m *Machine) (Word, bool, error)) {
+	m := mk_machine()
+	m.Load(fib_text)
+	m.decode(0) // allocate the decode cache before timing
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, t, _ := step(m); t {
+			*m.PC() = 0
+		}
+	}
+	b.ReportMetric(float64(b.N)/b.Elapsed().Seconds(), "instr/s")
+}
+
+func BenchmarkStep(b *testing.B) {
+	bench_fib(b, (*Machine).Step)
+}
+
+func BenchmarkStepDecouple(b *testing.B) {
+	bench_fib(b, old_step)
+}
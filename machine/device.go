@@ -0,0 +1,108 @@
+package machine
+
+// Hardware bus requests. Hardware interrupts with other messages are sent
+// to the attached device with the same number.
+const (
+	HWI_COUNT = 0x3fe // a0 := number of attached devices
+	HWI_QUERY = 0x3ff // a0, a1, a2 := vendor, id, version of device a0
+)
+
+// DeviceInfo identifies a device to the program querying the bus.
+type DeviceInfo struct {
+	Vendor  Word
+	ID      Word
+	Version Word
+}
+
+// Device is a peripheral attached to the machine hardware bus.
+type Device interface {
+	// Info returns device identification.
+	Info() DeviceInfo
+	// Interrupt is called when the program sends a hardware interrupt
+	// to the device. The device may access registers and memory of m,
+	// and raise interrupts with m.HWInterrupt.
+	Interrupt(m *Machine)
+}
+
+// Resetter is implemented by devices with state to reset along with the
+// machine.
+type Resetter interface {
+	// Reset returns the device to its initial state. It is called by
+	// Machine.Reset after registers and memory are cleared.
+	Reset(m *Machine)
+}
+
+// Attach attaches device d to the hardware bus and returns its number.
+func (m *Machine) Attach(d Device) Word {
+	if len(m.devices) >= HWI_COUNT {
+		panic("too many devices")
+	}
+	m.devices = append(m.devices, d)
+	return Word(len(m.devices) - 1)
+}
+
+// Devices returns devices attached to the hardware bus, indexed by their
+// numbers.
+func (m *Machine) Devices() []Device {
+	return append([]Device(nil), m.devices...)
+}
+
+// Device returns device number n, or nil if there is no such device.
+func (m *Machine) Device(n Word) Device {
+	if int(n) >= len(m.devices) {
+		return nil
+	}
+	return m.devices[n]
+}
+
+// resetDevices resets attached devices and handlers of memory-mapped
+// regions implementing Resetter. Handlers which are also attached are
+// reset once.
+func (m *Machine) resetDevices() {
+	var done []Resetter
+	reset := func(x interface{}) {
+		r, ok := x.(Resetter)
+		if !ok {
+			return
+		}
+		for _, d := range done {
+			if d == r {
+				return
+			}
+		}
+		done = append(done, r)
+		r.Reset(m)
+	}
+	for _, d := range m.devices {
+		reset(d)
+	}
+	if m.mmio != nil {
+		for _, r := range m.mmio.regions {
+			reset(r.h)
+		}
+	}
+}
+
+// hwi handles hardware interrupt c and reports whether the bus or a device
+// accepted it.
+func (m *Machine) hwi(c Word) bool {
+	switch {
+	case c == HWI_COUNT:
+		*m.R(A + 0) = Word(len(m.devices))
+	case c == HWI_QUERY:
+		var info DeviceInfo
+		if d := m.Device(*m.R(A + 0)); d != nil {
+			info = d.Info()
+		}
+		*m.R(A + 0), *m.R(A + 1), *m.R(A + 2) =
+			info.Vendor, info.ID, info.Version
+	case int(c) < len(m.devices):
+		if m.history != nil {
+			m.history.watch(m)
+		}
+		m.devices[c].Interrupt(m)
+	default:
+		return false
+	}
+	return true
+}
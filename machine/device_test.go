@@ -0,0 +1,105 @@
+package machine
+
+import "testing"
+
+// echo is a device which copies a0 words from memory at a1 to memory at a2
+// and raises interrupt a3 when it's not zero.
+type echo struct {
+	calls int
+}
+
+func (d *echo) Info() DeviceInfo {
+	return DeviceInfo{Vendor: 0x7e57, ID: 0xec40, Version: 2}
+}
+
+func (d *echo) Interrupt(m *Machine) {
+	d.calls++
+	n, src, dst := *m.R(A + 0), *m.R(A + 1), *m.R(A + 2)
+	for i := Word(0); i < n; i++ {
+		*m.Mem(dst + i) = *m.Mem(src + i)
+	}
+	if msg := *m.R(A + 3); msg != 0 {
+		m.HWInterrupt(msg)
+	}
+}
+
+func TestDeviceQuery(t *testing.T) {
+	t.Parallel()
+	m := mk_machine()
+	if n := m.Attach(new(echo)); n != 0 {
+		t.Errorf("first device is #%d, want #0", n)
+	}
+	if n := m.Attach(new(echo)); n != 1 {
+		t.Errorf("second device is #%d, want #1", n)
+	}
+	text := []Word{
+		i1(OP_HWI, HWI_COUNT),
+		i2(OP_MOV, S+0, A+0),
+		i2(OP_IMP, IMP_MOV, A+0),
+		1,
+		i1(OP_HWI, HWI_QUERY),
+		i1(OP_HWI, 9),
+	}
+	m.Load(text)
+	if msg, _ := exec_until_interrupt(m, 10); msg != 9 {
+		t.Fatalf("stopped with %v, want %v", msg, Word(9))
+	}
+	if n := *m.R(S + 0); n != 2 {
+		t.Errorf("device count is %d, want 2", n)
+	}
+	info := [3]Word{*m.R(A + 0), *m.R(A + 1), *m.R(A + 2)}
+	if info != [3]Word{0x7e57, 0xec40, 2} {
+		t.Errorf("device #1 info is %v", info)
+	}
+	// query of a missing device returns zeros
+	*m.R(A + 0), *m.PC() = 5, 4
+	exec_until_interrupt(m, 2)
+	info = [3]Word{*m.R(A + 0), *m.R(A + 1), *m.R(A + 2)}
+	if info != [3]Word{} {
+		t.Errorf("missing device #5 info is %v, want zeros", info)
+	}
+}
+
+func TestDeviceInterrupt(t *testing.T) {
+	t.Parallel()
+	m := mk_machine()
+	d := new(echo)
+	m.Attach(d)
+	if m.Device(0) != d || m.Device(1) != nil || len(m.Devices()) != 1 {
+		t.Fatalf("devices are %v", m.Devices())
+	}
+	text := []Word{
+		i2(OP_IMP, IMP_MOV, T+0),
+		0x20,
+		i2(OP_MTC, IA, T+0),
+		i2(OP_IMP, IMP_MOV, A+0),
+		2,
+		i2(OP_IMP, IMP_MOV, A+1),
+		0x100,
+		i2(OP_IMP, IMP_MOV, A+2),
+		0x200,
+		i1(OP_HWI, 0),
+		i2(OP_IMP, IMP_MOV, A+3),
+		0x42,
+		i1(OP_HWI, 0),
+		0x20: i2(OP_MFC, S+0, IM),
+		i1(OP_HWI, 9),
+		0x100: 0xdead, 0xbeef,
+	}
+	m.Load(text)
+	if msg, _ := exec_until_interrupt(m, 10); msg != 9 {
+		t.Fatalf("stopped with %v, want %v", msg, Word(9))
+	}
+	if im, ir := *m.R(S + 0), *m.C(IR); im != 0x42 || ir != 13 {
+		t.Errorf("im is %v, ir is %v, want 42 and d", im, ir)
+	}
+	if fl := FlagsRegister(*m.C(FL)); !fl.H() || !fl.I() {
+		t.Errorf("fl is %v, want H and I set", fl)
+	}
+	if d.calls != 2 {
+		t.Errorf("device is called %d times, want 2", d.calls)
+	}
+	if *m.Mem(0x200) != 0xdead || *m.Mem(0x201) != 0xbeef {
+		t.Errorf("device copied %v %v", *m.Mem(0x200), *m.Mem(0x201))
+	}
+}
@@ -0,0 +1,80 @@
+package machine
+
+import "fmt"
+
+// Trap messages loaded into IM when an instruction traps.
+const (
+	TRAP_PROTECTION = 0xfffa // memory protection violation
+	TRAP_RESERVED   = 0xfffb // access to reserved control register
+	TRAP_SUBOPCODE  = 0xfffc // illegal IMP subopcode
+	TRAP_OPCODE     = 0xfffd // illegal opcode
+	TRAP_PRIVILEGE  = 0xfffe // privileged instruction in user mode
+)
+
+var trap_strings = map[Word]string{
+	TRAP_PROTECTION: "memory protection violation",
+	TRAP_RESERVED:   "reserved control register",
+	TRAP_SUBOPCODE:  "illegal IMP subopcode",
+	TRAP_OPCODE:     "illegal opcode",
+	TRAP_PRIVILEGE:  "privileged instruction",
+}
+
+// Fault is an instruction fault returned to the host by Step when the
+// machine is not in strict mode. Protection violations are never returned.
+type Fault struct {
+	Code        Word        // trap message, one of TRAP_*
+	PC          Word        // address of the faulting instruction
+	Instruction Instruction // the faulting instruction
+}
+
+func (f *Fault) Error() string {
+	return fmt.Sprintf("%s at %04x: %04x",
+		trap_strings[f.Code], uint16(f.PC), uint16(f.Instruction))
+}
+
+// trap makes the current instruction fault with message msg. Instructions
+// must trap before changing machine state.
+func (m *Machine) trap(msg Word) {
+	m.fault.trigger = true
+	m.fault.message = msg
+}
+
+// fail delivers fault of the current instruction. In strict mode, and for
+// protection violations, which only happen with MPU enabled, it traps
+// through IA with IR set to the address of the faulting instruction.
+// Otherwise PC is restored to that address and the fault is returned.
+func (m *Machine) fail() error {
+	m.fault.trigger = false
+	addr := m.fault.addr
+	if m.Strict || m.fault.message == TRAP_PROTECTION {
+		*m.C(IR) = addr
+		m.vector(m.fault.message)
+		return nil
+	}
+	*m.PC() = addr
+	return &Fault{Code: m.fault.message, PC: addr, Instruction: *m.Text(addr)}
+}
+
+// supervisor reports whether the machine is in supervisor mode. In strict
+// mode, privileged instructions executed in user mode trap.
+func (m *Machine) supervisor() bool {
+	if (*FlagsRegister)(m.C(FL)).S() {
+		return true
+	}
+	if m.Strict {
+		m.trap(TRAP_PRIVILEGE)
+	}
+	return false
+}
+
+// privilegedp reports whether control register k may be written in
+// supervisor mode only. PC and EX are available in user mode.
+func privilegedp(k Word) bool {
+	return k != PC && k != EX
+}
+
+// reservedp reports whether control register k is reserved. MS and MD
+// are reserved unless MPU is attached.
+func (m *Machine) reservedp(k Word) bool {
+	return (k == MS || k == MD) && m.mpu == nil
+}
@@ -0,0 +1,148 @@
+package machine
+
+import "testing"
+
+var faults = []struct {
+	text []Word
+	code Word
+}{
+	{[]Word{i2(0x0a, 1, 2)}, TRAP_OPCODE},
+	{[]Word{i1(0x3e, 0)}, TRAP_OPCODE},
+	{[]Word{i1(0x3f, 0)}, TRAP_OPCODE},
+	{[]Word{i2(OP_IMP, 0x06, 1), 0}, TRAP_SUBOPCODE},
+	{[]Word{i2(OP_MTC, C+2, 1)}, TRAP_RESERVED},
+	{[]Word{i2(OP_MFC, 1, C+3)}, TRAP_RESERVED},
+	{[]Word{i2(OP_IMP, IMP_MTC, AM_IOR<<3|C+3), 1}, TRAP_RESERVED},
+}
+
+func TestFault(t *testing.T) {
+	t.Parallel()
+	for _, ck := range faults {
+		m := mk_machine()
+		m.Load(append([]Word{i2(OP_MOV, 1, 1)}, ck.text...))
+		*m.R(1) = 0x77
+		m.Step()
+		_, _, err := m.Step()
+		f, ok := err.(*Fault)
+		if !ok {
+			t.Errorf("%04x: got error %v, want fault", ck.text[0], err)
+			continue
+		}
+		want := Fault{Code: ck.code, PC: 1, Instruction: Instruction(ck.text[0])}
+		if *f != want {
+			t.Errorf("%04x: got %v, want %v", ck.text[0], f, &want)
+		}
+		if pc, r1 := *m.PC(), *m.R(1); pc != 1 || r1 != 0x77 {
+			t.Errorf("%04x: pc is %v and r1 is %v, want 1 and 77",
+				ck.text[0], pc, r1)
+		}
+	}
+}
+
+func TestFaultTrap(t *testing.T) {
+	t.Parallel()
+	for _, ck := range faults {
+		m := mk_machine()
+		m.Strict = true
+		*m.C(IA) = 0x20
+		m.Load(append([]Word{i2(OP_MOV, 1, 1)}, ck.text...))
+		m.Step()
+		if _, _, err := m.Step(); err != nil {
+			t.Errorf("%04x: got error %v in strict mode",
+				ck.text[0], err)
+			continue
+		}
+		pc, im, ir := *m.PC(), *m.C(IM), *m.C(IR)
+		if pc != 0x20 || im != ck.code || ir != 1 {
+			t.Errorf("%04x: pc, im, ir are %v, %v, %v, want 20, %v, 1",
+				ck.text[0], pc, im, ir, ck.code)
+		}
+	}
+}
+
+func TestFaultError(t *testing.T) {
+	t.Parallel()
+	f := &Fault{Code: TRAP_OPCODE, PC: 0x10, Instruction: Instruction(i1(0x3e, 0))}
+	want := "illegal opcode at 0010: 003e"
+	if s := f.Error(); s != want {
+		t.Errorf("got %q, want %q", s, want)
+	}
+}
+
+func TestIMPShifts(t *testing.T) {
+	t.Parallel()
+	m := mk_machine()
+	text := []Word{
+		i2(OP_IMP, IMP_MOV, A+0),
+		0x8001,
+		i2(OP_IMP, IMP_ROL, A+0),
+		1,
+		i2(OP_IMP, IMP_SHL, A+0),
+		4,
+	}
+	m.Load(text)
+	exec_until_interrupt(m, 3)
+	if a0, ex := *m.R(A + 0), *m.C(EX); a0 != 0x30 || ex != 0 {
+		t.Errorf("a0 is %v and ex is %v, want 30 and 0", a0, ex)
+	}
+}
+
+// divisions are division instructions executed with s0 = 7, s1 = -7 and
+// s2 = 0, with expected results in a and EX.
+var divisions = []struct {
+	text  []Word
+	a, ex Word
+}{
+	{[]Word{i2(OP_DIV, S+0, S+2)}, 0xffff, 0},
+	{[]Word{i2(OP_DVI, S+1, S+2)}, 0xffff, 0},
+	{[]Word{i2(OP_MOD, S+0, S+2)}, 0, 0x55},
+	{[]Word{i2(OP_MDI, S+1, S+2)}, 0, 0x55},
+	{[]Word{i2(OP_IMP, IMP_DIV, S+0), 0}, 0xffff, 0},
+	{[]Word{i2(OP_IMP, IMP_DVI, S+1), 0}, 0xffff, 0},
+	{[]Word{i2(OP_IMP, IMP_MOD, S+0), 0}, 0, 0x55},
+	{[]Word{i2(OP_IMP, IMP_MDI, S+1), 0}, 0, 0x55},
+	{[]Word{i2(OP_DIV, S+0, S+0)}, 1, 0},
+	{[]Word{i2(OP_DVI, S+1, S+0)}, 0xffff, 0},
+	{[]Word{i2(OP_MOD, S+0, S+0)}, 0, 0x55},
+	{[]Word{i2(OP_MDI, S+1, S+0)}, 0, 0x55},
+	{[]Word{i2(OP_IMP, IMP_DIV, S+0), 2}, 3, 0x8000},
+	{[]Word{i2(OP_IMP, IMP_DVI, S+1), 2}, 0xfffc, 0x8000},
+	{[]Word{i2(OP_IMP, IMP_MOD, S+0), 2}, 1, 0x55},
+	{[]Word{i2(OP_IMP, IMP_MDI, S+1), 2}, 0xffff, 0x55},
+}
+
+func TestDivideByZero(t *testing.T) {
+	t.Parallel()
+	for _, ck := range divisions {
+		m := mk_machine()
+		m.Load(ck.text)
+		*m.R(S + 0), *m.R(S + 1), *m.C(EX) = 7, 0xfff9, 0x55
+		if _, _, err := m.Step(); err != nil {
+			t.Errorf("%04x: got error %v", ck.text[0], err)
+			continue
+		}
+		a := *m.R(Instruction(ck.text[0]).A())
+		if ck.text[0]&0x3f == OP_IMP {
+			a = *m.R(Instruction(ck.text[0]).B())
+		}
+		if ex := *m.C(EX); a != ck.a || ex != ck.ex {
+			t.Errorf("%04x: a is %v and ex is %v, want %v and %v",
+				ck.text[0], a, ex, ck.a, ck.ex)
+		}
+	}
+}
+
+func TestBIC(t *testing.T) {
+	t.Parallel()
+	m := mk_machine()
+	m.Load([]Word{i2(OP_BIC, S+0, S+1), i2(OP_IMP, IMP_BIC, S+1), 0x0f00})
+	*m.R(S + 0), *m.R(S + 1) = 0xffff, 0x0ff0
+	for i := 0; i < 2; i++ {
+		if _, _, err := m.Step(); err != nil {
+			t.Fatalf("step %d: %v", i, err)
+		}
+	}
+	if s0, s1 := *m.R(S + 0), *m.R(S + 1); s0 != 0xf00f || s1 != 0x00f0 {
+		t.Errorf("s0 is %v and s1 is %v, want f00f and f0", s0, s1)
+	}
+}
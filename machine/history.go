@@ -0,0 +1,217 @@
+package machine
+
+// History is an undo log of machine steps, used for stepping backward.
+// For every step it keeps registers, interrupt state and old values of
+// memory words written by instructions and by devices handling hardware
+// interrupts. Memory written by the host, stores to memory-mapped regions,
+// MPU registers and state of devices are not kept, and stepping backward
+// does not undo them, except for bank switches of Banks made by steps.
+type History struct {
+	m      *Machine
+	depth  int
+	steps  []undo
+	mem    []memUndo  // old memory words for all steps
+	irqs   []irqQueue // interrupt queues changed by steps
+	fns    []func()   // undos of device state for all steps
+	text   *[0x10000]Word
+	watchp bool // text holds memory before device handled interrupt
+}
+
+// undo is the state of the machine before a step.
+type undo struct {
+	regs    [32]Word
+	ctrl    [8]Word
+	cycles  uint64
+	message Word // host interrupt message
+	brk     bool
+	head, n int // interrupt queue position
+	irq     int // index of queue in History.irqs, or -1 if unchanged
+	mem     int // index of the first memory word in History.mem
+	fn      int // index of the first device undo in History.fns
+}
+
+type memUndo struct {
+	addr Word
+	old  Word
+}
+
+// Track starts keeping history of the machine. At least depth last steps
+// are kept, or all of them if depth is zero.
+func (m *Machine) Track(depth int) *History {
+	h := &History{m: m, depth: depth}
+	m.history = h
+	return h
+}
+
+// Close stops keeping history.
+func (h *History) Close() {
+	if h.m.history == h {
+		h.m.history = nil
+	}
+}
+
+// Len returns number of steps which can be undone.
+func (h *History) Len() int {
+	return len(h.steps)
+}
+
+func (h *History) begin(m *Machine) {
+	if len(h.steps) >= 2*h.depth && h.depth > 0 {
+		h.forget(len(h.steps) - h.depth + 1)
+	}
+	h.steps = append(h.steps, undo{
+		regs:    m.regs,
+		ctrl:    m.ctrl,
+		cycles:  m.cycles,
+		message: m.interrupt.message,
+		brk:     m.brk,
+		head:    m.irq.head,
+		n:       m.irq.n,
+		irq:     -1,
+		mem:     len(h.mem),
+		fn:      len(h.fns),
+	})
+	if m.irq.n != 0 {
+		h.irqs = append(h.irqs, m.irq)
+		h.steps[len(h.steps)-1].irq = len(h.irqs) - 1
+	}
+}
+
+func (h *History) end(m *Machine) {
+	u := &h.steps[len(h.steps)-1]
+	if u.irq >= 0 && u.head == m.irq.head && u.n == m.irq.n {
+		h.irqs = h.irqs[:u.irq]
+		u.irq = -1
+	}
+	if h.watchp {
+		h.watchp = false
+		for i := range h.text {
+			if h.text[i] != m.text[i] {
+				h.mem = append(h.mem, memUndo{Word(i), h.text[i]})
+			}
+		}
+	}
+}
+
+// note keeps memory word i before an instruction writes it.
+func (h *History) note(m *Machine, i Word) {
+	h.mem = append(h.mem, memUndo{i, m.text[i]})
+}
+
+// keep keeps undo of device state changed by the current step.
+func (h *History) keep(undo func()) {
+	h.fns = append(h.fns, undo)
+}
+
+// watch keeps memory before a device handles hardware interrupt.
+func (h *History) watch(m *Machine) {
+	if h.text == nil {
+		h.text = new([0x10000]Word)
+	}
+	*h.text = m.text
+	h.watchp = true
+}
+
+// forget drops n oldest steps.
+func (h *History) forget(n int) {
+	mem, fn, irq := h.steps[n].mem, h.steps[n].fn, len(h.irqs)
+	for _, u := range h.steps[n:] {
+		if u.irq >= 0 {
+			irq = u.irq
+			break
+		}
+	}
+	h.mem = append(h.mem[:0], h.mem[mem:]...)
+	h.fns = append(h.fns[:0], h.fns[fn:]...)
+	h.irqs = append(h.irqs[:0], h.irqs[irq:]...)
+	h.steps = append(h.steps[:0], h.steps[n:]...)
+	for i := range h.steps {
+		u := &h.steps[i]
+		u.mem -= mem
+		u.fn -= fn
+		if u.irq >= 0 {
+			u.irq -= irq
+		}
+	}
+}
+
+// Back undoes the last step, reporting false if there is no such step.
+func (h *History) Back() bool {
+	if len(h.steps) == 0 {
+		return false
+	}
+	m := h.m
+	u := h.steps[len(h.steps)-1]
+	for i := len(h.mem) - 1; i >= u.mem; i-- {
+		m.text[h.mem[i].addr] = h.mem[i].old
+	}
+	for i := len(h.fns) - 1; i >= u.fn; i-- {
+		h.fns[i]()
+		h.fns[i] = nil
+	}
+	h.fns = h.fns[:u.fn]
+	m.regs = u.regs
+	m.ctrl = u.ctrl
+	m.cycles = u.cycles
+	m.interrupt.message = u.message
+	m.brk = u.brk
+	if u.irq >= 0 {
+		m.irq = h.irqs[u.irq]
+		h.irqs = h.irqs[:u.irq]
+	}
+	m.irq.head, m.irq.n = u.head, u.n
+	h.mem = h.mem[:u.mem]
+	h.steps = h.steps[:len(h.steps)-1]
+	return true
+}
+
+// back undoes steps until the step which changed a word, selected by
+// changed with state before the step and state after it. It reports the
+// number of steps undone; if no step changed the word, nothing is undone.
+func (h *History) back(changed func(before, after *undo) bool) (int, bool) {
+	after := undo{regs: h.m.regs, ctrl: h.m.ctrl}
+	for i := len(h.steps) - 1; i >= 0; i-- {
+		if changed(&h.steps[i], &after) {
+			n := len(h.steps) - i
+			for j := 0; j < n; j++ {
+				h.Back()
+			}
+			return n, true
+		}
+		after = h.steps[i]
+	}
+	return 0, false
+}
+
+// BackToR undoes steps until the last step which changed general register
+// r is undone, and reports the number of steps undone. If no step changed
+// r, nothing is undone.
+func (h *History) BackToR(r Word) (int, bool) {
+	h.m.R(r) // check r
+	return h.back(func(before, after *undo) bool {
+		return before.regs[r] != after.regs[r]
+	})
+}
+
+// BackToC is like BackToR, but for control register c.
+func (h *History) BackToC(c Word) (int, bool) {
+	h.m.C(c) // check c
+	return h.back(func(before, after *undo) bool {
+		return before.ctrl[c] != after.ctrl[c]
+	})
+}
+
+// BackToMem is like BackToR, but for memory word i. Every write to the
+// word by instructions counts, even if it does not change the word.
+func (h *History) BackToMem(i Word) (int, bool) {
+	end := len(h.mem)
+	return h.back(func(before, after *undo) bool {
+		for _, w := range h.mem[before.mem:end] {
+			if w.addr == i {
+				return true
+			}
+		}
+		end = before.mem
+		return false
+	})
+}
@@ -0,0 +1,128 @@
+package machine
+
+import "testing"
+
+var stacking = []Word{
+	i2(OP_IMP, IMP_MOV, T+0),
+	0x40,
+	i2(OP_MTC, IA, T+0),
+	i2(OP_IMP, IMP_MOV, S+0),
+	0x100,
+	i2(OP_IMP, IMP_MOV, A+0),
+	1,
+	i2(OP_IMP, IMP_MOV, A+2),
+	0x200,
+	i2(OP_IMP, IMP_MOV, A+3),
+	0x42,
+	i2(OP_INC, S+1, 1), // :loop
+	i2(OP_PSH, S+0, S+1),
+	i2(OP_IMP, IMP_PSH, S+0),
+	0x77,
+	i2(OP_MOV, A+1, S+0),
+	i2(OP_INC, A+1, 1),
+	i1(OP_HWI, 0),
+	i2(OP_POP, S+2, S+0),
+	i2(OP_POP, S+2, S+0),
+	i1(OP_JMP, -9),
+	0x40: i2(OP_INC, S+3, 1),
+	i1(OP_IRE, 0),
+}
+
+type machineState struct {
+	regs   [32]Word
+	ctrl   [8]Word
+	cycles uint64
+	text   [0x10000]Word
+	irq    []Word
+}
+
+func state_of(m *Machine) *machineState {
+	s := &machineState{m.regs, m.ctrl, m.cycles, m.text, nil}
+	for i := 0; i < m.irq.n; i++ {
+		s.irq = append(s.irq, m.irq.msgs[(m.irq.head+i)%IRQ_MAX])
+	}
+	return s
+}
+
+func (s *machineState) equal(t *machineState) bool {
+	if s.regs != t.regs || s.ctrl != t.ctrl || s.cycles != t.cycles ||
+		s.text != t.text || len(s.irq) != len(t.irq) {
+		return false
+	}
+	for i := range s.irq {
+		if s.irq[i] != t.irq[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestHistoryBack(t *testing.T) {
+	t.Parallel()
+	m := mk_machine()
+	m.Attach(new(echo))
+	m.Load(stacking)
+	h := m.Track(0)
+	var states []*machineState
+	for i := 0; i < 60; i++ {
+		if i%7 == 0 {
+			m.HWInterrupt(Word(i))
+		}
+		states = append(states, state_of(m))
+		m.Step()
+	}
+	if h.Len() != 60 {
+		t.Fatalf("history has %d steps, want 60", h.Len())
+	}
+	for i := len(states) - 1; i >= 0; i-- {
+		if !h.Back() {
+			t.Fatalf("can't undo step %d", i)
+		}
+		if !state_of(m).equal(states[i]) {
+			t.Fatalf("state before step %d differs", i)
+		}
+	}
+	if h.Back() {
+		t.Error("undoes step before history")
+	}
+}
+
+func TestHistoryBackTo(t *testing.T) {
+	t.Parallel()
+	m := mk_machine()
+	m.Attach(new(echo))
+	m.Load(stacking)
+	h := m.Track(0)
+	exec_until_interrupt(m, 30)
+	// the last write to 0x200 is by the device
+	if n, ok := h.BackToMem(0x200); !ok || *m.PC() != 17 || n == 0 {
+		t.Errorf("stopped at %v after %d steps, want hwi", *m.PC(), n)
+	}
+	if n, ok := h.BackToMem(0xfe); !ok || *m.PC() != 13 || n == 0 {
+		t.Errorf("stopped at %v after %d steps, want imp psh", *m.PC(), n)
+	}
+	if n, ok := h.BackToR(S + 1); !ok || *m.PC() != 11 || n == 0 {
+		t.Errorf("stopped at %v after %d steps, want inc", *m.PC(), n)
+	}
+	pc, n := *m.PC(), h.Len()
+	if _, ok := h.BackToR(S + 7); ok || *m.PC() != pc || h.Len() != n {
+		t.Error("undoes steps looking for unchanged register")
+	}
+}
+
+func TestHistoryDepth(t *testing.T) {
+	t.Parallel()
+	m := mk_machine()
+	m.Attach(new(echo))
+	m.Load(stacking)
+	h := m.Track(10)
+	exec_until_interrupt(m, 100)
+	if n := h.Len(); n < 10 || n >= 20 {
+		t.Errorf("history has %d steps, want 10 to 19", n)
+	}
+	for h.Back() {
+	}
+	if *m.R(T + 0) != 0x40 {
+		t.Error("undoes steps beyond history depth")
+	}
+}
@@ -58,15 +58,13 @@ func (i Instruction) C() Word { return Word(i >> 6) }
 // Cs is like c, but sign-extends the result to 16 bits
 func (i Instruction) Cs() Word { return sextend10(i.C()) }
 
-// decouple extracts operator and operands from an instruction
-func (i Instruction) decouple() (op Word, args []Word) {
-	op = i.Op()
-	if op >= OP_IMP && op <= OP_CMN {
-		args = []Word{ i.A(), i.B() }
-	} else {
-		args = []Word{ i.C() }
+// Size returns number of words taken by an instruction, counting the
+// immediate word of IMP instructions.
+func (i Instruction) Size() Word {
+	if i.Op() == OP_IMP && i.A() != IMP_BRK {
+		return 2
 	}
-	return
+	return 1
 }
 
 /*
@@ -84,7 +82,7 @@ func kstring(r Word) (str string) {
 	mode := r >> 3
 	kreg := r & 7
 
-	str = fmt.Sprintf("%2i", kreg)
+	str = fmt.Sprintf("%2d", kreg)
 
 	switch mode {
 	case AM_AND:
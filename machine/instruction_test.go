@@ -42,6 +42,6 @@ func TestInstructionNotOverlaps(t *testing.T) {
 		t.Errorf("Op is %x, want %x", op, 0x3f)
 	}
 	if a != 0 || b != 0x1f {
-		t.Errorf("a, b are %x, %x, want %x", a, b, 0, 0x1f)
+		t.Errorf("a, b are %x, %x, want %x, %x", a, b, 0, 0x1f)
 	}
 }
@@ -0,0 +1,85 @@
+package machine
+
+// IRQ_MAX is the capacity of the queue of pending hardware interrupts.
+const IRQ_MAX = 256
+
+// irqQueue is a FIFO of pending hardware interrupts.
+type irqQueue struct {
+	msgs [IRQ_MAX]Word
+	head int // index of the oldest interrupt
+	n    int // number of pending interrupts
+}
+
+// push appends msg to q, reporting false if q is full.
+func (q *irqQueue) push(msg Word) bool {
+	if q.n == len(q.msgs) {
+		return false
+	}
+	q.msgs[(q.head+q.n)%len(q.msgs)] = msg
+	q.n++
+	return true
+}
+
+// pop removes and returns the oldest interrupt in q.
+func (q *irqQueue) pop() (msg Word, ok bool) {
+	if q.n == 0 {
+		return 0, false
+	}
+	msg = q.msgs[q.head]
+	q.head = (q.head + 1) % len(q.msgs)
+	q.n--
+	return msg, true
+}
+
+// HWInterrupt queues hardware interrupt with message i for the program.
+// Devices use it to signal the program. When the queue is full, the
+// interrupt is dropped and HWInterrupt returns false. Interrupts sent by
+// the host between steps are recorded in event logs, and ignored when
+// replaying.
+func (m *Machine) HWInterrupt(i Word) bool {
+	if m.journal != nil && !m.stepping && !m.journal.interrupt(m, i) {
+		return false
+	}
+	return m.irq.push(i)
+}
+
+// PendingInterrupts returns number of queued hardware interrupts.
+func (m *Machine) PendingInterrupts() int {
+	return m.irq.n
+}
+
+// pending reports whether the next step dispatches hardware interrupt.
+func (m *Machine) pending() bool {
+	return m.irq.n != 0 && !(*FlagsRegister)(m.C(FL)).I()
+}
+
+// dispatch delivers the oldest pending hardware interrupt unless I flag is
+// set: IR := PC, PC := IA, IM := message, set I, H and S.
+func (m *Machine) dispatch() {
+	fl := (*FlagsRegister)(m.C(FL))
+	if fl.I() {
+		return
+	}
+	msg, ok := m.irq.pop()
+	if !ok {
+		return
+	}
+	*m.C(IR) = *m.PC()
+	*m.PC() = *m.C(IA)
+	*m.C(IM) = msg
+	m.cycles += INTERRUPT_CYCLES
+	fl.SetI(true)
+	fl.SetH(true)
+	fl.SetS(true)
+}
+
+// vector enters software interrupt handler: PC := IA, IM := msg, set I and
+// S, clear H. IR is set by the caller.
+func (m *Machine) vector(msg Word) {
+	fl := (*FlagsRegister)(m.C(FL))
+	*m.PC() = *m.C(IA)
+	*m.C(IM) = msg
+	fl.SetI(true)
+	fl.SetH(false)
+	fl.SetS(true)
+}
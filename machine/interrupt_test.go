@@ -0,0 +1,165 @@
+package machine
+
+import "testing"
+
+// mk_irq_machine creates machine with interrupt handler at 0x20 pushing
+// interrupt messages to the stack at s0 and main program looping forever.
+func mk_irq_machine() *Machine {
+	m := mk_machine()
+	text := []Word{
+		i2(OP_IMP, IMP_MOV, T+0),
+		0x20,
+		i2(OP_MTC, IA, T+0),
+		i1(OP_JMP, 0),
+		0x20: i2(OP_MFC, T+1, IM),
+		i2(OP_PSH, S+0, T+1),
+		i1(OP_IRE, 0),
+	}
+	m.Load(text)
+	*m.R(S + 0) = 0x100
+	exec_until_interrupt(m, 2)
+	return m
+}
+
+func TestInterruptQueue(t *testing.T) {
+	t.Parallel()
+	m := mk_irq_machine()
+	for _, msg := range []Word{1, 2, 3} {
+		m.HWInterrupt(msg)
+	}
+	exec_until_interrupt(m, 16)
+	if n := m.PendingInterrupts(); n != 0 {
+		t.Errorf("%d interrupts pending, want 0", n)
+	}
+	got := []Word{*m.Mem(0xff), *m.Mem(0xfe), *m.Mem(0xfd)}
+	if got[0] != 1 || got[1] != 2 || got[2] != 3 {
+		t.Errorf("handled interrupts %v, want [1 2 3]", got)
+	}
+	if pc := *m.PC(); pc != 3 {
+		t.Errorf("pc is %v, want 3", pc)
+	}
+}
+
+func TestInterruptMasking(t *testing.T) {
+	t.Parallel()
+	m := mk_irq_machine()
+	fl := (*FlagsRegister)(m.C(FL))
+	fl.SetI(true)
+	m.HWInterrupt(7)
+	exec_until_interrupt(m, 4)
+	if n := m.PendingInterrupts(); n != 1 || *m.R(S + 0) != 0x100 {
+		t.Fatalf("interrupt is not held while I is set")
+	}
+	fl.SetI(false)
+	m.Step()
+	if *m.PC() != 0x21 || *m.C(IM) != 7 || *m.C(IR) != 3 {
+		t.Errorf("pc, im, ir are %v, %v, %v, want 21, 7, 3",
+			*m.PC(), *m.C(IM), *m.C(IR))
+	}
+	if !fl.I() || !fl.H() || !fl.S() {
+		t.Errorf("fl is %v, want I, H and S set", *fl)
+	}
+	// software interrupts are not hardware ones
+	fl.SetI(false)
+	m.Load([]Word{3: i1(OP_SWI, 1)})
+	*m.PC() = 3
+	m.Step()
+	if fl.H() {
+		t.Errorf("H is set after swi")
+	}
+}
+
+func TestInterruptOverflow(t *testing.T) {
+	t.Parallel()
+	m := mk_machine()
+	m.Load([]Word{i1(OP_JMP, 0)})
+	for i := 0; i < IRQ_MAX; i++ {
+		if !m.HWInterrupt(Word(i)) {
+			t.Fatalf("interrupt #%d is dropped", i)
+		}
+	}
+	if m.HWInterrupt(0xbad) {
+		t.Errorf("interrupt is queued when the queue is full")
+	}
+	if n := m.PendingInterrupts(); n != IRQ_MAX {
+		t.Errorf("%d interrupts pending, want %d", n, IRQ_MAX)
+	}
+	m.Step()
+	if *m.C(IM) != 0 {
+		t.Errorf("dispatched %v, want the oldest interrupt 0", *m.C(IM))
+	}
+	m.Reset()
+	if n := m.PendingInterrupts(); n != 0 {
+		t.Errorf("%d interrupts pending after reset", n)
+	}
+}
+
+func TestUserMode(t *testing.T) {
+	t.Parallel()
+	m := new(Machine)
+	*m.R(T + 0) = 0x20
+	text := []Word{
+		i2(OP_MTC, IA, T+0),
+		i2(OP_IMP, IMP_MTC, AM_IOR<<3|FL),
+		0x8001,
+		i2(OP_MTC, EX, T+0),
+		i1(OP_HWI, 9),
+		i1(OP_IRE, 1),
+		i1(OP_SWI, 5),
+	}
+	m.Load(text)
+	if _, t1 := exec_until_interrupt(m, 5); t1 {
+		t.Errorf("hwi is not ignored in user mode")
+	}
+	if ia, fl := *m.C(IA), *m.C(FL); ia != 0 || fl != 0 {
+		t.Errorf("ia is %v and fl is %v, want both unchanged", ia, fl)
+	}
+	if ex := *m.C(EX); ex != 0x20 {
+		t.Errorf("ex is %v, want 20", ex)
+	}
+	m.Step()
+	if pc, im := *m.PC(), *m.C(IM); pc != 0 || im != 5 {
+		t.Errorf("pc is %v and im is %v after swi, want 0 and 5", pc, im)
+	}
+	// supervisor mode is entered with swi
+	m.Load([]Word{i2(OP_IMP, IMP_MTC, IA), 0x30})
+	m.Step()
+	if ia := *m.C(IA); ia != 0x30 {
+		t.Errorf("ia is %v in supervisor mode, want 30", ia)
+	}
+}
+
+func TestStrictMode(t *testing.T) {
+	t.Parallel()
+	for _, text := range [][]Word{
+		{i2(OP_MTC, FL, T+0)},
+		{i2(OP_IMP, IMP_MTC, IR), 0x100},
+		{i1(OP_HWI, 9)},
+		{i1(OP_IRE, 0)},
+	} {
+		m := new(Machine)
+		m.Strict = true
+		*m.C(IA) = 0x20
+		m.Load(append([]Word{i2(OP_MTC, EX, T+0)}, text...))
+		m.Step()
+		if pc := *m.PC(); pc != 1 {
+			t.Errorf("%v: mtc ex traps", Instruction(text[0]))
+			continue
+		}
+		msg, t1, _ := m.Step()
+		if t1 {
+			t.Errorf("%v: interrupt %v sent to host",
+				Instruction(text[0]), msg)
+		}
+		pc, im, ir := *m.PC(), *m.C(IM), *m.C(IR)
+		if pc != 0x20 || im != TRAP_PRIVILEGE || ir != 1 {
+			t.Errorf("%v: pc, im, ir are %v, %v, %v, want 20, %v, 1",
+				Instruction(text[0]), pc, im, ir,
+				Word(TRAP_PRIVILEGE))
+		}
+		if fl := FlagsRegister(*m.C(FL)); !fl.S() || !fl.I() || fl.H() {
+			t.Errorf("%v: fl is %v, want S and I set",
+				Instruction(text[0]), fl)
+		}
+	}
+}
@@ -0,0 +1,99 @@
+package machine
+
+// MMIO handles memory accesses of instructions to a memory-mapped region.
+// Offsets are relative to the start of the region.
+type MMIO interface {
+	// Load returns the word at offset i of the region.
+	Load(m *Machine, i Word) Word
+	// Store writes w at offset i of the region.
+	Store(m *Machine, i, w Word)
+}
+
+// region is a range of memory mapped to a handler.
+type region struct {
+	base Word
+	size int
+	h    MMIO
+}
+
+// mmioMap keeps memory-mapped regions of the machine.
+type mmioMap struct {
+	regions []region
+	pages   [0x100]bool // 256-word pages with mapped words
+}
+
+// find returns region containing address i, or nil.
+func (mm *mmioMap) find(i Word) *region {
+	if !mm.pages[i>>8] {
+		return nil
+	}
+	for n := range mm.regions {
+		r := &mm.regions[n]
+		if i >= r.base && int(i-r.base) < r.size {
+			return r
+		}
+	}
+	return nil
+}
+
+// Map maps size words of memory from base to h. Loads and stores of
+// instructions to the region are handled by h instead of memory. Mem, Text
+// and instruction fetches access memory behind the region. Map panics if
+// the region is empty, wraps past the end of memory or overlaps another.
+func (m *Machine) Map(base Word, size int, h MMIO) {
+	if size <= 0 || int(base)+size > len(m.text) {
+		panic("mmio: bad region")
+	}
+	if m.mmio == nil {
+		m.mmio = new(mmioMap)
+	}
+	for _, r := range m.mmio.regions {
+		if int(base) < int(r.base)+r.size && int(r.base) < int(base)+size {
+			panic("mmio: overlapping regions")
+		}
+	}
+	m.mmio.regions = append(m.mmio.regions, region{base, size, h})
+	m.mmio.index()
+}
+
+// Unmap removes region mapped at base, reporting whether there is one.
+func (m *Machine) Unmap(base Word) bool {
+	if m.mmio == nil {
+		return false
+	}
+	for n, r := range m.mmio.regions {
+		if r.base == base {
+			rs := m.mmio.regions
+			m.mmio.regions = append(rs[:n:n], rs[n+1:]...)
+			if len(m.mmio.regions) == 0 {
+				m.mmio = nil
+			} else {
+				m.mmio.index()
+			}
+			return true
+		}
+	}
+	return false
+}
+
+// Mapped returns handler of memory-mapped address i, or nil if i is not
+// mapped.
+func (m *Machine) Mapped(i Word) MMIO {
+	if m.mmio == nil {
+		return nil
+	}
+	if r := m.mmio.find(i); r != nil {
+		return r.h
+	}
+	return nil
+}
+
+// index marks pages with mapped words.
+func (mm *mmioMap) index() {
+	mm.pages = [0x100]bool{}
+	for _, r := range mm.regions {
+		for p := int(r.base) >> 8; p <= (int(r.base)+r.size-1)>>8; p++ {
+			mm.pages[p] = true
+		}
+	}
+}
@@ -0,0 +1,104 @@
+package machine
+
+import "testing"
+
+// uart is a memory-mapped serial port: loads from offset 0 read input,
+// stores to offset 1 write output, and offset 2 holds the number of
+// words left to read.
+type uart struct {
+	in, out []Word
+}
+
+func (u *uart) Load(m *Machine, i Word) Word {
+	switch i {
+	case 0:
+		if len(u.in) == 0 {
+			return 0
+		}
+		w := u.in[0]
+		u.in = u.in[1:]
+		return w
+	case 2:
+		return Word(len(u.in))
+	}
+	return 0xffff
+}
+
+func (u *uart) Store(m *Machine, i, w Word) {
+	if i == 1 {
+		u.out = append(u.out, w)
+	}
+}
+
+func TestMMIO(t *testing.T) {
+	t.Parallel()
+	m := mk_machine()
+	u := &uart{in: []Word{'h', 'i', '!'}}
+	m.Map(0xff00, 3, u)
+	text := []Word{
+		i2(OP_IMP, IMP_MOV, S+0),
+		0xff00,
+		i2(OP_IMP, IMP_MOV, S+1),
+		0xff01,
+		i2(OP_IMP, IMP_MOV, S+2),
+		0xff02,
+		i2(OP_LOA, T+0, S+0), // :loop
+		i2(OP_STR, S+1, T+0),
+		i2(OP_LOA, T+1, S+2),
+		i2(OP_TST, T+1, T+1),
+		i1(OP_JNE, -4),
+		i2(OP_MOM, S+1, S+2), // copies count and moves pointers
+		i2(OP_IMP, IMP_PSH, S+1),
+		'.',
+		i1(OP_HWI, 9),
+	}
+	m.Load(text)
+	if msg, _ := exec_until_interrupt(m, 100); msg != 9 {
+		t.Fatalf("stopped with %v, want 9", msg)
+	}
+	want := []Word{'h', 'i', '!', 0, '.'}
+	if len(u.out) != len(want) {
+		t.Fatalf("output is %v, want %v", u.out, want)
+	}
+	for i := range want {
+		if u.out[i] != want[i] {
+			t.Fatalf("output is %v, want %v", u.out, want)
+		}
+	}
+	if *m.Mem(0xff01) != 0 {
+		t.Error("store to mapped region writes memory")
+	}
+	if m.Mapped(0xff02) != u || m.Mapped(0xff03) != nil {
+		t.Error("mapped handlers are wrong")
+	}
+	if !m.Unmap(0xff00) || m.Unmap(0xff00) || m.Mapped(0xff00) != nil {
+		t.Error("region isn't unmapped once")
+	}
+}
+
+func TestMapOverlap(t *testing.T) {
+	t.Parallel()
+	m := mk_machine()
+	m.Map(0x100, 0x10, new(uart))
+	checks := []struct {
+		base Word
+		size int
+	}{
+		{0x108, 1},
+		{0xf0, 0x11},
+		{0xffff, 2},
+		{0x200, 0},
+	}
+	for _, ck := range checks {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("%v+%v: mapped", ck.base, ck.size)
+				}
+			}()
+			m.Map(ck.base, ck.size, new(uart))
+		}()
+	}
+	m.Map(0x110, 1, new(uart))
+	m.Map(0xf0, 0x10, new(uart))
+}
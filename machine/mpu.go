@@ -0,0 +1,139 @@
+package machine
+
+// MPU_REGIONS is the number of memory protection regions.
+const MPU_REGIONS = 8
+
+// MPU registers, selected by MS and accessed through MD.
+const (
+	MPU_CTL    = 0x00 // control, MPU_ENABLE bit
+	MPU_FAULT  = 0x01 // address of the last violation, read-only
+	MPU_REGION = 0x10 // regions: first address, last address, permissions
+
+	MPU_SIZE = MPU_REGION + 4*MPU_REGIONS
+)
+
+// MPU_CTL bits.
+const (
+	MPU_ENABLE = 1 // protect memory in user mode
+)
+
+// Region permissions.
+const (
+	MPU_R = 1 << iota // readable
+	MPU_W             // writable
+	MPU_X             // executable
+)
+
+// MPU is a memory protection unit. When enabled, instructions executed in
+// user mode may only access memory within regions that permit the access,
+// and trap with TRAP_PROTECTION otherwise. Register MPU_REGION+4*n holds
+// the first address of region n, the next one the last address, and the
+// next one permissions; regions without permissions are disabled.
+// Supervisor mode and devices are not restricted.
+type MPU struct {
+	regs  [MPU_SIZE]Word
+	saved struct { // state before the current step
+		regs [32]Word
+		ctrl [8]Word
+	}
+	addr Word // address violating protection in the current step
+}
+
+// AttachMPU attaches memory protection unit to the machine, making MS and
+// MD control registers available, and returns it. The MPU starts disabled.
+func (m *Machine) AttachMPU() *MPU {
+	if m.mpu == nil {
+		m.mpu = new(MPU)
+	}
+	return m.mpu
+}
+
+// MPU returns memory protection unit of the machine, or nil if there is
+// none.
+func (m *Machine) MPU() *MPU {
+	return m.mpu
+}
+
+// Reg returns MPU register n, or zero if there is no such register.
+func (p *MPU) Reg(n Word) Word {
+	if int(n) >= len(p.regs) {
+		return 0
+	}
+	return p.regs[n]
+}
+
+// SetReg sets MPU register n, unless there is no such register.
+func (p *MPU) SetReg(n, w Word) {
+	if int(n) < len(p.regs) {
+		p.regs[n] = w
+	}
+}
+
+// active reports whether memory accesses of the current step are checked.
+func (p *MPU) active(m *Machine) bool {
+	return p.regs[MPU_CTL]&MPU_ENABLE != 0 &&
+		!(*FlagsRegister)(m.C(FL)).S()
+}
+
+// allows reports whether access perm to address i is permitted.
+func (p *MPU) allows(i, perm Word) bool {
+	for n := MPU_REGION; n < MPU_SIZE; n += 4 {
+		first, last, p := p.regs[n], p.regs[n+1], p.regs[n+2]
+		if i >= first && i <= last && p&perm != 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// check checks access perm of an instruction to address i. Violations
+// trap, and check reports false.
+func (p *MPU) check(m *Machine, i, perm Word) bool {
+	if !p.active(m) || p.allows(i, perm) {
+		return true
+	}
+	if !m.fault.trigger {
+		p.addr = i
+		m.trap(TRAP_PROTECTION)
+	}
+	return false
+}
+
+// fetch saves state of the machine and checks execution of instruction at
+// pc in user mode.
+func (p *MPU) fetch(m *Machine, pc Word) {
+	if !p.active(m) {
+		return
+	}
+	p.saved.regs, p.saved.ctrl = m.regs, m.ctrl
+	size := m.Text(pc).Size()
+	for i := Word(0); i < size; i++ {
+		if !p.check(m, pc+i, MPU_X) {
+			return
+		}
+	}
+}
+
+// rollback undoes changes of registers made by the instruction violating
+// protection, and sets MPU_FAULT.
+func (p *MPU) rollback(m *Machine) {
+	m.regs, m.ctrl = p.saved.regs, p.saved.ctrl
+	p.regs[MPU_FAULT] = p.addr
+}
+
+// mpuRead updates MD before an instruction reads control register k.
+func (m *Machine) mpuRead(k Word) {
+	if m.mpu != nil && k == MD {
+		m.ctrl[MD] = m.mpu.Reg(m.ctrl[MS])
+	}
+}
+
+// mpuWrite passes write of control register k by an instruction to MPU.
+func (m *Machine) mpuWrite(k Word) {
+	if m.mpu != nil && k == MD {
+		if m.ctrl[MS] != MPU_FAULT {
+			m.mpu.SetReg(m.ctrl[MS], m.ctrl[MD])
+		}
+		m.ctrl[MD] = m.mpu.Reg(m.ctrl[MS])
+	}
+}
@@ -0,0 +1,210 @@
+package machine
+
+import (
+	"bytes"
+	"testing"
+)
+
+// mpu_set returns instructions setting MPU register n to w.
+func mpu_set(n, w Word) []Word {
+	return []Word{
+		i2(OP_IMP, IMP_MTC, MS), n,
+		i2(OP_IMP, IMP_MTC, MD), w,
+	}
+}
+
+// protected returns text which protects memory and enters user mode at
+// 0x40 with user text in it. User mode may execute 40-7f and access
+// 1000-10ff. Handler at 0x100 loads trap code into s2
+// and fault address into s3.
+func protected(user ...Word) []Word {
+	var text []Word
+	text = append(text, i2(OP_IMP, IMP_MTC, IA), 0x100)
+	text = append(text, mpu_set(MPU_REGION+0, 0x40)...)
+	text = append(text, mpu_set(MPU_REGION+1, 0x7f)...)
+	text = append(text, mpu_set(MPU_REGION+2, MPU_R|MPU_X)...)
+	text = append(text, mpu_set(MPU_REGION+4, 0x1000)...)
+	text = append(text, mpu_set(MPU_REGION+5, 0x10ff)...)
+	text = append(text, mpu_set(MPU_REGION+6, MPU_R|MPU_W)...)
+	text = append(text, mpu_set(MPU_CTL, MPU_ENABLE)...)
+	text = append(text, i2(OP_IMP, IMP_MTC, IR), 0x40, i1(OP_IRE, 1))
+	text = append(text, make([]Word, 0x40-len(text))...)
+	text = append(text, user...)
+	text = append(text, make([]Word, 0x100-len(text))...)
+	return append(text,
+		i2(OP_IMP, IMP_MTC, MS), MPU_FAULT,
+		i2(OP_MFC, S+2, IM),
+		i2(OP_MFC, S+3, MD),
+		i1(OP_HWI, 9))
+}
+
+var protecting = protected(
+	i2(OP_IMP, IMP_MOV, S+0),
+	0x1010,
+	i2(OP_IMP, IMP_PSH, S+0),
+	0x77,
+	i2(OP_IMP, IMP_MOV, S+1),
+	0x2000,
+	i2(OP_PSH, S+1, S+0), // violates protection
+	i1(OP_HWI, 8),
+)
+
+func TestMPU(t *testing.T) {
+	t.Parallel()
+	m := mk_machine()
+	m.Strict = true
+	m.AttachMPU()
+	m.Load(protecting)
+	if msg, _ := exec_until_interrupt(m, 100); msg != 9 {
+		t.Fatalf("stopped with %v, want 9", msg)
+	}
+	if im, ir := *m.R(S + 2), *m.C(IR); im != TRAP_PROTECTION || ir != 0x46 {
+		t.Errorf("im is %v and ir is %v, want %v and 46",
+			im, ir, Word(TRAP_PROTECTION))
+	}
+	if s1, s3 := *m.R(S + 1), *m.R(S + 3); s1 != 0x2000 || s3 != 0x1fff {
+		t.Errorf("s1 is %v and s3 is %v, want 2000 and 1fff", s1, s3)
+	}
+	if *m.Mem(0x100f) != 0x77 || *m.Mem(0x1fff) != 0 {
+		t.Errorf("memory is %v at 100f and %v at 1fff, want 77 and 0",
+			*m.Mem(0x100f), *m.Mem(0x1fff))
+	}
+}
+
+func TestMPUFault(t *testing.T) {
+	t.Parallel()
+	m := mk_machine()
+	m.AttachMPU()
+	m.Load(protecting)
+	var (
+		msg Word
+		ok  bool
+		err error
+	)
+	for i := 0; i < 100 && !ok && err == nil; i++ {
+		msg, ok, err = m.Step()
+	}
+	if err != nil || msg != 9 {
+		t.Fatalf("stopped with %v and error %v, want 9", msg, err)
+	}
+	if im, ir := *m.R(S + 2), *m.C(IR); im != TRAP_PROTECTION || ir != 0x46 {
+		t.Errorf("im is %v and ir is %v, want %v and 46",
+			im, ir, Word(TRAP_PROTECTION))
+	}
+	if m.MPU().Reg(MPU_FAULT) != 0x1fff {
+		t.Errorf("fault register is %v", m.MPU().Reg(MPU_FAULT))
+	}
+}
+
+func TestMPUMove(t *testing.T) {
+	t.Parallel()
+	for _, dst := range []Word{0x1010, 0x10f1} {
+		m := mk_machine()
+		m.Strict = true
+		m.AttachMPU()
+		u := new(uart)
+		m.Map(0x10f0, 2, u)
+		m.Load(protected(
+			i2(OP_IMP, IMP_MOV, S+0),
+			dst,
+			i2(OP_IMP, IMP_MOV, S+1),
+			0x2000,
+			i2(OP_MOM, S+0, S+1), // reading 2000 violates protection
+			i1(OP_HWI, 8),
+		))
+		*m.Mem(0x1010), *m.Mem(0x2000) = 0x5555, 0x77
+		if msg, _ := exec_until_interrupt(m, 100); msg != 9 {
+			t.Fatalf("%04x: stopped with %v, want 9", uint16(dst), msg)
+		}
+		if s2, s3 := *m.R(S + 2), *m.R(S + 3); s2 != TRAP_PROTECTION ||
+			s3 != 0x2000 {
+			t.Errorf("%04x: im is %v and fault address is %v",
+				uint16(dst), s2, s3)
+		}
+		if w := *m.Mem(0x1010); w != 0x5555 || u.out != nil {
+			t.Errorf("%04x: 1010 is %v and %v is written to device",
+				uint16(dst), w, u.out)
+		}
+	}
+}
+
+func TestMPUExecute(t *testing.T) {
+	t.Parallel()
+	user := make([]Word, 0x41)
+	user[0] = i1(OP_JMP, 0x3f)
+	user[0x3f] = i2(OP_IMP, IMP_MOV, S+0) // immediate word isn't executable
+	user[0x40] = 5
+	m := mk_machine()
+	m.Strict = true
+	m.AttachMPU()
+	m.Load(protected(user...))
+	if msg, _ := exec_until_interrupt(m, 100); msg != 9 {
+		t.Fatalf("stopped with %v, want 9", msg)
+	}
+	if ir, s3, s0 := *m.C(IR), *m.R(S + 3), *m.R(S + 0); ir != 0x7f ||
+		s3 != 0x80 || s0 != 0 {
+		t.Errorf("ir, s3, s0 are %v, %v, %v, want 7f, 80, 0", ir, s3, s0)
+	}
+}
+
+func TestMPUReset(t *testing.T) {
+	t.Parallel()
+	m := mk_machine()
+	m.AttachMPU()
+	m.Load(protecting)
+	for i := 0; i < 100; i++ {
+		if _, _, err := m.Step(); err != nil {
+			break
+		}
+	}
+	m.Reset()
+	for n := Word(0); n < MPU_SIZE; n++ {
+		if w := m.MPU().Reg(n); w != 0 {
+			t.Errorf("MPU register %v is %v after reset", n, w)
+		}
+	}
+	m.Load([]Word{
+		i2(OP_IMP, IMP_MOV, S+1),
+		0x2000,
+		i2(OP_PSH, S+1, S+0),
+	})
+	*m.R(S + 0) = 0x77
+	for i := 0; i < 2; i++ {
+		if _, _, err := m.Step(); err != nil {
+			t.Fatalf("step %d in user mode after reset: %v", i, err)
+		}
+	}
+	if w := *m.Mem(0x1fff); w != 0x77 {
+		t.Errorf("memory at 1fff is %v, want 77", w)
+	}
+}
+
+func TestMPUSnapshot(t *testing.T) {
+	t.Parallel()
+	m := mk_machine()
+	m.AttachMPU().SetReg(MPU_REGION+1, 0x1234)
+	var buf bytes.Buffer
+	if err := m.Snapshot(&buf, false); err != nil {
+		t.Fatal(err)
+	}
+	snap := buf.Bytes()
+	if err := new(Machine).Restore(bytes.NewReader(snap)); err == nil {
+		t.Error("restored MPU into machine without one")
+	}
+	n := new(Machine)
+	p := n.AttachMPU()
+	if err := n.Restore(bytes.NewReader(snap)); err != nil {
+		t.Fatal(err)
+	}
+	if n.MPU() != p || p.Reg(MPU_REGION+1) != 0x1234 {
+		t.Error("MPU isn't restored into the attached one")
+	}
+	buf.Reset()
+	mk_machine().Snapshot(&buf, false)
+	if err := n.Restore(&buf); err == nil {
+		t.Error("restored snapshot without MPU into machine with one")
+	}
+	if n.MPU() != p || p.Reg(MPU_REGION+1) != 0x1234 {
+		t.Error("MPU is changed by failed restore")
+	}
+}
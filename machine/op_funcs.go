@@ -1,25 +1,17 @@
 package machine
 
-type OpFunc func(m *Machine, args ...Word)
-
-// get 1 arg
-func get1(args []Word) Word {
-	return args[0]
-}
-
-// get 2 args
-func get2(args []Word) (Word, Word) {
-	return args[0], args[1]
-}
+// OpFunc executes an operator with operands x and y of the instruction.
+// Operators with a single operand take it as x.
+type OpFunc func(m *Machine, x, y Word)
 
 // get 2 registers
-func get2r(args []Word, m *Machine) (*Word, *Word) {
-	return m.R(args[0]), m.R(args[1])
+func get2r(m *Machine, x, y Word) (*Word, *Word) {
+	return m.R(x), m.R(y)
 }
 
 // get register and the next word
-func get2rn(args []Word, m *Machine) (*Word, Word) {
-	return m.R(args[0]), *m.Mem(*m.PC())
+func get2rn(m *Machine, x Word) (*Word, Word) {
+	return m.R(x), *m.Mem(*m.PC())
 }
 
 // fls finds last bit set in a word.
@@ -54,28 +46,34 @@ func raddc(r *Word, v Word) {
 	*r = Word(int16(*r) + int16(sextend10(v)))
 }
 
-// jump (relative) by sign-extended 10-bit v
-func jump(v Word) {
-	*m.PC() += sextend10(v)
-}
 */
 
 // Ordinary operators.
 var op_funcs = []OpFunc{
-	OP_IMP: func(m *Machine, args ...Word) {
-		a, b := get2(args)
-		imp_funcs[a](m, b)
+	OP_IMP: func(m *Machine, a, b Word) {
+		if int(a) >= len(imp_funcs) || imp_funcs[a] == nil {
+			m.trap(TRAP_SUBOPCODE)
+			return
+		}
+		imp_funcs[a](m, b, 0)
 		*m.PC()++
 	},
-	OP_MOV: func(m *Machine, args ...Word) {
-		a, b := get2r(args, m)
+	OP_MOV: func(m *Machine, x, y Word) {
+		a, b := get2r(m, x, y)
 		*a = *b
 	},
-	OP_MTC: func(m *Machine, args ...Word) {
-		k, b := get2(args)
+	OP_MTC: func(m *Machine, k, b Word) {
 		md := k >> 3
 		kr := k & 7
+		if m.reservedp(kr) {
+			m.trap(TRAP_RESERVED)
+			return
+		}
+		if privilegedp(kr) && !m.supervisor() {
+			return
+		}
 
+		m.mpuRead(kr)
 		switch md {
 		case AM_SET:
 			*m.C(kr) = *m.R(b)
@@ -86,12 +84,17 @@ var op_funcs = []OpFunc{
 		case AM_XOR:
 			*m.C(kr) ^= *m.R(b)
 		}
+		m.mpuWrite(kr)
 	},
-	OP_MFC: func(m *Machine, args ...Word) {
-		a, k := get2(args)
+	OP_MFC: func(m *Machine, a, k Word) {
 		md := k >> 3
 		kr := k & 7
+		if m.reservedp(kr) {
+			m.trap(TRAP_RESERVED)
+			return
+		}
 
+		m.mpuRead(kr)
 		switch md {
 		case AM_SET:
 			*m.R(a) = *m.C(kr)
@@ -103,238 +106,258 @@ var op_funcs = []OpFunc{
 			*m.R(a) ^= *m.C(kr)
 		}
 	},
-	OP_STR: func(m *Machine, args ...Word) {
-		a, b := get2r(args, m)
-		*m.Mem(*a) = *b
+	OP_STR: func(m *Machine, x, y Word) {
+		a, b := get2r(m, x, y)
+		m.store(*a, *b)
 	},
-	OP_PSH: func(m *Machine, args ...Word) {
-		a, b := get2r(args, m)
+	OP_PSH: func(m *Machine, x, y Word) {
+		a, b := get2r(m, x, y)
 		*a--
-		*m.Mem(*a) = *b
+		m.store(*a, *b)
 	},
-	OP_LOA: func(m *Machine, args ...Word) {
-		a, b := get2r(args, m)
-		*a = *m.Mem(*b)
+	OP_LOA: func(m *Machine, x, y Word) {
+		a, b := get2r(m, x, y)
+		*a = m.load(*b)
 	},
-	OP_POP: func(m *Machine, args ...Word) {
-		a, b := get2r(args, m)
-		*a = *m.Mem(*b)
+	OP_POP: func(m *Machine, x, y Word) {
+		a, b := get2r(m, x, y)
+		*a = m.load(*b)
 		*b++
 	},
-	OP_MOM: func(m *Machine, args ...Word) {
-		a, b := get2r(args, m)
-		*m.Mem(*a) = *m.Mem(*b)
+	OP_MOM: func(m *Machine, x, y Word) {
+		a, b := get2r(m, x, y)
+		m.store(*a, m.load(*b))
 		*a++
 		*b++
 	},
 
-	OP_SRL: func(m *Machine, args ...Word) {
-		a, b := get2r(args, m)
+	OP_SRL: func(m *Machine, x, y Word) {
+		a, b := get2r(m, x, y)
 		*a = *m.PC()
 		*m.PC() = *b
 	},
 
-	OP_ADD: func(m *Machine, args ...Word) {
-		a, b := get2r(args, m)
+	OP_ADD: func(m *Machine, x, y Word) {
+		a, b := get2r(m, x, y)
 		ex := m.C(EX)
 		r := uint32(*a) + uint32(*b)
 		*ex = Word(r >> 16)
 		*a = Word(r)
 	},
-	OP_ADX: func(m *Machine, args ...Word) {
-		a, b := get2r(args, m)
+	OP_ADX: func(m *Machine, x, y Word) {
+		a, b := get2r(m, x, y)
 		ex := m.C(EX)
 		r := uint32(*a) + uint32(*b) + uint32(*ex)
 		*ex = Word(r >> 16)
 		*a = Word(r)
 	},
-	OP_SUB: func(m *Machine, args ...Word) {
-		a, b := get2r(args, m)
+	OP_SUB: func(m *Machine, x, y Word) {
+		a, b := get2r(m, x, y)
 		ex := m.C(EX)
 		r := uint32(*a) - uint32(*b)
 		*ex = Word(r >> 16)
 		*a = Word(r)
 	},
-	OP_SBX: func(m *Machine, args ...Word) {
-		a, b := get2r(args, m)
+	OP_SBX: func(m *Machine, x, y Word) {
+		a, b := get2r(m, x, y)
 		ex := m.C(EX)
 		r := uint32(*a) - uint32(*b) + uint32(*ex)
 		*ex = Word(r >> 16)
 		*a = Word(r)
 	},
-	OP_MUL: func(m *Machine, args ...Word) {
-		a, b := get2r(args, m)
+	OP_MUL: func(m *Machine, x, y Word) {
+		a, b := get2r(m, x, y)
 		ex := m.C(EX)
 		r := uint32(*a) * uint32(*b)
 		*ex = Word(r >> 16)
 		*a = Word(r)
 	},
-	OP_MLI: func(m *Machine, args ...Word) {
-		a, b := get2r(args, m)
+	OP_MLI: func(m *Machine, x, y Word) {
+		a, b := get2r(m, x, y)
 		ex := m.C(EX)
 		r := int32(*a) * int32(*b)
 		*ex = Word(r >> 16)
 		*a = Word(r)
 	},
-	OP_DIV: func(m *Machine, args ...Word) {
-		a, b := get2r(args, m)
+	OP_DIV: func(m *Machine, x, y Word) {
+		a, b := get2r(m, x, y)
 		ex := m.C(EX)
+		if *b == 0 {
+			*a, *ex = 0xffff, 0
+			return
+		}
 		r := uint32(*a) << 16 / uint32(*b)
 		*ex = Word(r)
 		*a = Word(r >> 16)
 	},
-	OP_DVI: func(m *Machine, args ...Word) {
-		a, b := get2r(args, m)
+	OP_DVI: func(m *Machine, x, y Word) {
+		a, b := get2r(m, x, y)
 		ex := m.C(EX)
+		if *b == 0 {
+			*a, *ex = 0xffff, 0
+			return
+		}
 		r := int32(*a) << 16 / int32(*b)
 		*ex = Word(r)
 		*a = Word(r >> 16)
 	},
-	OP_MOD: func(m *Machine, args ...Word) {
-		a, b := get2r(args, m)
+	OP_MOD: func(m *Machine, x, y Word) {
+		a, b := get2r(m, x, y)
+		if *b == 0 {
+			*a = *b
+			return
+		}
 		*a %= *b
 	},
-	OP_MDI: func(m *Machine, args ...Word) {
-		a, b := get2r(args, m)
+	OP_MDI: func(m *Machine, x, y Word) {
+		a, b := get2r(m, x, y)
+		if *b == 0 {
+			*a = *b
+			return
+		}
 		*a = Word(int16(*a) % int16(*b))
 	},
-	OP_INC: func(m *Machine, args ...Word) {
-		a, b := m.R(args[0]), args[1]
+	OP_INC: func(m *Machine, x, y Word) {
+		a, b := m.R(x), y
 		*a += b
 	},
-	OP_GBS: func(m *Machine, args ...Word) {
-		a, b := get2r(args, m)
+	OP_GBS: func(m *Machine, x, y Word) {
+		a, b := get2r(m, x, y)
 		*a = fls(*b)
 	},
 
-	OP_AND: func(m *Machine, args ...Word) {
-		a, b := get2r(args, m)
+	OP_AND: func(m *Machine, x, y Word) {
+		a, b := get2r(m, x, y)
 		*a &= *b
 	},
-	OP_IOR: func(m *Machine, args ...Word) {
-		a, b := get2r(args, m)
+	OP_IOR: func(m *Machine, x, y Word) {
+		a, b := get2r(m, x, y)
 		*a |= *b
 	},
-	OP_XOR: func(m *Machine, args ...Word) {
-		a, b := get2r(args, m)
+	OP_XOR: func(m *Machine, x, y Word) {
+		a, b := get2r(m, x, y)
 		*a ^= *b
 	},
-	OP_SHL: func(m *Machine, args ...Word) {
-		a, b := get2r(args, m)
+	OP_BIC: func(m *Machine, x, y Word) {
+		a, b := get2r(m, x, y)
+		*a &^= *b
+	},
+	OP_SHL: func(m *Machine, x, y Word) {
+		a, b := get2r(m, x, y)
 		ex := m.C(EX)
 		r := uint32(*a) << uint32(*b)
 		*ex = Word(r >> 16)
 		*a = Word(r)
 	},
-	OP_ASR: func(m *Machine, args ...Word) {
-		a, b := get2r(args, m)
+	OP_ASR: func(m *Machine, x, y Word) {
+		a, b := get2r(m, x, y)
 		ex := m.C(EX)
 		r := int32(*a) << 16 >> uint32(*b)
 		*ex = Word(r)
 		*a = Word(r >> 16)
 	},
-	OP_SHR: func(m *Machine, args ...Word) {
-		a, b := get2r(args, m)
+	OP_SHR: func(m *Machine, x, y Word) {
+		a, b := get2r(m, x, y)
 		ex := m.C(EX)
 		r := uint32(*a) << 16 >> uint32(*b)
 		*ex = Word(r)
 		*a = Word(r >> 16)
 	},
-	OP_ROL: func(m *Machine, args ...Word) {
-		a, b := m.R(args[0]), args[1]
+	OP_ROL: func(m *Machine, x, y Word) {
+		a, b := m.R(x), y
 		r := uint32(*a) << uint32(b)
 		*a = Word(r) | Word(r>>16)
 	},
-	OP_ROR: func(m *Machine, args ...Word) {
-		a, b := m.R(args[0]), args[1]
+	OP_ROR: func(m *Machine, x, y Word) {
+		a, b := m.R(x), y
 		r := uint32(*a) << 16 >> uint32(b)
 		*a = Word(r) | Word(r>>16)
 	},
 
-	OP_TST: func(m *Machine, args ...Word) {
-		a, b := get2r(args, m)
+	OP_TST: func(m *Machine, x, y Word) {
+		a, b := get2r(m, x, y)
 		ex := m.C(EX)
 		*ex = *a & *b
 	},
-	OP_TEQ: func(m *Machine, args ...Word) {
-		a, b := get2r(args, m)
+	OP_TEQ: func(m *Machine, x, y Word) {
+		a, b := get2r(m, x, y)
 		ex := m.C(EX)
 		*ex = *a ^ *b
 	},
-	OP_CMP: func(m *Machine, args ...Word) {
-		a, b := get2r(args, m)
+	OP_CMP: func(m *Machine, x, y Word) {
+		a, b := get2r(m, x, y)
 		ex := m.C(EX)
 		*ex = *a - *b
 	},
-	OP_CMN: func(m *Machine, args ...Word) {
-		a, b := get2r(args, m)
+	OP_CMN: func(m *Machine, x, y Word) {
+		a, b := get2r(m, x, y)
 		ex := m.C(EX)
 		*ex = *a + *b
 	},
 
-	OP_JMP: func(m *Machine, args ...Word) {
-		c := args[0]
-		*m.PC() += sextend10(c) - 1
+	OP_JMP: func(m *Machine, c, _ Word) {
+		m.jump(c)
 	},
-	OP_JLT: func(m *Machine, args ...Word) {
-		c := args[0]
+	OP_JLT: func(m *Machine, c, _ Word) {
 		ex := m.C(EX)
 		if *ex < 0 {
-			*m.PC() += sextend10(c) - 1
+			m.jump(c)
 		}
 	},
-	OP_JLE: func(m *Machine, args ...Word) {
-		c := args[0]
+	OP_JLE: func(m *Machine, c, _ Word) {
 		ex := m.C(EX)
 		if *ex <= 0 {
-			*m.PC() += sextend10(c) - 1
+			m.jump(c)
 		}
 	},
-	OP_JGT: func(m *Machine, args ...Word) {
-		c := args[0]
+	OP_JGT: func(m *Machine, c, _ Word) {
 		ex := m.C(EX)
 		if *ex > 0 {
-			*m.PC() += sextend10(c) - 1
+			m.jump(c)
 		}
 	},
-	OP_JGE: func(m *Machine, args ...Word) {
-		c := args[0]
+	OP_JGE: func(m *Machine, c, _ Word) {
 		ex := m.C(EX)
 		if *ex >= 0 {
-			*m.PC() += sextend10(c) - 1
+			m.jump(c)
 		}
 	},
-	OP_JEQ: func(m *Machine, args ...Word) {
-		c := args[0]
+	OP_JEQ: func(m *Machine, c, _ Word) {
 		ex := m.C(EX)
 		if *ex == 0 {
-			*m.PC() += sextend10(c) - 1
+			m.jump(c)
 		}
 	},
-	OP_JNE: func(m *Machine, args ...Word) {
-		c := args[0]
+	OP_JNE: func(m *Machine, c, _ Word) {
 		ex := m.C(EX)
 		if *ex != 0 {
-			*m.PC() += sextend10(c) - 1
+			m.jump(c)
 		}
 	},
 
-	OP_SWI: func(m *Machine, args ...Word) {
+	OP_SWI: func(m *Machine, c, _ Word) {
 		*m.C(IR) = *m.PC()
-		*m.PC() = *m.C(IA)
-		*m.C(IM) = args[0]
-		(*FlagsRegister)(m.C(FL)).SetI(true)
-		(*FlagsRegister)(m.C(FL)).SetS(true)
+		m.vector(c)
 	},
-	OP_HWI: func(m *Machine, args ...Word) {
+	OP_HWI: func(m *Machine, c, _ Word) {
+		if !m.supervisor() {
+			return
+		}
+		*m.C(IM) = c
+		if m.hwi(c) {
+			return
+		}
+		// no device to accept the interrupt, let the caller handle it
 		m.interrupt.trigger = true
-		m.interrupt.message = args[0]
-		*m.C(IM) = args[0]
+		m.interrupt.message = c
 	},
-	OP_IRE: func(m *Machine, args ...Word) {
+	OP_IRE: func(m *Machine, c, _ Word) {
+		if !m.supervisor() {
+			return
+		}
 		*m.PC() = *m.C(IR)
 		(*FlagsRegister)(m.C(FL)).SetI(false)
-		if args[0] != 0 {
+		if c != 0 {
 			(*FlagsRegister)(m.C(FL)).SetS(false)
 		}
 	},
@@ -342,22 +365,29 @@ var op_funcs = []OpFunc{
 
 // Immediate operand operators
 var imp_funcs = []OpFunc{
-	IMP_BRK: func(m *Machine, args ...Word) {
+	IMP_BRK: func(m *Machine, _, _ Word) {
 		*m.C(IR) = *m.PC()
-		*m.PC() = *m.C(IA)
-		*m.C(IM) = 0xffff
-		(*FlagsRegister)(m.C(FL)).SetI(true)
-		(*FlagsRegister)(m.C(FL)).SetS(true)
+		m.vector(0xffff)
+		*m.PC()-- // compensate OP_IMP incrementing PC by one
+		m.brk = true
 	},
-	IMP_MOV: func(m *Machine, args ...Word) {
-		a, n := get2rn(args, m)
+	IMP_MOV: func(m *Machine, x, _ Word) {
+		a, n := get2rn(m, x)
 		*a = n
 	},
-	IMP_MTC: func(m *Machine, args ...Word) {
-		k, n := get2(args)
+	IMP_MTC: func(m *Machine, k, _ Word) {
+		n := *m.Mem(*m.PC())
 		md := k >> 3
 		kr := k & 7
+		if m.reservedp(kr) {
+			m.trap(TRAP_RESERVED)
+			return
+		}
+		if privilegedp(kr) && !m.supervisor() {
+			return
+		}
 
+		m.mpuRead(kr)
 		switch md {
 		case AM_SET:
 			*m.C(kr) = n
@@ -368,147 +398,167 @@ var imp_funcs = []OpFunc{
 		case AM_XOR:
 			*m.C(kr) ^= n
 		}
+		m.mpuWrite(kr)
 	},
 
-	IMP_STR: func(m *Machine, args ...Word) {
-		a, n := get2rn(args, m)
-		*m.Mem(*a) = n
+	IMP_STR: func(m *Machine, x, _ Word) {
+		a, n := get2rn(m, x)
+		m.store(*a, n)
 	},
-	IMP_PSH: func(m *Machine, args ...Word) {
-		a, n := get2rn(args, m)
+	IMP_PSH: func(m *Machine, x, _ Word) {
+		a, n := get2rn(m, x)
 		*a--
-		*m.Mem(*a) = n
+		m.store(*a, n)
 	},
 
-	IMP_SRL: func(m *Machine, args ...Word) {
-		a, n := get2rn(args, m)
+	IMP_SRL: func(m *Machine, x, _ Word) {
+		a, n := get2rn(m, x)
 		*a = *m.PC()
 		*m.PC() = n - 1 // compensate OP_IMP incrementing PC by one
 	},
 
-	IMP_ADD: func(m *Machine, args ...Word) {
-		a, n := get2rn(args, m)
+	IMP_ADD: func(m *Machine, x, _ Word) {
+		a, n := get2rn(m, x)
 		ex := m.C(EX)
 		r := uint32(*a) + uint32(n)
 		*a, *ex = Word(r), Word(r>>16)
 	},
-	IMP_ADX: func(m *Machine, args ...Word) {
-		a, n := get2rn(args, m)
+	IMP_ADX: func(m *Machine, x, _ Word) {
+		a, n := get2rn(m, x)
 		ex := m.C(EX)
 		r := uint32(*a) + uint32(n) + uint32(*ex)
 		*a, *ex = Word(r), Word(r>>16)
 	},
-	IMP_SUB: func(m *Machine, args ...Word) {
-		a, n := get2rn(args, m)
+	IMP_SUB: func(m *Machine, x, _ Word) {
+		a, n := get2rn(m, x)
 		ex := m.C(EX)
 		r := uint32(*a) - uint32(n)
 		*a, *ex = Word(r), Word(r>>16)
 	},
-	IMP_SBX: func(m *Machine, args ...Word) {
-		a, n := get2rn(args, m)
+	IMP_SBX: func(m *Machine, x, _ Word) {
+		a, n := get2rn(m, x)
 		ex := m.C(EX)
 		r := uint32(*a) - uint32(n) + uint32(*ex)
 		*a, *ex = Word(r), Word(r>>16)
 	},
-	IMP_MUL: func(m *Machine, args ...Word) {
-		a, n := get2rn(args, m)
+	IMP_MUL: func(m *Machine, x, _ Word) {
+		a, n := get2rn(m, x)
 		ex := m.C(EX)
 		r := uint32(*a) * uint32(n)
 		*a, *ex = Word(r), Word(r>>16)
 	},
-	IMP_MLI: func(m *Machine, args ...Word) {
-		a, n := get2rn(args, m)
+	IMP_MLI: func(m *Machine, x, _ Word) {
+		a, n := get2rn(m, x)
 		ex := m.C(EX)
 		r := int32(*a) * int32(n)
 		*a, *ex = Word(r), Word(r>>16)
 	},
-	IMP_DIV: func(m *Machine, args ...Word) {
-		a, n := get2rn(args, m)
+	IMP_DIV: func(m *Machine, x, _ Word) {
+		a, n := get2rn(m, x)
 		ex := m.C(EX)
+		if n == 0 {
+			*a, *ex = 0xffff, 0
+			return
+		}
 		r := uint32(*a) << 16 / uint32(n)
 		*a, *ex = Word(r>>16), Word(r)
 	},
-	IMP_DVI: func(m *Machine, args ...Word) {
-		a, n := get2rn(args, m)
+	IMP_DVI: func(m *Machine, x, _ Word) {
+		a, n := get2rn(m, x)
 		ex := m.C(EX)
+		if n == 0 {
+			*a, *ex = 0xffff, 0
+			return
+		}
 		r := int32(*a) << 16 / int32(n)
 		*a, *ex = Word(r>>16), Word(r)
 	},
-	IMP_MOD: func(m *Machine, args ...Word) {
-		a, n := get2rn(args, m)
+	IMP_MOD: func(m *Machine, x, _ Word) {
+		a, n := get2rn(m, x)
+		if n == 0 {
+			*a = n
+			return
+		}
 		*a %= n
 	},
-	IMP_MDI: func(m *Machine, args ...Word) {
-		a, n := get2rn(args, m)
+	IMP_MDI: func(m *Machine, x, _ Word) {
+		a, n := get2rn(m, x)
+		if n == 0 {
+			*a = n
+			return
+		}
 		*a = Word(int16(*a) % int16(n))
 	},
-	IMP_INC: func(m *Machine, args ...Word) {
-		a, n := get2rn(args, m)
+	IMP_INC: func(m *Machine, x, _ Word) {
+		a, n := get2rn(m, x)
 		*a += n
 	},
 
-	IMP_AND: func(m *Machine, args ...Word) {
-		a, n := get2rn(args, m)
+	IMP_AND: func(m *Machine, x, _ Word) {
+		a, n := get2rn(m, x)
 		*a &= n
 	},
-	IMP_IOR: func(m *Machine, args ...Word) {
-		a, n := get2rn(args, m)
+	IMP_IOR: func(m *Machine, x, _ Word) {
+		a, n := get2rn(m, x)
 		*a |= n
 	},
-	IMP_XOR: func(m *Machine, args ...Word) {
-		a, n := get2rn(args, m)
+	IMP_XOR: func(m *Machine, x, _ Word) {
+		a, n := get2rn(m, x)
 		*a ^= n
 	},
-	IMP_BIC: func(m *Machine, args ...Word) {
-		a, n := get2rn(args, m)
+	IMP_BIC: func(m *Machine, x, _ Word) {
+		a, n := get2rn(m, x)
 		*a &^= n
 	},
-	IMP_SHL: func(m *Machine, args ...Word) {
-		a, n, ex := m.R(args[0]), args[1], m.C(EX)
+	IMP_SHL: func(m *Machine, x, _ Word) {
+		a, n := get2rn(m, x)
+		ex := m.C(EX)
 		r := uint32(*a) << uint32(n)
 		*ex = Word(r >> 16)
 		*a = Word(r)
 	},
-	IMP_ASR: func(m *Machine, args ...Word) {
-		a, n, ex := m.R(args[0]), args[1], m.C(EX)
+	IMP_ASR: func(m *Machine, x, _ Word) {
+		a, n := get2rn(m, x)
+		ex := m.C(EX)
 		r := int32(*a) << 16 >> uint32(n)
 		*ex = Word(r)
 		*a = Word(r >> 16)
 	},
-	IMP_SHR: func(m *Machine, args ...Word) {
-		a, n, ex := m.R(args[0]), args[1], m.C(EX)
+	IMP_SHR: func(m *Machine, x, _ Word) {
+		a, n := get2rn(m, x)
+		ex := m.C(EX)
 		r := uint32(*a) << 16 >> uint32(n)
 		*ex = Word(r)
 		*a = Word(r >> 16)
 	},
-	IMP_ROL: func(m *Machine, args ...Word) {
-		a, n := m.R(args[0]), args[1]
+	IMP_ROL: func(m *Machine, x, _ Word) {
+		a, n := get2rn(m, x)
 		r := uint32(*a) << uint32(n)
 		*a = Word(r) | Word(r>>16)
 	},
-	IMP_ROR: func(m *Machine, args ...Word) {
-		a, n := m.R(args[0]), args[1]
+	IMP_ROR: func(m *Machine, x, _ Word) {
+		a, n := get2rn(m, x)
 		r := uint32(*a) << 16 >> uint32(n)
 		*a = Word(r) | Word(r>>16)
 	},
 
-	IMP_TST: func(m *Machine, args ...Word) {
-		a, n := get2rn(args, m)
+	IMP_TST: func(m *Machine, x, _ Word) {
+		a, n := get2rn(m, x)
 		ex := m.C(EX)
 		*ex = *a & n
 	},
-	IMP_TEQ: func(m *Machine, args ...Word) {
-		a, n := get2rn(args, m)
+	IMP_TEQ: func(m *Machine, x, _ Word) {
+		a, n := get2rn(m, x)
 		ex := m.C(EX)
 		*ex = *a ^ n
 	},
-	IMP_CMP: func(m *Machine, args ...Word) {
-		a, n := get2rn(args, m)
+	IMP_CMP: func(m *Machine, x, _ Word) {
+		a, n := get2rn(m, x)
 		ex := m.C(EX)
 		*ex = *a - n
 	},
-	IMP_CMN: func(m *Machine, args ...Word) {
-		a, n := get2rn(args, m)
+	IMP_CMN: func(m *Machine, x, _ Word) {
+		a, n := get2rn(m, x)
 		ex := m.C(EX)
 		*ex = *a + n
 	},
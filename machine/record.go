@@ -0,0 +1,369 @@
+package machine
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+// Event logs make runs of the machine reproducible. A log starts with the
+// state of the machine when recording began and goes on with events which
+// come from outside of the machine: hardware interrupts sent by the host
+// between steps and input read by devices with Input. Each event is tagged
+// with the index of the step it arrived at, counted from the start of
+// recording, and the cycle counter. To detect diverging replays, the log
+// also traces control flow: every step which does not execute the
+// instruction following the previous one is logged as a jump.
+//
+// Logs are written as they are recorded. All integers are little-endian,
+// and uvarints are encoded as in encoding/binary:
+//
+//	magic     [4]byte  "RHMR"
+//	version   uint16   LOG_VERSION
+//	snapshot           as written by Machine.Snapshot
+//	records   of
+//	  kind    byte     one of LOG_*
+//	  delta   uvarint  step index minus that of the previous record
+//	  payload          depends on kind:
+//	    LOG_END        none, the last record
+//	    LOG_JUMP       uvarint address of the instruction
+//	    LOG_INTERRUPT  uvarint cycles, uvarint message
+//	    LOG_INPUT      uvarint cycles, uvarint value
+const LOG_VERSION = 1
+
+var log_magic = [4]byte{'R', 'H', 'M', 'R'}
+
+// Event log record kinds.
+const (
+	LOG_END       = 0x00 // recording is over
+	LOG_JUMP      = 0x01 // step executes instruction out of sequence
+	LOG_INTERRUPT = 0x02 // hardware interrupt is sent by the host
+	LOG_INPUT     = 0x03 // device reads input
+)
+
+var log_strings = [...]string{
+	LOG_END:       "end of log",
+	LOG_JUMP:      "jump",
+	LOG_INTERRUPT: "interrupt",
+	LOG_INPUT:     "input",
+}
+
+// journal records or replays events for the machine.
+type journal interface {
+	// begin is called before the step, dispatch is not done yet.
+	begin(m *Machine) error
+	// fetch is called with address of the instruction to be executed.
+	fetch(m *Machine, pc Word) error
+	// end is called after the step.
+	end(m *Machine) error
+	// interrupt is called for interrupts sent by the host between steps,
+	// and reports whether to queue it.
+	interrupt(m *Machine, msg Word) bool
+	// input is called for input read by devices.
+	input(m *Machine, read func() Word) Word
+}
+
+// Input returns input for a device, read from outside of the machine by
+// read. Devices must read input with Input for runs to be recorded and
+// replayed; when replaying, read is not called.
+func (m *Machine) Input(read func() Word) Word {
+	if m.journal == nil {
+		return read()
+	}
+	return m.journal.input(m, read)
+}
+
+// Divergence is returned by Step when a replayed run does not match its
+// log. Replay stops at the first diverging step.
+type Divergence struct {
+	Step   uint64 // index of the step, counted from the start of replay
+	PC     Word   // address of the instruction executed by the step
+	Reason string
+}
+
+func (d *Divergence) Error() string {
+	return fmt.Sprintf("replay diverges at step %d, %04x: %s",
+		d.Step, uint16(d.PC), d.Reason)
+}
+
+// logRecord is an event log record.
+type logRecord struct {
+	kind byte
+	step uint64
+	a, b uint64 // payload
+}
+
+func (r *logRecord) String() string {
+	switch r.kind {
+	case LOG_JUMP:
+		return fmt.Sprintf("jump to %04x", r.a)
+	case LOG_INTERRUPT, LOG_INPUT:
+		return fmt.Sprintf("%s %04x at cycle %d",
+			log_strings[r.kind], r.b, r.a)
+	}
+	return log_strings[r.kind]
+}
+
+// Recorder writes event log of the machine.
+type Recorder struct {
+	w     *bufio.Writer
+	m     *Machine
+	steps uint64 // steps done
+	last  uint64 // step index of the last record
+	next  Word   // address of the instruction following the last one
+	err   error
+}
+
+// Record starts recording the machine into event log written to w. The
+// state of the machine is saved first, so the log can be replayed on a
+// fresh machine with the same devices attached.
+func (m *Machine) Record(w io.Writer) (*Recorder, error) {
+	r := &Recorder{w: bufio.NewWriter(w), m: m, next: *m.PC()}
+	hdr := struct {
+		Magic   [4]byte
+		Version uint16
+	}{log_magic, LOG_VERSION}
+	if err := binary.Write(r.w, binary.LittleEndian, &hdr); err != nil {
+		return nil, err
+	}
+	if err := m.Snapshot(r.w, true); err != nil {
+		return nil, err
+	}
+	m.journal = r
+	return r, nil
+}
+
+// Flush writes buffered records to the underlying writer.
+func (r *Recorder) Flush() error {
+	if r.err != nil {
+		return r.err
+	}
+	r.err = r.w.Flush()
+	return r.err
+}
+
+// Close ends the log and stops recording.
+func (r *Recorder) Close() error {
+	if r.m.journal == r {
+		r.m.journal = nil
+	}
+	r.put(LOG_END)
+	return r.Flush()
+}
+
+// put writes record of given kind at the current step.
+func (r *Recorder) put(kind byte, payload ...uint64) {
+	if r.err != nil {
+		return
+	}
+	buf := make([]byte, 0, 32)
+	buf = append(buf, kind)
+	buf = binary.AppendUvarint(buf, r.steps-r.last)
+	for _, x := range payload {
+		buf = binary.AppendUvarint(buf, x)
+	}
+	r.last = r.steps
+	_, r.err = r.w.Write(buf)
+}
+
+func (r *Recorder) begin(m *Machine) error {
+	return nil
+}
+
+func (r *Recorder) fetch(m *Machine, pc Word) error {
+	if pc != r.next {
+		r.put(LOG_JUMP, uint64(pc))
+	}
+	r.next = pc + m.Text(pc).Size()
+	return nil
+}
+
+func (r *Recorder) end(m *Machine) error {
+	r.steps++
+	return nil
+}
+
+func (r *Recorder) interrupt(m *Machine, msg Word) bool {
+	r.put(LOG_INTERRUPT, m.cycles, uint64(msg))
+	return true
+}
+
+func (r *Recorder) input(m *Machine, read func() Word) Word {
+	v := read()
+	r.put(LOG_INPUT, m.cycles, uint64(v))
+	return v
+}
+
+// replayer feeds events from event log to the machine.
+type replayer struct {
+	r     *bufio.Reader
+	rec   logRecord // the next record
+	steps uint64    // steps done
+	pc    Word      // address of the current instruction
+	next  Word      // address of the instruction following the last one
+	err   error     // divergence found during the step
+}
+
+// Replay restores state of the machine saved in event log read from r,
+// then replays events from the log as the machine is stepped. The same
+// devices must be attached as when the log was recorded. Hardware
+// interrupts sent by the host are ignored until the replay is over.
+func (m *Machine) Replay(r io.Reader) error {
+	p := &replayer{r: bufio.NewReader(r)}
+	var hdr struct {
+		Magic   [4]byte
+		Version uint16
+	}
+	if err := binary.Read(p.r, binary.LittleEndian, &hdr); err != nil {
+		return logError(err)
+	}
+	switch {
+	case hdr.Magic != log_magic:
+		return fmt.Errorf("event log: bad magic %q", hdr.Magic[:])
+	case hdr.Version != LOG_VERSION:
+		return fmt.Errorf("event log: unsupported version %d", hdr.Version)
+	}
+	if err := m.Restore(p.r); err != nil {
+		return err
+	}
+	if err := p.read(); err != nil {
+		return err
+	}
+	p.next = *m.PC()
+	m.journal = p
+	return nil
+}
+
+// Replaying reports whether the machine replays an event log.
+func (m *Machine) Replaying() bool {
+	_, ok := m.journal.(*replayer)
+	return ok
+}
+
+// read reads the next record.
+func (p *replayer) read() error {
+	kind, err := p.r.ReadByte()
+	if err != nil {
+		return logError(err)
+	}
+	if int(kind) >= len(log_strings) {
+		return fmt.Errorf("event log: bad record kind %02x", kind)
+	}
+	delta, err := binary.ReadUvarint(p.r)
+	if err != nil {
+		return logError(err)
+	}
+	rec := logRecord{kind: kind, step: p.rec.step + delta}
+	var n int
+	switch kind {
+	case LOG_JUMP:
+		n = 1
+	case LOG_INTERRUPT, LOG_INPUT:
+		n = 2
+	}
+	for _, x := range []*uint64{&rec.a, &rec.b}[:n] {
+		if *x, err = binary.ReadUvarint(p.r); err != nil {
+			return logError(err)
+		}
+	}
+	p.rec = rec
+	return nil
+}
+
+// diverge stops replay of m, returning the divergence.
+func (p *replayer) diverge(m *Machine, format string, args ...interface{}) error {
+	m.journal = nil
+	return &Divergence{p.steps, p.pc, fmt.Sprintf(format, args...)}
+}
+
+// due reports whether the next record is of given kind and at the current
+// step.
+func (p *replayer) due(kind byte) bool {
+	return p.rec.kind == kind && p.rec.step == p.steps
+}
+
+func (p *replayer) begin(m *Machine) error {
+	p.pc = *m.PC()
+	for p.due(LOG_INTERRUPT) {
+		if p.rec.a != m.cycles {
+			return p.diverge(m, "interrupt at cycle %d, want %d",
+				m.cycles, p.rec.a)
+		}
+		m.irq.push(Word(p.rec.b))
+		if err := p.read(); err != nil {
+			m.journal = nil
+			return err
+		}
+	}
+	if p.due(LOG_END) {
+		m.journal = nil
+	}
+	return nil
+}
+
+func (p *replayer) fetch(m *Machine, pc Word) error {
+	p.pc = pc
+	switch {
+	case p.due(LOG_JUMP):
+		if Word(p.rec.a) != pc {
+			return p.diverge(m, "instruction is out of sequence, want %04x",
+				p.rec.a)
+		}
+		if err := p.read(); err != nil {
+			m.journal = nil
+			return err
+		}
+	case pc != p.next:
+		return p.diverge(m, "instruction is out of sequence, want %04x",
+			uint16(p.next))
+	}
+	p.next = pc + m.Text(pc).Size()
+	return nil
+}
+
+func (p *replayer) end(m *Machine) error {
+	if err := p.err; err != nil {
+		m.journal = nil
+		return err
+	}
+	if p.rec.step == p.steps && p.rec.kind == LOG_INPUT {
+		return p.diverge(m, "missing %v", &p.rec)
+	}
+	p.steps++
+	if p.due(LOG_END) {
+		m.journal = nil
+	}
+	return nil
+}
+
+func (p *replayer) interrupt(m *Machine, msg Word) bool {
+	return false
+}
+
+func (p *replayer) input(m *Machine, read func() Word) Word {
+	if p.err != nil {
+		return 0
+	}
+	if !p.due(LOG_INPUT) {
+		p.err = &Divergence{p.steps, p.pc,
+			fmt.Sprintf("unexpected input, want %v", &p.rec)}
+		return 0
+	}
+	if p.rec.a != m.cycles {
+		p.err = &Divergence{p.steps, p.pc,
+			fmt.Sprintf("input at cycle %d, want %d", m.cycles, p.rec.a)}
+		return 0
+	}
+	v := Word(p.rec.b)
+	p.err = p.read()
+	return v
+}
+
+// logError reports truncated logs as such.
+func logError(err error) error {
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		return fmt.Errorf("event log: truncated")
+	}
+	return fmt.Errorf("event log: %v", err)
+}
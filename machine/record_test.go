@@ -0,0 +1,137 @@
+package machine
+
+import (
+	"bytes"
+	"math/rand"
+	"testing"
+)
+
+// keys is a device which reads host input into a0.
+type keys struct {
+	read func() Word
+}
+
+func (d *keys) Info() DeviceInfo {
+	return DeviceInfo{Vendor: 0x7e57, ID: 0x6e75, Version: 1}
+}
+
+func (d *keys) Interrupt(m *Machine) {
+	*m.R(A + 0) = m.Input(d.read)
+}
+
+var typing = []Word{
+	i2(OP_IMP, IMP_MOV, T+0),
+	0x20,
+	i2(OP_MTC, IA, T+0),
+	i1(OP_HWI, 0), // :loop
+	i2(OP_ADD, S+0, A+0),
+	i2(OP_TST, A+0, A+0),
+	i1(OP_JEQ, 2),
+	i2(OP_INC, S+2, 1),
+	i1(OP_JMP, -5),
+	0x20: i2(OP_INC, S+1, 1),
+	i1(OP_IRE, 0),
+}
+
+// record runs typing for steps steps sending host interrupts at random,
+// and returns the machine and its event log.
+func record(t *testing.T, steps int) (*Machine, []byte) {
+	rnd := rand.New(rand.NewSource(1))
+	m := mk_machine()
+	m.Attach(&keys{func() Word { return Word(rnd.Intn(3)) }})
+	m.Load(typing)
+	exec_until_interrupt(m, 5)
+	var log bytes.Buffer
+	rec, err := m.Record(&log)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < steps; i++ {
+		if rnd.Intn(10) == 0 {
+			m.HWInterrupt(Word(rnd.Intn(100)))
+		}
+		if _, _, err := m.Step(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := rec.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return m, log.Bytes()
+}
+
+// replaying returns machine replaying log.
+func replaying(t *testing.T, log []byte) *Machine {
+	m := new(Machine)
+	m.Attach(&keys{func() Word {
+		t.Fatal("replay reads host input")
+		return 0
+	}})
+	if err := m.Replay(bytes.NewReader(log)); err != nil {
+		t.Fatal(err)
+	}
+	return m
+}
+
+func TestReplay(t *testing.T) {
+	t.Parallel()
+	m, log := record(t, 500)
+	n := replaying(t, log)
+	for i := 0; i < 500; i++ {
+		if !n.Replaying() {
+			t.Fatalf("replay is over after %d steps", i)
+		}
+		n.HWInterrupt(0x99) // ignored
+		if _, _, err := n.Step(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n.Replaying() {
+		t.Error("replay goes on after the end of log")
+	}
+	if !same_state(m, n) {
+		t.Error("replayed state differs")
+	}
+	if *n.R(S + 1) == 0 || *n.R(S + 2) == 0 {
+		t.Errorf("s1 is %v and s2 is %v, want interrupts and input",
+			*n.R(S + 1), *n.R(S + 2))
+	}
+}
+
+func TestReplayDivergence(t *testing.T) {
+	t.Parallel()
+	_, log := record(t, 200)
+	checks := []struct {
+		name  string
+		patch func(m *Machine)
+		pc    Word
+	}{
+		// the loop misses input
+		{"input", func(m *Machine) {
+			*m.Mem(3) = i2(OP_MOV, A+0, ZR)
+		}, 3},
+		// the loop never counts input
+		{"jump", func(m *Machine) {
+			*m.Mem(6) = i1(OP_JMP, 2)
+		}, 8},
+	}
+	for _, ck := range checks {
+		m := replaying(t, log)
+		ck.patch(m)
+		var err error
+		for i := 0; i < 200 && err == nil; i++ {
+			_, _, err = m.Step()
+		}
+		d, ok := err.(*Divergence)
+		if !ok {
+			t.Errorf("%s: got error %v, want divergence", ck.name, err)
+			continue
+		}
+		if d.PC != ck.pc {
+			t.Errorf("%s: %v, want divergence at %v", ck.name, d, ck.pc)
+		}
+		if m.Replaying() {
+			t.Errorf("%s: replay goes on after divergence", ck.name)
+		}
+	}
+}
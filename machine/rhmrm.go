@@ -25,6 +25,8 @@ const (
 	C = 0 // Control register prefix.
 	PC = C+0 // PC (program counter) register.
 	EX = C+1 // EX (extra or excess) register.
+	MS = C+2 // MS (MPU select) register.
+	MD = C+3 // MD (MPU data) register.
 	IA = C+4 // IA (interrupt address) register.
 	IM = C+5 // IM (interrupt message) register.
 	IR = C+6 // IR (interrupt return) register.
@@ -194,15 +196,45 @@ type Machine struct {
 		trigger bool // interrupt trigger
 		message Word // interrupt message
 	}
+	fault struct {
+		trigger bool // trap after the current instruction
+		message Word // trap message
+		addr    Word // address of the current instruction
+	}
+	irq       irqQueue      // pending hardware interrupts
+	brk       bool          // the last instruction is brk
+	cycles    uint64        // cycles elapsed
 	regs      [32]Word      // general registers
 	ctrl      [8]Word       // control registers
 	text      [0x10000]Word // memory
+	devices   []Device      // hardware bus
+	journal   journal       // event log being recorded or replayed
+	stepping  bool          // an instruction is being executed
+	history   *History      // undo log of steps
+	debug     *debugger     // breakpoints
+	mmio      *mmioMap      // memory-mapped regions
+	mpu       *MPU          // memory protection unit
+	cache     *decodeCache  // predecoded instructions
+
+	// Strict makes faulting instructions trap through IA instead of
+	// being returned to the host, as protection violations always do,
+	// and privileged instructions trap in user mode instead of being
+	// ignored.
+	Strict bool
 }
 
-// Reset the machine to its' initial state
+// Reset the machine to its' initial state. Devices stay attached and
+// memory-mapped regions stay mapped, and those implementing Resetter are
+// reset. The MPU stays attached, disabled and with its registers cleared.
 func (m *Machine) Reset() {
 	m.interrupt.trigger = false
 	m.interrupt.message = 0
+	m.fault.trigger = false
+	m.fault.message = 0
+	m.fault.addr = 0
+	m.irq = irqQueue{}
+	m.brk = false
+	m.cycles = 0
 	for i := range m.regs {
 		m.regs[i] = 0
 	}
@@ -212,6 +244,10 @@ func (m *Machine) Reset() {
 	for i := range m.text {
 		m.text[i] = 0
 	}
+	if m.mpu != nil {
+		*m.mpu = MPU{}
+	}
+	m.resetDevices()
 }
 
 // R returns a pointer to a global register.
@@ -230,6 +266,11 @@ func (m *Machine) C(c Word) *Word {
 	return &m.ctrl[c]
 }
 
+// Cycles returns number of cycles elapsed since reset.
+func (m *Machine) Cycles() uint64 {
+	return m.cycles
+}
+
 // PC returns program counter of the machine
 func (m *Machine) PC() *Word {
 	return &m.ctrl[PC]
@@ -240,17 +281,47 @@ func (m *Machine) Mem(i Word) *Word {
 	return &m.text[i]
 }
 
+// load reads memory at i for instructions.
+func (m *Machine) load(i Word) Word {
+	if m.fault.trigger || m.mpu != nil && !m.mpu.check(m, i, MPU_R) {
+		return 0 // faulting instructions are not executed
+	}
+	if m.debug != nil {
+		m.debug.access(m, i, false)
+	}
+	if m.mmio != nil {
+		if r := m.mmio.find(i); r != nil {
+			return r.h.Load(m, i-r.base)
+		}
+	}
+	return m.text[i]
+}
+
+// store writes w to memory at i for instructions.
+func (m *Machine) store(i, w Word) {
+	if m.fault.trigger || m.mpu != nil && !m.mpu.check(m, i, MPU_W) {
+		return
+	}
+	if m.debug != nil {
+		m.debug.access(m, i, true)
+	}
+	if m.mmio != nil {
+		if r := m.mmio.find(i); r != nil {
+			r.h.Store(m, i-r.base, w)
+			return
+		}
+	}
+	if m.history != nil {
+		m.history.note(m, i)
+	}
+	m.text[i] = w
+}
+
 // Text is like Mem(), but points to instruction.
 func (m *Machine) Text(i Word) *Instruction {
 	return (*Instruction)(&m.text[i])
 }
 
-// HWInterrupt triggers hardware interrupt with message i.
-func (m *Machine) HWInterrupt(i Word) {
-	m.interrupt.trigger = true
-	m.interrupt.message = i
-}
-
 // Load copies words from slice to Machine memory.
 func (m *Machine) Load(text []Word) {
 	for i, w := range text {
@@ -261,20 +332,73 @@ func (m *Machine) Load(text []Word) {
 	}
 }
 
-// Step executes one instruction and increments the Program Counter.
-func (m *Machine) Step() (interrupt Word, trigger bool) {
-	o, args := (*m.Text(*m.PC())).decouple()
-	op := op_funcs[o]
-	if op == nil {
-		return 0xffff, true
+// Step dispatches pending hardware interrupt, if any, then executes one
+// instruction and increments the Program Counter. Hardware interrupts not
+// accepted by any device are returned to the caller. Faulting instructions
+// are not executed and their faults are returned unless the machine is in
+// strict mode or the fault is a protection violation. When replaying an event log, Step returns *Divergence if
+// the run does not match the log. Step returns *Hit when a breakpoint is
+// hit, along with host interrupt sent by the instruction, if any.
+func (m *Machine) Step() (interrupt Word, trigger bool, err error) {
+	if m.debug != nil {
+		if hit := m.debug.begin(m); hit != nil {
+			return 0, false, hit
+		}
+	}
+	if m.journal != nil {
+		if err = m.journal.begin(m); err != nil {
+			return 0, false, err
+		}
+	}
+	if m.history != nil {
+		m.history.begin(m)
+	}
+	m.dispatch()
+	if m.journal != nil {
+		if err = m.journal.fetch(m, *m.PC()); err != nil {
+			return 0, false, err
+		}
+	}
+	m.brk = false
+	pc := *m.PC()
+	d := m.decode(pc)
+	m.fault.addr = pc
+	m.cycles += uint64(d.cycles)
+	if m.mpu != nil {
+		m.mpu.fetch(m, pc)
 	}
 	*m.R(0) = 0
 	*m.PC()++
-	op(m, args...)
+	m.stepping = true
+	switch {
+	case m.fault.trigger:
+	case d.f != nil:
+		d.f(m, d.x, d.y)
+	default:
+		m.trap(TRAP_OPCODE)
+	}
+	m.stepping = false
+	if m.fault.trigger && m.fault.message == TRAP_PROTECTION {
+		m.mpu.rollback(m)
+	}
+	if m.history != nil {
+		m.history.end(m)
+	}
+	if m.journal != nil {
+		if err = m.journal.end(m); err != nil {
+			return 0, false, err
+		}
+	}
+	if m.fault.trigger {
+		return 0, false, m.fail()
+	}
+	if m.debug != nil {
+		err = m.debug.end(m)
+	}
 	if m.interrupt.trigger {
 		m.interrupt.trigger = false
-		return m.interrupt.message, true
+		return m.interrupt.message, true, err
 	} else {
-		return 0, false
+		return 0, false, err
 	}
 }
@@ -16,7 +16,7 @@ func mk_machine() *Machine {
 // but no more than step_max times.
 func exec_until_interrupt(m *Machine, step_max int) (msg Word, t bool) {
 	for i := 0; !t && i < step_max; i++ {
-		msg, t = m.Step()
+		msg, t, _ = m.Step()
 	}
 	return msg, t
 }
@@ -36,7 +36,7 @@ func TestSimpleAddition(t *testing.T) {
 		t.Logf("r1: %04x; r2: %04x; pc %04x",
 			*m.R(1), *m.R(2), *m.PC())
 		t.Log(instr)
-		i, _ = m.Step()
+		i, _, _ = m.Step()
 	}
 	if r := *m.R(R+1); r != 3 {
 		t.Errorf("r1 == %x, want %x", r, 3)
@@ -61,7 +61,7 @@ func TestImmediateLoadingAddition(t *testing.T) {
 		t.Logf("r1: %04v; r2: %04v; pc: %04v",
 			*m.R(1), *m.R(2), *m.C(PC))
 		t.Logf("%2d %2d, %2d", instr.Op(), instr.A(), instr.B())
-		_, i = m.Step()
+		_, i, _ = m.Step()
 	}
 	if r := *m.R(R+1); r != 3 {
 		t.Errorf("r1 == %x, want %x", r, 3)
@@ -113,7 +113,7 @@ func TestFibFunction(t *testing.T) {
 		t.Logf("a0: %04v; v0: %04v; ex: %04v, pc: %04v",
 			*m.R(A+0), *m.R(V+0), *m.C(EX), *m.PC())
 		t.Log(instr)
-		_, i = m.Step()
+		_, i, _ = m.Step()
 	}
 	if ret := *m.R(V+0); ret != 34 {
 		t.Errorf("fib(9) returns %v (%d), want %d", ret, ret, 34)
@@ -0,0 +1,150 @@
+package machine
+
+import (
+	"context"
+	"fmt"
+)
+
+// RunOptions control Machine.Run.
+type RunOptions struct {
+	Steps  uint64 // instruction budget, zero means unlimited
+	Cycles uint64 // cycle budget, zero means unlimited
+
+	// StopOn reports whether host interrupt msg stops the run. When it
+	// is nil, every host interrupt does.
+	StopOn func(msg Word) bool
+
+	// Translation, if set, has its blocks run instead of stepping
+	// through the instructions they translate, when the machine allows.
+	Translation *Translation
+}
+
+// StopKind tells why Run stopped.
+type StopKind int
+
+const (
+	StopSteps      StopKind = iota // instruction budget is exhausted
+	StopCycles                     // cycle budget is exhausted
+	StopCancel                     // context is cancelled
+	StopBreak                      // brk is executed
+	StopFault                      // instruction faulted
+	StopInterrupt                  // host interrupt is sent
+	StopReplay                     // replay diverged or failed
+	StopBreakpoint                 // breakpoint is hit
+)
+
+var stop_strings = [...]string{
+	StopSteps:      "instruction budget exhausted",
+	StopCycles:     "cycle budget exhausted",
+	StopCancel:     "cancelled",
+	StopBreak:      "break",
+	StopFault:      "fault",
+	StopInterrupt:  "interrupt",
+	StopReplay:     "replay failed",
+	StopBreakpoint: "breakpoint",
+}
+
+func (k StopKind) String() string {
+	if k < 0 || int(k) >= len(stop_strings) {
+		return fmt.Sprintf("StopKind(%d)", int(k))
+	}
+	return stop_strings[k]
+}
+
+// StopReason describes how Run stopped.
+type StopReason struct {
+	Kind    StopKind
+	PC      Word   // program counter when stopped
+	Steps   uint64 // instructions executed by Run
+	Cycles  uint64 // cycles elapsed during Run
+	Message Word   // host interrupt message, for StopInterrupt
+	Err     error  // fault, context or replay error, or breakpoint hit
+}
+
+func (r StopReason) String() string {
+	s := fmt.Sprintf("%v at %04x after %d steps, %d cycles",
+		r.Kind, uint16(r.PC), r.Steps, r.Cycles)
+	switch {
+	case r.Kind == StopInterrupt:
+		s += fmt.Sprintf(": message %04x", uint16(r.Message))
+	case r.Err != nil:
+		s += ": " + r.Err.Error()
+	}
+	return s
+}
+
+// cancelPeriod is the number of steps between checks for cancellation.
+const cancelPeriod = 1024
+
+// Run steps the machine until budget in opts is exhausted, ctx is
+// cancelled, brk is executed, an instruction faults, a host interrupt
+// selected by opts is sent, a replay fails or a breakpoint is hit.
+// Breakpoints hit before the instruction at r.PC is executed are not
+// hit again when Run is resumed. Blocks of opts.Translation are run only
+// when they fit in the remaining budget, so runs stop where stepping would.
+func (m *Machine) Run(ctx context.Context, opts RunOptions) (r StopReason) {
+	start, check := m.cycles, uint64(0)
+	defer func() {
+		r.PC = *m.PC()
+		r.Cycles = m.cycles - start
+	}()
+	var core *Core
+	if opts.Translation != nil {
+		core = m.core()
+	}
+	for {
+		if r.Steps >= check {
+			check = r.Steps + cancelPeriod
+			if err := ctx.Err(); err != nil {
+				r.Kind, r.Err = StopCancel, err
+				return r
+			}
+		}
+		if opts.Steps != 0 && r.Steps >= opts.Steps {
+			r.Kind = StopSteps
+			return r
+		}
+		if opts.Cycles != 0 && m.cycles-start >= opts.Cycles {
+			r.Kind = StopCycles
+			return r
+		}
+		if core != nil {
+			b := m.block(opts.Translation)
+			if b != nil && (opts.Steps == 0 || r.Steps+b.Steps <= opts.Steps) &&
+				(opts.Cycles == 0 || m.cycles-start+b.Cycles < opts.Cycles) {
+				r.Steps += b.Run(core)
+				m.brk = false
+				continue
+			}
+		}
+		msg, trigger, err := m.Step()
+		switch err := err.(type) {
+		case nil:
+		case *Fault:
+			r.Kind, r.Err = StopFault, err
+			return r
+		case *Hit:
+			r.Kind, r.Err = StopBreakpoint, err
+			if k := err.Breakpoint.Kind; k == BreakExec || k == BreakCond {
+				return r // the instruction is not executed
+			}
+			r.Steps++
+			if trigger {
+				r.Message = msg
+			}
+			return r
+		default:
+			r.Kind, r.Err = StopReplay, err
+			return r
+		}
+		r.Steps++
+		switch {
+		case m.brk:
+			r.Kind = StopBreak
+			return r
+		case trigger && (opts.StopOn == nil || opts.StopOn(msg)):
+			r.Kind, r.Message = StopInterrupt, msg
+			return r
+		}
+	}
+}
@@ -0,0 +1,105 @@
+package machine
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestRun(t *testing.T) {
+	t.Parallel()
+	loop := []Word{i1(OP_JMP, 0)}
+	checks := []struct {
+		name  string
+		text  []Word
+		opts  RunOptions
+		kind  StopKind
+		pc    Word
+		steps uint64
+	}{
+		{"steps", loop, RunOptions{Steps: 100}, StopSteps, 0, 100},
+		{"cycles", loop, RunOptions{Cycles: 50}, StopCycles, 0, 25},
+		{"break", []Word{
+			i2(OP_MOV, 1, 2),
+			i2(OP_IMP, IMP_BRK, 0),
+			0,
+			0x20: i1(OP_JMP, 0),
+		}, RunOptions{}, StopBreak, 0x20, 2},
+		{"fault", []Word{
+			i2(OP_MOV, 1, 2),
+			i1(0x3f, 0),
+		}, RunOptions{}, StopFault, 1, 1},
+		{"interrupt", []Word{
+			i1(OP_HWI, 1),
+			i1(OP_HWI, 2),
+			i1(OP_HWI, 3),
+		}, RunOptions{StopOn: func(msg Word) bool {
+			return msg == 2
+		}}, StopInterrupt, 2, 2},
+	}
+	for _, ck := range checks {
+		m := mk_machine()
+		*m.C(IA) = 0x20
+		m.Load(ck.text)
+		r := m.Run(context.Background(), ck.opts)
+		if r.Kind != ck.kind || r.PC != ck.pc || r.Steps != ck.steps {
+			t.Errorf("%s: stopped with %v, want %v at %v after %d",
+				ck.name, r, ck.kind, ck.pc, ck.steps)
+		}
+		if r.Cycles != m.Cycles() {
+			t.Errorf("%s: run takes %d cycles, machine counts %d",
+				ck.name, r.Cycles, m.Cycles())
+		}
+		switch {
+		case ck.kind == StopInterrupt && r.Message != 2:
+			t.Errorf("%s: message is %v, want 2", ck.name, r.Message)
+		case ck.kind == StopFault && r.Err == nil:
+			t.Errorf("%s: missing fault", ck.name)
+		}
+	}
+}
+
+func TestRunCancel(t *testing.T) {
+	t.Parallel()
+	m := mk_machine()
+	m.Load([]Word{i1(OP_JMP, 0)})
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	r := m.Run(ctx, RunOptions{})
+	if r.Kind != StopCancel || r.Err != context.Canceled || r.Steps != 0 {
+		t.Errorf("stopped with %v, want cancellation", r)
+	}
+	ctx, cancel = context.WithTimeout(context.Background(),
+		10*time.Millisecond)
+	defer cancel()
+	r = m.Run(ctx, RunOptions{})
+	if r.Kind != StopCancel || r.Err != context.DeadlineExceeded {
+		t.Errorf("stopped with %v, want timeout", r)
+	}
+}
+
+func TestRunInterrupt(t *testing.T) {
+	t.Parallel()
+	text := []Word{
+		i2(OP_IMP, IMP_MOV, S+0),
+		5,
+		i2(OP_INC, S+1, 2), // :loop
+		i2(OP_IMP, IMP_SUB, S+0),
+		1,
+		i2(OP_CMP, S+0, ZR),
+		i1(OP_JNE, -4),
+		i1(OP_HWI, 9),
+	}
+	m, n := mk_machine(), mk_machine()
+	m.Load(text)
+	n.Load(text)
+	r := m.Run(context.Background(), RunOptions{Steps: 100})
+	msg, ok := exec_until_interrupt(n, 100)
+	if r.Kind != StopInterrupt || !ok || r.Message != msg {
+		t.Fatalf("run stopped with %v, stepping with %v, %v", r, msg, ok)
+	}
+	if !same_state(m, n) || *m.R(S + 1) != 10 {
+		t.Errorf("s1 is %v after run and %v after stepping, want 10",
+			*m.R(S + 1), *n.R(S + 1))
+	}
+}
@@ -0,0 +1,271 @@
+package machine
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding"
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+// Snapshots hold complete machine state, including pending interrupts and
+// state of attached devices. All integers are little-endian:
+//
+//	magic    [4]byte   "RHMS"
+//	version  uint16    SNAPSHOT_VERSION
+//	flags    uint16    SNAP_ZLIB: the rest of snapshot is zlib-compressed
+//	cycles   uint64    cycles elapsed since reset
+//	state    uint16    SNAP_STRICT, SNAP_INTERRUPT, SNAP_BRK and SNAP_MPU bits
+//	message  Word      host interrupt message
+//	regs     [32]Word  general registers
+//	ctrl     [8]Word   control registers
+//	text     [0x10000]Word
+//	nirq     uint16    number of pending hardware interrupts
+//	irq      [nirq]Word, oldest first
+//	mpu      [MPU_SIZE]Word, MPU registers if SNAP_MPU is set
+//	ndev     uint16    number of attached devices
+//	devices  [ndev] of
+//	  info   [3]Word   vendor, id and version
+//	  size   uint32    size of device state, zero for stateless devices
+//	  state  [size]byte
+//
+// Devices implementing encoding.BinaryMarshaler have their state saved,
+// and devices implementing encoding.BinaryUnmarshaler have it restored.
+// Version 1 snapshots, made before MPU, are restored as well.
+const SNAPSHOT_VERSION = 2
+
+var snapshot_magic = [4]byte{'R', 'H', 'M', 'S'}
+
+// Snapshot flags.
+const (
+	SNAP_ZLIB = 1 << iota
+)
+
+// Snapshot state bits.
+const (
+	SNAP_STRICT    = 1 << iota // Strict is set
+	SNAP_INTERRUPT             // host interrupt is triggered
+	SNAP_BRK                   // the last instruction is brk
+	SNAP_MPU                   // MPU is attached
+)
+
+type snapshotHeader struct {
+	Magic   [4]byte
+	Version uint16
+	Flags   uint16
+}
+
+type snapshotBody struct {
+	Cycles  uint64
+	State   uint16
+	Message Word
+	Regs    [32]Word
+	Ctrl    [8]Word
+	Text    [0x10000]Word
+}
+
+type snapshotDevice struct {
+	Info DeviceInfo
+	Size uint32
+}
+
+// Snapshot writes state of the machine and its devices to w, compressing
+// it when compress is true.
+func (m *Machine) Snapshot(w io.Writer, compress bool) error {
+	hdr := snapshotHeader{snapshot_magic, SNAPSHOT_VERSION, 0}
+	if compress {
+		hdr.Flags |= SNAP_ZLIB
+	}
+	if err := binary.Write(w, binary.LittleEndian, &hdr); err != nil {
+		return err
+	}
+	if !compress {
+		return m.snapshot(w)
+	}
+	zw := zlib.NewWriter(w)
+	if err := m.snapshot(zw); err != nil {
+		return err
+	}
+	return zw.Close()
+}
+
+func (m *Machine) snapshot(w io.Writer) error {
+	body := &snapshotBody{
+		Cycles:  m.cycles,
+		Message: m.interrupt.message,
+		Regs:    m.regs,
+		Ctrl:    m.ctrl,
+		Text:    m.text,
+	}
+	if m.Strict {
+		body.State |= SNAP_STRICT
+	}
+	if m.interrupt.trigger {
+		body.State |= SNAP_INTERRUPT
+	}
+	if m.brk {
+		body.State |= SNAP_BRK
+	}
+	irq := make([]Word, m.irq.n)
+	for i := range irq {
+		irq[i] = m.irq.msgs[(m.irq.head+i)%len(m.irq.msgs)]
+	}
+	data := []interface{}{body, uint16(len(irq)), irq}
+	if m.mpu != nil {
+		body.State |= SNAP_MPU
+		data = append(data, &m.mpu.regs)
+	}
+	data = append(data, uint16(len(m.devices)))
+	for _, d := range data {
+		if err := binary.Write(w, binary.LittleEndian, d); err != nil {
+			return err
+		}
+	}
+	for n, d := range m.devices {
+		var state []byte
+		if s, ok := d.(encoding.BinaryMarshaler); ok {
+			var err error
+			if state, err = s.MarshalBinary(); err != nil {
+				return fmt.Errorf("snapshot: device #%d: %v", n, err)
+			}
+		}
+		dev := snapshotDevice{d.Info(), uint32(len(state))}
+		if err := binary.Write(w, binary.LittleEndian, &dev); err != nil {
+			return err
+		}
+		if _, err := w.Write(state); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Restore reads snapshot made by Snapshot from r and loads it into the
+// machine. The same devices must be attached in the same order as when
+// the snapshot was made, and an MPU must be attached if there was one. The machine is left unchanged if the snapshot is
+// malformed or made with different devices, but device state may be
+// partially restored if a device fails to load it.
+func (m *Machine) Restore(r io.Reader) error {
+	var hdr snapshotHeader
+	if err := binary.Read(r, binary.LittleEndian, &hdr); err != nil {
+		return snapshotError(err)
+	}
+	switch {
+	case hdr.Magic != snapshot_magic:
+		return fmt.Errorf("snapshot: bad magic %q", hdr.Magic[:])
+	case hdr.Version < 1 || hdr.Version > SNAPSHOT_VERSION:
+		return fmt.Errorf("snapshot: unsupported version %d", hdr.Version)
+	case hdr.Flags&^SNAP_ZLIB != 0:
+		return fmt.Errorf("snapshot: unknown flags %04x", hdr.Flags)
+	}
+	if hdr.Flags&SNAP_ZLIB == 0 {
+		return m.restore(r, false)
+	}
+	zr, err := zlib.NewReader(r)
+	if err != nil {
+		return snapshotError(err)
+	}
+	defer zr.Close()
+	return m.restore(zr, true)
+}
+
+// restore loads snapshot body from r. When whole is true, r must end with
+// the snapshot.
+func (m *Machine) restore(r io.Reader, whole bool) error {
+	body := new(snapshotBody)
+	var nirq, ndev uint16
+	if err := binary.Read(r, binary.LittleEndian, body); err != nil {
+		return snapshotError(err)
+	}
+	if err := binary.Read(r, binary.LittleEndian, &nirq); err != nil {
+		return snapshotError(err)
+	}
+	if nirq > IRQ_MAX {
+		return fmt.Errorf("snapshot: %d pending interrupts", nirq)
+	}
+	var irq irqQueue
+	irq.n = int(nirq)
+	if err := binary.Read(r, binary.LittleEndian, irq.msgs[:nirq]); err != nil {
+		return snapshotError(err)
+	}
+	var mpu [MPU_SIZE]Word
+	switch has := body.State&SNAP_MPU != 0; {
+	case has && m.mpu == nil:
+		return fmt.Errorf("snapshot: MPU isn't attached")
+	case !has && m.mpu != nil:
+		return fmt.Errorf("snapshot: MPU is attached, snapshot has none")
+	case has:
+		if err := binary.Read(r, binary.LittleEndian, &mpu); err != nil {
+			return snapshotError(err)
+		}
+	}
+	if err := binary.Read(r, binary.LittleEndian, &ndev); err != nil {
+		return snapshotError(err)
+	}
+	if int(ndev) != len(m.devices) {
+		return fmt.Errorf("snapshot: %d devices, %d attached",
+			ndev, len(m.devices))
+	}
+	states := make([][]byte, ndev)
+	for n, d := range m.devices {
+		var dev snapshotDevice
+		if err := binary.Read(r, binary.LittleEndian, &dev); err != nil {
+			return snapshotError(err)
+		}
+		if info := d.Info(); dev.Info != info {
+			return fmt.Errorf("snapshot: device #%d is %v, %v attached",
+				n, dev.Info, info)
+		}
+		var buf bytes.Buffer
+		if _, err := io.CopyN(&buf, r, int64(dev.Size)); err != nil {
+			return snapshotError(err)
+		}
+		states[n] = buf.Bytes()
+		_, ok := d.(encoding.BinaryUnmarshaler)
+		if !ok && dev.Size != 0 {
+			return fmt.Errorf("snapshot: device #%d can't restore state", n)
+		}
+	}
+	if whole {
+		if n, err := io.Copy(io.Discard, r); err != nil {
+			return snapshotError(err)
+		} else if n != 0 {
+			return fmt.Errorf("snapshot: trailing data")
+		}
+	}
+
+	m.cycles = body.Cycles
+	m.Strict = body.State&SNAP_STRICT != 0
+	m.interrupt.trigger = body.State&SNAP_INTERRUPT != 0
+	m.interrupt.message = body.Message
+	m.brk = body.State&SNAP_BRK != 0
+	m.fault.trigger = false
+	m.fault.message = 0
+	m.fault.addr = 0
+	m.regs = body.Regs
+	m.ctrl = body.Ctrl
+	m.text = body.Text
+	m.irq = irq
+	if m.mpu != nil {
+		m.mpu.regs, m.mpu.addr = mpu, 0
+	}
+	for n, d := range m.devices {
+		s, ok := d.(encoding.BinaryUnmarshaler)
+		if !ok {
+			continue
+		}
+		if err := s.UnmarshalBinary(states[n]); err != nil {
+			return fmt.Errorf("snapshot: device #%d: %v", n, err)
+		}
+	}
+	return nil
+}
+
+// snapshotError reports truncated snapshots as such.
+func snapshotError(err error) error {
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		return fmt.Errorf("snapshot: truncated")
+	}
+	return fmt.Errorf("snapshot: %v", err)
+}
@@ -0,0 +1,140 @@
+package machine
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"strings"
+	"testing"
+)
+
+// counter is a device which counts interrupts sent to it into a0.
+type counter struct {
+	n Word
+}
+
+func (d *counter) Info() DeviceInfo {
+	return DeviceInfo{Vendor: 0x7e57, ID: 0xc047, Version: 1}
+}
+
+func (d *counter) Interrupt(m *Machine) {
+	d.n++
+	*m.R(A + 0) = d.n
+}
+
+func (d *counter) MarshalBinary() ([]byte, error) {
+	return []byte{byte(d.n), byte(d.n >> 8)}, nil
+}
+
+func (d *counter) UnmarshalBinary(data []byte) error {
+	if len(data) != 2 {
+		return errors.New("bad counter state")
+	}
+	d.n = Word(data[0]) | Word(data[1])<<8
+	return nil
+}
+
+var counting = []Word{
+	i2(OP_IMP, IMP_MOV, T+0),
+	0x20,
+	i2(OP_MTC, IA, T+0),
+	i1(OP_HWI, 0), // :loop
+	i2(OP_INC, S+0, 1),
+	i1(OP_JMP, -2),
+	0x20: i2(OP_INC, S+1, 1),
+	i1(OP_IRE, 0),
+}
+
+// same_state reports whether machines a and b are in the same state.
+func same_state(a, b *Machine) bool {
+	if a.regs != b.regs || a.ctrl != b.ctrl || a.text != b.text ||
+		a.cycles != b.cycles || a.irq.n != b.irq.n {
+		return false
+	}
+	for i := 0; i < a.irq.n; i++ {
+		ai := a.irq.msgs[(a.irq.head+i)%IRQ_MAX]
+		bi := b.irq.msgs[(b.irq.head+i)%IRQ_MAX]
+		if ai != bi {
+			return false
+		}
+	}
+	return true
+}
+
+func TestSnapshot(t *testing.T) {
+	t.Parallel()
+	for _, compress := range []bool{false, true} {
+		m := mk_machine()
+		d := new(counter)
+		m.Attach(d)
+		m.Load(counting)
+		exec_until_interrupt(m, 20)
+		m.HWInterrupt(7)
+		m.HWInterrupt(8)
+		var buf bytes.Buffer
+		if err := m.Snapshot(&buf, compress); err != nil {
+			t.Fatalf("compress %v: %v", compress, err)
+		}
+		size := buf.Len()
+
+		n := new(Machine)
+		nd := new(counter)
+		n.Attach(nd)
+		if err := n.Restore(&buf); err != nil {
+			t.Fatalf("compress %v: %v", compress, err)
+		}
+		if !same_state(m, n) || nd.n != d.n {
+			t.Errorf("compress %v: restored state differs", compress)
+		}
+		exec_until_interrupt(m, 50)
+		exec_until_interrupt(n, 50)
+		if !same_state(m, n) || nd.n != d.n {
+			t.Errorf("compress %v: restored machine diverges", compress)
+		}
+		if compress && size > 1024 {
+			t.Errorf("compressed snapshot takes %d bytes", size)
+		}
+	}
+}
+
+func TestRestoreErrors(t *testing.T) {
+	t.Parallel()
+	m := mk_machine()
+	m.Attach(new(counter))
+	m.Load(counting)
+	exec_until_interrupt(m, 20)
+	var buf bytes.Buffer
+	if err := m.Snapshot(&buf, false); err != nil {
+		t.Fatal(err)
+	}
+	snap := buf.Bytes()
+	version := append([]byte(nil), snap...)
+	binary.LittleEndian.PutUint16(version[4:], SNAPSHOT_VERSION+1)
+	checks := []struct {
+		name    string
+		data    []byte
+		devices []Device
+		err     string
+	}{
+		{"magic", append([]byte("RHMX"), snap[4:]...),
+			[]Device{new(counter)}, "bad magic"},
+		{"version", version, []Device{new(counter)}, "unsupported version"},
+		{"truncated", snap[:len(snap)-1], []Device{new(counter)}, "truncated"},
+		{"missing device", snap, nil, "1 devices, 0 attached"},
+		{"other device", snap, []Device{new(echo)}, "device #0 is"},
+	}
+	for _, ck := range checks {
+		n := mk_machine()
+		for _, d := range ck.devices {
+			n.Attach(d)
+		}
+		*n.R(S + 0) = 0x77
+		err := n.Restore(bytes.NewReader(ck.data))
+		if err == nil || !strings.Contains(err.Error(), ck.err) {
+			t.Errorf("%s: got error %v, want %q", ck.name, err, ck.err)
+		}
+		if *n.R(S + 0) != 0x77 {
+			t.Errorf("%s: failed restore changes the machine", ck.name)
+		}
+	}
+}
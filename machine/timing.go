@@ -0,0 +1,71 @@
+package machine
+
+// Cycle costs not covered by instruction tables.
+const (
+	BRANCH_PENALTY   = 1 // taken jumps take this much more
+	INTERRUPT_CYCLES = 2 // hardware interrupt dispatch
+)
+
+// Cycle costs of ordinary and unary instructions. Zero marks illegal
+// opcodes, which take one cycle to fail.
+var op_cycles = [64]uint64{
+	OP_MOV: 1, OP_MTC: 1, OP_MFC: 1,
+
+	OP_STR: 2, OP_PSH: 2, OP_LOA: 2, OP_POP: 2, OP_MOM: 3,
+
+	OP_SRL: 1 + BRANCH_PENALTY,
+
+	OP_ADD: 1, OP_ADX: 1, OP_SUB: 1, OP_SBX: 1,
+	OP_MUL: 2, OP_MLI: 2,
+	OP_DIV: 4, OP_DVI: 4, OP_MOD: 4, OP_MDI: 4,
+	OP_INC: 1, OP_GBS: 1,
+
+	OP_AND: 1, OP_IOR: 1, OP_XOR: 1, OP_BIC: 1,
+	OP_SHL: 1, OP_ASR: 1, OP_SHR: 1, OP_ROL: 1, OP_ROR: 1,
+
+	OP_TST: 1, OP_TEQ: 1, OP_CMP: 1, OP_CMN: 1,
+
+	OP_JMP: 1, OP_JLT: 1, OP_JLE: 1, OP_JGT: 1, OP_JGE: 1,
+	OP_JEQ: 1, OP_JNE: 1,
+
+	OP_SWI: 2, OP_HWI: 2, OP_IRE: 2,
+}
+
+// Cycle costs of IMP instructions, including fetching the immediate word.
+// Zero marks illegal subopcodes.
+var imp_cycles = [32]uint64{
+	IMP_BRK: 2, IMP_MOV: 2, IMP_MTC: 2,
+
+	IMP_STR: 3, IMP_PSH: 3,
+
+	IMP_SRL: 2 + BRANCH_PENALTY,
+
+	IMP_ADD: 2, IMP_ADX: 2, IMP_SUB: 2, IMP_SBX: 2,
+	IMP_MUL: 3, IMP_MLI: 3,
+	IMP_DIV: 5, IMP_DVI: 5, IMP_MOD: 5, IMP_MDI: 5,
+	IMP_INC: 2,
+
+	IMP_AND: 2, IMP_IOR: 2, IMP_XOR: 2, IMP_BIC: 2,
+	IMP_SHL: 2, IMP_ASR: 2, IMP_SHR: 2, IMP_ROL: 2, IMP_ROR: 2,
+
+	IMP_TST: 2, IMP_TEQ: 2, IMP_CMP: 2, IMP_CMN: 2,
+}
+
+// Cycles returns number of cycles instruction i takes, not counting the
+// penalty for taken jumps.
+func (i Instruction) Cycles() uint64 {
+	n := op_cycles[i.Op()]
+	if i.Op() == OP_IMP {
+		n = imp_cycles[i.A()]
+	}
+	if n == 0 {
+		return 1
+	}
+	return n
+}
+
+// jump moves PC by sign-extended 10-bit c relative to the jump instruction.
+func (m *Machine) jump(c Word) {
+	*m.PC() += sextend10(c) - 1
+	m.cycles += BRANCH_PENALTY
+}
@@ -0,0 +1,41 @@
+package machine
+
+import "testing"
+
+func TestCycles(t *testing.T) {
+	t.Parallel()
+	checks := []struct {
+		text   []Word
+		cycles uint64
+	}{
+		{[]Word{i2(OP_MOV, 1, 2)}, 1},
+		{[]Word{i2(OP_MOM, 1, 2)}, 3},
+		{[]Word{i2(OP_IMP, IMP_ADD, 1), 5}, 2},
+		{[]Word{i2(OP_IMP, IMP_DIV, 1), 5}, 5},
+		{[]Word{i1(OP_JMP, 4)}, 1 + BRANCH_PENALTY},
+		{[]Word{i1(OP_JEQ, 4)}, 1 + BRANCH_PENALTY},
+		{[]Word{i1(OP_JNE, 4)}, 1},
+		{[]Word{i1(0x3e, 0)}, 1},
+	}
+	for _, ck := range checks {
+		m := mk_machine()
+		m.Load(ck.text)
+		m.Step()
+		if c := m.Cycles(); c != ck.cycles {
+			t.Errorf("%04x: takes %d cycles, want %d",
+				ck.text[0], c, ck.cycles)
+		}
+	}
+}
+
+func TestInterruptCycles(t *testing.T) {
+	t.Parallel()
+	m := mk_machine()
+	*m.C(IA) = 0x20
+	m.Load([]Word{i2(OP_MOV, 1, 2), 0x20: i2(OP_MOV, 1, 2)})
+	m.HWInterrupt(1)
+	m.Step()
+	if c := m.Cycles(); c != INTERRUPT_CYCLES+1 {
+		t.Errorf("takes %d cycles, want %d", c, INTERRUPT_CYCLES+1)
+	}
+}
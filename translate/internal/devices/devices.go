@@ -0,0 +1,425 @@
+// Code generated by translate. DO NOT EDIT.
+
+// Package devices is an RHMRM image translated to Go.
+package devices
+
+import (
+	"math/bits"
+
+	"github.com/niksaak/rhmrm/machine"
+)
+
+// Translation runs the image.
+var Translation = &machine.Translation{
+	Text: text,
+	Blocks: []machine.Block{
+		{Addr: 0x0002, Size: 8, Steps: 4, Cycles: 8, Run: b0002},
+		{Addr: 0x000a, Size: 89, Steps: 61, Cycles: 128, Run: b000a},
+		{Addr: 0x0067, Size: 1, Steps: 1, Cycles: 2, Run: b0067},
+	},
+}
+
+var text = []machine.Word{
+	0x2080, 0x0066, 0xf840, 0x3000, 0x1040, 0xff00, 0x1840, 0x0007,
+	0x4840, 0x0028, 0x1a81, 0x1a94, 0x4a91, 0x1a95, 0x4a92, 0x1a93,
+	0x52db, 0x1a96, 0x1a97, 0x1ad8, 0x1a99, 0x1a9a, 0x52e0, 0x4ae1,
+	0x1ae2, 0x1ae4, 0x4ae5, 0x4aa6, 0x1aa7, 0x2ae8, 0x5aa9, 0x5aaa,
+	0x5aac, 0x5200, 0x04d2, 0x5240, 0x0011, 0x5280, 0x0063, 0x52c0,
+	0x0003, 0x5300, 0x0141, 0x5340, 0xfffb, 0x5380, 0x0007, 0x53c0,
+	0x0003, 0x5400, 0x000b, 0x5c40, 0x0006, 0x5c80, 0x0002, 0x54c0,
+	0x7fff, 0x5500, 0x0100, 0x5540, 0x5555, 0x5580, 0x0f0f, 0x55c0,
+	0x0003, 0x5600, 0x0002, 0x5e40, 0x0001, 0x5680, 0x0004, 0x56c0,
+	0x0009, 0x5700, 0x00ff, 0x5740, 0x1234, 0x57c0, 0x0001, 0x57c5,
+	0x5fc5, 0x5084, 0x1306, 0x67c5, 0xfb41, 0x6b87, 0x7840, 0x2000,
+	0x6bc8, 0x78c0, 0x004d, 0x7900, 0x004e, 0x1a00, 0x0002, 0x4a80,
+	0x0001, 0x026b, 0xea36, 0x017b, 0x003c, 0x027c, 0x2c03, 0x87c5,
+	0x003d,
+}
+
+// b0002 runs 0002-0009.
+func b0002(c *machine.Core) uint64 {
+	r, k, cy := c.R, c.C, c.Cycles
+	var n machine.Word
+	// 0002: imp mov 31, 3000
+	r[0] = 0
+	n = 0x3000
+	r[31] = n
+	// 0004: imp mov  2, ff00
+	r[0] = 0
+	n = 0xff00
+	r[2] = n
+	// 0006: imp mov  3, 0007
+	r[0] = 0
+	n = 0x0007
+	r[3] = n
+	// 0008: imp mov  9, 0028
+	r[0] = 0
+	n = 0x0028
+	r[9] = n
+	*cy += 8
+	k[machine.PC] = 0x000a
+	return 4
+}
+
+// b000a runs 000a-0062.
+func b000a(c *machine.Core) uint64 {
+	r, k, cy := c.R, c.C, c.Cycles
+	var n machine.Word
+	// 000a: mov 10, 3
+	r[0] = 0
+	r[10] = r[3]
+	// 000b: mul 10, 3
+	r[0] = 0
+	k[machine.EX], r[10] = machine.Word((uint32(r[10])*uint32(r[3]))>>16), machine.Word(uint32(r[10])*uint32(r[3]))
+	// 000c: adx 10, 9
+	r[0] = 0
+	k[machine.EX], r[10] = machine.Word((uint32(r[10])+uint32(r[9])+uint32(k[machine.EX]))>>16), machine.Word(uint32(r[10])+uint32(r[9])+uint32(k[machine.EX]))
+	// 000d: mli 10, 3
+	r[0] = 0
+	k[machine.EX], r[10] = machine.Word((int32(r[10])*int32(r[3]))>>16), machine.Word(int32(r[10])*int32(r[3]))
+	// 000e: sub 10, 9
+	r[0] = 0
+	k[machine.EX], r[10] = machine.Word((uint32(r[10])-uint32(r[9]))>>16), machine.Word(uint32(r[10])-uint32(r[9]))
+	// 000f: sbx 10, 3
+	r[0] = 0
+	k[machine.EX], r[10] = machine.Word((uint32(r[10])-uint32(r[3])+uint32(k[machine.EX]))>>16), machine.Word(uint32(r[10])-uint32(r[3])+uint32(k[machine.EX]))
+	// 0010: gbs 11, 10
+	r[0] = 0
+	r[11] = machine.Word(bits.Len16(uint16(r[10])))
+	// 0011: div 10, 3
+	r[0] = 0
+	if r[3] == 0 {
+		r[10], k[machine.EX] = 0xffff, 0
+	} else {
+		k[machine.EX], r[10] = machine.Word(uint32(r[10])<<16/uint32(r[3])), machine.Word((uint32(r[10])<<16/uint32(r[3]))>>16)
+	}
+	// 0012: dvi 10, 3
+	r[0] = 0
+	if r[3] == 0 {
+		r[10], k[machine.EX] = 0xffff, 0
+	} else {
+		k[machine.EX], r[10] = machine.Word(int32(r[10])<<16/int32(r[3])), machine.Word((int32(r[10])<<16/int32(r[3]))>>16)
+	}
+	// 0013: mod 11, 3
+	r[0] = 0
+	if r[3] == 0 {
+		r[11] = 0
+	} else {
+		r[11] %= r[3]
+	}
+	// 0014: mdi 10, 3
+	r[0] = 0
+	if r[3] == 0 {
+		r[10] = 0
+	} else {
+		r[10] = machine.Word(int16(r[10]) % int16(r[3]))
+	}
+	// 0015: inc 10, 3
+	r[0] = 0
+	r[10] += 3
+	// 0016: and 11, 10
+	r[0] = 0
+	r[11] &= r[10]
+	// 0017: ior 11, 9
+	r[0] = 0
+	r[11] |= r[9]
+	// 0018: xor 11, 3
+	r[0] = 0
+	r[11] ^= r[3]
+	// 0019: shl 11, 3
+	r[0] = 0
+	k[machine.EX], r[11] = machine.Word((uint32(r[11])<<uint32(r[3]))>>16), machine.Word(uint32(r[11])<<uint32(r[3]))
+	// 001a: asr 11, 9
+	r[0] = 0
+	k[machine.EX], r[11] = machine.Word(int32(r[11])<<16>>uint32(r[9])), machine.Word((int32(r[11])<<16>>uint32(r[9]))>>16)
+	// 001b: shr 10, 9
+	r[0] = 0
+	k[machine.EX], r[10] = machine.Word(uint32(r[10])<<16>>uint32(r[9])), machine.Word((uint32(r[10])<<16>>uint32(r[9]))>>16)
+	// 001c: rol 10, 3
+	r[0] = 0
+	r[10] = machine.Word(uint32(r[10])<<uint32(3)) | machine.Word((uint32(r[10])<<uint32(3))>>16)
+	// 001d: ror 11, 5
+	r[0] = 0
+	r[11] = machine.Word(uint32(r[11])<<16>>uint32(5)) | machine.Word((uint32(r[11])<<16>>uint32(5))>>16)
+	// 001e: tst 10, 11
+	r[0] = 0
+	k[machine.EX] = r[10] & r[11]
+	// 001f: teq 10, 11
+	r[0] = 0
+	k[machine.EX] = r[10] ^ r[11]
+	// 0020: cmn 10, 11
+	r[0] = 0
+	k[machine.EX] = r[10] + r[11]
+	// 0021: imp add 10, 04d2
+	r[0] = 0
+	n = 0x04d2
+	k[machine.EX], r[10] = machine.Word((uint32(r[10])+uint32(n))>>16), machine.Word(uint32(r[10])+uint32(n))
+	// 0023: imp adx 10, 0011
+	r[0] = 0
+	n = 0x0011
+	k[machine.EX], r[10] = machine.Word((uint32(r[10])+uint32(n)+uint32(k[machine.EX]))>>16), machine.Word(uint32(r[10])+uint32(n)+uint32(k[machine.EX]))
+	// 0025: imp sub 10, 0063
+	r[0] = 0
+	n = 0x0063
+	k[machine.EX], r[10] = machine.Word((uint32(r[10])-uint32(n))>>16), machine.Word(uint32(r[10])-uint32(n))
+	// 0027: imp sbx 10, 0003
+	r[0] = 0
+	n = 0x0003
+	k[machine.EX], r[10] = machine.Word((uint32(r[10])-uint32(n)+uint32(k[machine.EX]))>>16), machine.Word(uint32(r[10])-uint32(n)+uint32(k[machine.EX]))
+	// 0029: imp mul 10, 0141
+	r[0] = 0
+	n = 0x0141
+	k[machine.EX], r[10] = machine.Word((uint32(r[10])*uint32(n))>>16), machine.Word(uint32(r[10])*uint32(n))
+	// 002b: imp mli 10, fffb
+	r[0] = 0
+	n = 0xfffb
+	k[machine.EX], r[10] = machine.Word((int32(r[10])*int32(n))>>16), machine.Word(int32(r[10])*int32(n))
+	// 002d: imp div 10, 0007
+	r[0] = 0
+	n = 0x0007
+	if n == 0 {
+		r[10], k[machine.EX] = 0xffff, 0
+	} else {
+		k[machine.EX], r[10] = machine.Word(uint32(r[10])<<16/uint32(n)), machine.Word((uint32(r[10])<<16/uint32(n))>>16)
+	}
+	// 002f: imp dvi 10, 0003
+	r[0] = 0
+	n = 0x0003
+	if n == 0 {
+		r[10], k[machine.EX] = 0xffff, 0
+	} else {
+		k[machine.EX], r[10] = machine.Word(int32(r[10])<<16/int32(n)), machine.Word((int32(r[10])<<16/int32(n))>>16)
+	}
+	// 0031: imp mod 10, 000b
+	r[0] = 0
+	n = 0x000b
+	if n == 0 {
+		r[10] = 0
+	} else {
+		r[10] %= n
+	}
+	// 0033: imp mdi 11, 0006
+	r[0] = 0
+	n = 0x0006
+	if n == 0 {
+		r[11] = 0
+	} else {
+		r[11] = machine.Word(int16(r[11]) % int16(n))
+	}
+	// 0035: imp inc 11, 0002
+	r[0] = 0
+	n = 0x0002
+	r[11] += n
+	// 0037: imp and 10, 7fff
+	r[0] = 0
+	n = 0x7fff
+	r[10] &= n
+	// 0039: imp ior 10, 0100
+	r[0] = 0
+	n = 0x0100
+	r[10] |= n
+	// 003b: imp xor 10, 5555
+	r[0] = 0
+	n = 0x5555
+	r[10] ^= n
+	// 003d: imp bic 10, 0f0f
+	r[0] = 0
+	n = 0x0f0f
+	r[10] &^= n
+	// 003f: imp shl 10, 0003
+	r[0] = 0
+	n = 0x0003
+	k[machine.EX], r[10] = machine.Word((uint32(r[10])<<uint32(n))>>16), machine.Word(uint32(r[10])<<uint32(n))
+	// 0041: imp asr 10, 0002
+	r[0] = 0
+	n = 0x0002
+	k[machine.EX], r[10] = machine.Word(int32(r[10])<<16>>uint32(n)), machine.Word((int32(r[10])<<16>>uint32(n))>>16)
+	// 0043: imp shr 11, 0001
+	r[0] = 0
+	n = 0x0001
+	k[machine.EX], r[11] = machine.Word(uint32(r[11])<<16>>uint32(n)), machine.Word((uint32(r[11])<<16>>uint32(n))>>16)
+	// 0045: imp rol 10, 0004
+	r[0] = 0
+	n = 0x0004
+	r[10] = machine.Word(uint32(r[10])<<uint32(n)) | machine.Word((uint32(r[10])<<uint32(n))>>16)
+	// 0047: imp ror 10, 0009
+	r[0] = 0
+	n = 0x0009
+	r[10] = machine.Word(uint32(r[10])<<16>>uint32(n)) | machine.Word((uint32(r[10])<<16>>uint32(n))>>16)
+	// 0049: imp tst 10, 00ff
+	r[0] = 0
+	n = 0x00ff
+	k[machine.EX] = r[10] & n
+	// 004b: imp teq 10, 1234
+	r[0] = 0
+	n = 0x1234
+	k[machine.EX] = r[10] ^ n
+	// 004d: imp cmn 10, 0001
+	r[0] = 0
+	n = 0x0001
+	k[machine.EX] = r[10] + n
+	// 004f: psh 31, 10
+	r[0] = 0
+	*cy += 99
+	k[machine.PC] = 0x0050
+	{
+		r[31]--
+		w := r[31]
+		ok := c.Store(w, r[10])
+		if !ok || w-0x000a < 0x0059 {
+			k[machine.PC] = 0x0050
+			return 47
+		}
+	}
+	// 0050: psh 31, 11
+	r[0] = 0
+	*cy += 2
+	k[machine.PC] = 0x0051
+	{
+		r[31]--
+		w := r[31]
+		ok := c.Store(w, r[11])
+		if !ok || w-0x000a < 0x0059 {
+			k[machine.PC] = 0x0051
+			return 48
+		}
+	}
+	// 0051: str  2, 10
+	r[0] = 0
+	*cy += 2
+	k[machine.PC] = 0x0052
+	{
+		w := r[2]
+		ok := c.Store(w, r[10])
+		if !ok || w-0x000a < 0x0059 {
+			k[machine.PC] = 0x0052
+			return 49
+		}
+	}
+	// 0052: loa 12, 2
+	r[0] = 0
+	*cy += 2
+	k[machine.PC] = 0x0053
+	{
+		w, ok := c.Load(r[2])
+		r[12] = w
+		if !ok {
+			k[machine.PC] = 0x0053
+			return 50
+		}
+	}
+	// 0053: psh 31, 12
+	r[0] = 0
+	*cy += 2
+	k[machine.PC] = 0x0054
+	{
+		r[31]--
+		w := r[31]
+		ok := c.Store(w, r[12])
+		if !ok || w-0x000a < 0x0059 {
+			k[machine.PC] = 0x0054
+			return 51
+		}
+	}
+	// 0054: mov 13, 31
+	r[0] = 0
+	r[13] = r[31]
+	// 0055: pop 14, 13
+	r[0] = 0
+	*cy += 3
+	k[machine.PC] = 0x0056
+	{
+		w, ok := c.Load(r[13])
+		r[14] = w
+		r[13]++
+		if !ok {
+			k[machine.PC] = 0x0056
+			return 53
+		}
+	}
+	// 0056: imp mov 15, 2000
+	r[0] = 0
+	n = 0x2000
+	r[15] = n
+	// 0058: mom 15, 13
+	r[0] = 0
+	*cy += 5
+	k[machine.PC] = 0x0059
+	{
+		w, ok := c.Load(r[13])
+		v := r[15]
+		ok = c.Store(v, w) && ok
+		r[15]++
+		r[13]++
+		if !ok || v-0x000a < 0x0059 {
+			k[machine.PC] = 0x0059
+			return 55
+		}
+	}
+	// 0059: imp str 15, 004d
+	r[0] = 0
+	*cy += 3
+	k[machine.PC] = 0x005a
+	{
+		w := r[15]
+		ok := c.Store(w, 0x004d)
+		if !ok || w-0x000a < 0x0059 {
+			k[machine.PC] = 0x005b
+			return 56
+		}
+	}
+	// 005b: imp psh 15, 004e
+	r[0] = 0
+	*cy += 3
+	k[machine.PC] = 0x005c
+	{
+		r[15]--
+		w := r[15]
+		ok := c.Store(w, 0x004e)
+		if !ok || w-0x000a < 0x0059 {
+			k[machine.PC] = 0x005d
+			return 57
+		}
+	}
+	// 005d: imp add  3, 0002
+	r[0] = 0
+	n = 0x0002
+	k[machine.EX], r[3] = machine.Word((uint32(r[3])+uint32(n))>>16), machine.Word(uint32(r[3])+uint32(n))
+	// 005f: imp sub  9, 0001
+	r[0] = 0
+	n = 0x0001
+	k[machine.EX], r[9] = machine.Word((uint32(r[9])-uint32(n))>>16), machine.Word(uint32(r[9])-uint32(n))
+	// 0061: cmp  9, 0
+	r[0] = 0
+	k[machine.EX] = r[9] - r[0]
+	// 0062: jne -88
+	r[0] = 0
+	if k[machine.EX] != 0 {
+		*cy += 7
+		k[machine.PC] = 0x000a
+		return 61
+	}
+	*cy += 6
+	k[machine.PC] = 0x0063
+	return 61
+}
+
+// b0067 runs 0067-0067.
+func b0067(c *machine.Core) uint64 {
+	r, k, cy := c.R, c.C, c.Cycles
+	// 0067: psh 31, 16
+	r[0] = 0
+	*cy += 2
+	k[machine.PC] = 0x0068
+	{
+		r[31]--
+		w := r[31]
+		ok := c.Store(w, r[16])
+		if !ok || w-0x0067 < 0x0001 {
+			k[machine.PC] = 0x0068
+			return 1
+		}
+	}
+	k[machine.PC] = 0x0068
+	return 1
+}
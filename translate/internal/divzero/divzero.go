@@ -0,0 +1,299 @@
+// Code generated by translate. DO NOT EDIT.
+
+// Package divzero is an RHMRM image translated to Go.
+package divzero
+
+import (
+	"github.com/niksaak/rhmrm/machine"
+)
+
+// Translation runs the image.
+var Translation = &machine.Translation{
+	Text: text,
+	Blocks: []machine.Block{
+		{Addr: 0x0000, Size: 6, Steps: 3, Cycles: 6, Run: b0000},
+		{Addr: 0x0006, Size: 26, Steps: 24, Cycles: 52, Run: b0006},
+		{Addr: 0x0020, Size: 20, Steps: 10, Cycles: 35, Run: b0020},
+	},
+}
+
+var text = []machine.Word{
+	0xf840, 0x3000, 0x1040, 0x0003, 0x1840, 0x8765, 0x1a81, 0x1296,
+	0x1ac1, 0x12d7, 0x1b01, 0x1318, 0x1b41, 0x1359, 0x1381, 0x7396,
+	0x13c1, 0x7bd9, 0x1c01, 0x1423, 0x57c5, 0x5fc5, 0x67c5, 0x6fc5,
+	0x77c5, 0x7fc5, 0x87c5, 0x1280, 0x0001, 0x1780, 0xffff, 0xf9f6,
+	0x5040, 0x8765, 0x5380, 0x0000, 0x5840, 0x8765, 0x5bc0, 0x0000,
+	0x6040, 0x8765, 0x6400, 0x0000, 0x6840, 0x8765, 0x6c40, 0x0000,
+	0x73c0, 0x0005, 0x8580, 0x00ff, 0x027c,
+}
+
+// b0000 runs 0000-0005.
+func b0000(c *machine.Core) uint64 {
+	r, k, cy := c.R, c.C, c.Cycles
+	var n machine.Word
+	// 0000: imp mov 31, 3000
+	r[0] = 0
+	n = 0x3000
+	r[31] = n
+	// 0002: imp mov  2, 0003
+	r[0] = 0
+	n = 0x0003
+	r[2] = n
+	// 0004: imp mov  3, 8765
+	r[0] = 0
+	n = 0x8765
+	r[3] = n
+	*cy += 6
+	k[machine.PC] = 0x0006
+	return 3
+}
+
+// b0006 runs 0006-001f.
+func b0006(c *machine.Core) uint64 {
+	r, k, cy := c.R, c.C, c.Cycles
+	var n machine.Word
+	// 0006: mov 10, 3
+	r[0] = 0
+	r[10] = r[3]
+	// 0007: div 10, 2
+	r[0] = 0
+	if r[2] == 0 {
+		r[10], k[machine.EX] = 0xffff, 0
+	} else {
+		k[machine.EX], r[10] = machine.Word(uint32(r[10])<<16/uint32(r[2])), machine.Word((uint32(r[10])<<16/uint32(r[2]))>>16)
+	}
+	// 0008: mov 11, 3
+	r[0] = 0
+	r[11] = r[3]
+	// 0009: dvi 11, 2
+	r[0] = 0
+	if r[2] == 0 {
+		r[11], k[machine.EX] = 0xffff, 0
+	} else {
+		k[machine.EX], r[11] = machine.Word(int32(r[11])<<16/int32(r[2])), machine.Word((int32(r[11])<<16/int32(r[2]))>>16)
+	}
+	// 000a: mov 12, 3
+	r[0] = 0
+	r[12] = r[3]
+	// 000b: mod 12, 2
+	r[0] = 0
+	if r[2] == 0 {
+		r[12] = 0
+	} else {
+		r[12] %= r[2]
+	}
+	// 000c: mov 13, 3
+	r[0] = 0
+	r[13] = r[3]
+	// 000d: mdi 13, 2
+	r[0] = 0
+	if r[2] == 0 {
+		r[13] = 0
+	} else {
+		r[13] = machine.Word(int16(r[13]) % int16(r[2]))
+	}
+	// 000e: mov 14, 2
+	r[0] = 0
+	r[14] = r[2]
+	// 000f: div 14, 14
+	r[0] = 0
+	if r[14] == 0 {
+		r[14], k[machine.EX] = 0xffff, 0
+	} else {
+		k[machine.EX], r[14] = machine.Word(uint32(r[14])<<16/uint32(r[14])), machine.Word((uint32(r[14])<<16/uint32(r[14]))>>16)
+	}
+	// 0010: mov 15, 2
+	r[0] = 0
+	r[15] = r[2]
+	// 0011: mdi 15, 15
+	r[0] = 0
+	if r[15] == 0 {
+		r[15] = 0
+	} else {
+		r[15] = machine.Word(int16(r[15]) % int16(r[15]))
+	}
+	// 0012: mov 16, 3
+	r[0] = 0
+	r[16] = r[3]
+	// 0013: bic 16, 2
+	r[0] = 0
+	r[16] &^= r[2]
+	// 0014: psh 31, 10
+	r[0] = 0
+	*cy += 34
+	k[machine.PC] = 0x0015
+	{
+		r[31]--
+		w := r[31]
+		ok := c.Store(w, r[10])
+		if !ok || w-0x0006 < 0x001a {
+			k[machine.PC] = 0x0015
+			return 15
+		}
+	}
+	// 0015: psh 31, 11
+	r[0] = 0
+	*cy += 2
+	k[machine.PC] = 0x0016
+	{
+		r[31]--
+		w := r[31]
+		ok := c.Store(w, r[11])
+		if !ok || w-0x0006 < 0x001a {
+			k[machine.PC] = 0x0016
+			return 16
+		}
+	}
+	// 0016: psh 31, 12
+	r[0] = 0
+	*cy += 2
+	k[machine.PC] = 0x0017
+	{
+		r[31]--
+		w := r[31]
+		ok := c.Store(w, r[12])
+		if !ok || w-0x0006 < 0x001a {
+			k[machine.PC] = 0x0017
+			return 17
+		}
+	}
+	// 0017: psh 31, 13
+	r[0] = 0
+	*cy += 2
+	k[machine.PC] = 0x0018
+	{
+		r[31]--
+		w := r[31]
+		ok := c.Store(w, r[13])
+		if !ok || w-0x0006 < 0x001a {
+			k[machine.PC] = 0x0018
+			return 18
+		}
+	}
+	// 0018: psh 31, 14
+	r[0] = 0
+	*cy += 2
+	k[machine.PC] = 0x0019
+	{
+		r[31]--
+		w := r[31]
+		ok := c.Store(w, r[14])
+		if !ok || w-0x0006 < 0x001a {
+			k[machine.PC] = 0x0019
+			return 19
+		}
+	}
+	// 0019: psh 31, 15
+	r[0] = 0
+	*cy += 2
+	k[machine.PC] = 0x001a
+	{
+		r[31]--
+		w := r[31]
+		ok := c.Store(w, r[15])
+		if !ok || w-0x0006 < 0x001a {
+			k[machine.PC] = 0x001a
+			return 20
+		}
+	}
+	// 001a: psh 31, 16
+	r[0] = 0
+	*cy += 2
+	k[machine.PC] = 0x001b
+	{
+		r[31]--
+		w := r[31]
+		ok := c.Store(w, r[16])
+		if !ok || w-0x0006 < 0x001a {
+			k[machine.PC] = 0x001b
+			return 21
+		}
+	}
+	// 001b: imp sub  2, 0001
+	r[0] = 0
+	n = 0x0001
+	k[machine.EX], r[2] = machine.Word((uint32(r[2])-uint32(n))>>16), machine.Word(uint32(r[2])-uint32(n))
+	// 001d: imp cmp  2, ffff
+	r[0] = 0
+	n = 0xffff
+	k[machine.EX] = r[2] - n
+	// 001f: jne -25
+	r[0] = 0
+	if k[machine.EX] != 0 {
+		*cy += 6
+		k[machine.PC] = 0x0006
+		return 24
+	}
+	*cy += 5
+	k[machine.PC] = 0x0020
+	return 24
+}
+
+// b0020 runs 0020-0033.
+func b0020(c *machine.Core) uint64 {
+	r, k, cy := c.R, c.C, c.Cycles
+	var n machine.Word
+	// 0020: imp mov 10, 8765
+	r[0] = 0
+	n = 0x8765
+	r[10] = n
+	// 0022: imp div 10, 0000
+	r[0] = 0
+	n = 0x0000
+	if n == 0 {
+		r[10], k[machine.EX] = 0xffff, 0
+	} else {
+		k[machine.EX], r[10] = machine.Word(uint32(r[10])<<16/uint32(n)), machine.Word((uint32(r[10])<<16/uint32(n))>>16)
+	}
+	// 0024: imp mov 11, 8765
+	r[0] = 0
+	n = 0x8765
+	r[11] = n
+	// 0026: imp dvi 11, 0000
+	r[0] = 0
+	n = 0x0000
+	if n == 0 {
+		r[11], k[machine.EX] = 0xffff, 0
+	} else {
+		k[machine.EX], r[11] = machine.Word(int32(r[11])<<16/int32(n)), machine.Word((int32(r[11])<<16/int32(n))>>16)
+	}
+	// 0028: imp mov 12, 8765
+	r[0] = 0
+	n = 0x8765
+	r[12] = n
+	// 002a: imp mod 12, 0000
+	r[0] = 0
+	n = 0x0000
+	if n == 0 {
+		r[12] = 0
+	} else {
+		r[12] %= n
+	}
+	// 002c: imp mov 13, 8765
+	r[0] = 0
+	n = 0x8765
+	r[13] = n
+	// 002e: imp mdi 13, 0000
+	r[0] = 0
+	n = 0x0000
+	if n == 0 {
+		r[13] = 0
+	} else {
+		r[13] = machine.Word(int16(r[13]) % int16(n))
+	}
+	// 0030: imp dvi 14, 0005
+	r[0] = 0
+	n = 0x0005
+	if n == 0 {
+		r[14], k[machine.EX] = 0xffff, 0
+	} else {
+		k[machine.EX], r[14] = machine.Word(int32(r[14])<<16/int32(n)), machine.Word((int32(r[14])<<16/int32(n))>>16)
+	}
+	// 0032: imp bic 16, 00ff
+	r[0] = 0
+	n = 0x00ff
+	r[16] &^= n
+	*cy += 35
+	k[machine.PC] = 0x0034
+	return 10
+}
@@ -0,0 +1,159 @@
+// Code generated by translate. DO NOT EDIT.
+
+// Package fib is an RHMRM image translated to Go.
+package fib
+
+import (
+	"github.com/niksaak/rhmrm/machine"
+)
+
+// Translation runs the image.
+var Translation = &machine.Translation{
+	Text: text,
+	Blocks: []machine.Block{
+		{Addr: 0x0000, Size: 5, Steps: 3, Cycles: 5, Run: b0000},
+		{Addr: 0x0005, Size: 1, Steps: 1, Cycles: 1, Run: b0005},
+		{Addr: 0x0007, Size: 6, Steps: 4, Cycles: 8, Run: b0007},
+		{Addr: 0x000e, Size: 6, Steps: 5, Cycles: 7, Run: b000e},
+		{Addr: 0x0014, Size: 8, Steps: 7, Cycles: 9, Run: b0014},
+	},
+}
+
+var text = []machine.Word{
+	0xf840, 0x1000, 0x0081, 0x1840, 0x000e, 0x1581, 0x1849, 0x97c5,
+	0x1200, 0x0001, 0x1780, 0x0019, 0xfe76, 0x027c, 0x0481, 0x0281,
+	0x9840, 0x0001, 0x05ab, 0x0275, 0x9281, 0x9a90, 0x9c81, 0x54c1,
+	0xb280, 0x0001, 0x05ab, 0xfe73, 0x0809,
+}
+
+// b0000 runs 0000-0004.
+func b0000(c *machine.Core) uint64 {
+	r, k, cy := c.R, c.C, c.Cycles
+	var n machine.Word
+	// 0000: imp mov 31, 1000
+	r[0] = 0
+	n = 0x1000
+	r[31] = n
+	// 0002: mov  2, 0
+	r[0] = 0
+	r[2] = r[0]
+	// 0003: imp mov  3, 000e
+	r[0] = 0
+	n = 0x000e
+	r[3] = n
+	*cy += 5
+	k[machine.PC] = 0x0005
+	return 3
+}
+
+// b0005 runs 0005-0005.
+func b0005(c *machine.Core) uint64 {
+	r, k, cy := c.R, c.C, c.Cycles
+	// 0005: mov 22, 2
+	r[0] = 0
+	r[22] = r[2]
+	*cy += 1
+	k[machine.PC] = 0x0006
+	return 1
+}
+
+// b0007 runs 0007-000c.
+func b0007(c *machine.Core) uint64 {
+	r, k, cy := c.R, c.C, c.Cycles
+	var n machine.Word
+	// 0007: psh 31, 18
+	r[0] = 0
+	*cy += 2
+	k[machine.PC] = 0x0008
+	{
+		r[31]--
+		w := r[31]
+		ok := c.Store(w, r[18])
+		if !ok || w-0x0007 < 0x0006 {
+			k[machine.PC] = 0x0008
+			return 1
+		}
+	}
+	// 0008: imp add  2, 0001
+	r[0] = 0
+	n = 0x0001
+	k[machine.EX], r[2] = machine.Word((uint32(r[2])+uint32(n))>>16), machine.Word(uint32(r[2])+uint32(n))
+	// 000a: imp cmp  2, 0019
+	r[0] = 0
+	n = 0x0019
+	k[machine.EX] = r[2] - n
+	// 000c: jne -7
+	r[0] = 0
+	if k[machine.EX] != 0 {
+		*cy += 6
+		k[machine.PC] = 0x0005
+		return 4
+	}
+	*cy += 5
+	k[machine.PC] = 0x000d
+	return 4
+}
+
+// b000e runs 000e-0013.
+func b000e(c *machine.Core) uint64 {
+	r, k, cy := c.R, c.C, c.Cycles
+	var n machine.Word
+	// 000e: mov 18, 0
+	r[0] = 0
+	r[18] = r[0]
+	// 000f: mov 10, 0
+	r[0] = 0
+	r[10] = r[0]
+	// 0010: imp mov 19, 0001
+	r[0] = 0
+	n = 0x0001
+	r[19] = n
+	// 0012: cmp 22, 0
+	r[0] = 0
+	k[machine.EX] = r[22] - r[0]
+	// 0013: jeq  9
+	r[0] = 0
+	if k[machine.EX] == 0 {
+		*cy += 7
+		k[machine.PC] = 0x001c
+		return 5
+	}
+	*cy += 6
+	k[machine.PC] = 0x0014
+	return 5
+}
+
+// b0014 runs 0014-001b.
+func b0014(c *machine.Core) uint64 {
+	r, k, cy := c.R, c.C, c.Cycles
+	var n machine.Word
+	// 0014: mov 10, 18
+	r[0] = 0
+	r[10] = r[18]
+	// 0015: add 10, 19
+	r[0] = 0
+	k[machine.EX], r[10] = machine.Word((uint32(r[10])+uint32(r[19]))>>16), machine.Word(uint32(r[10])+uint32(r[19]))
+	// 0016: mov 18, 19
+	r[0] = 0
+	r[18] = r[19]
+	// 0017: mov 19, 10
+	r[0] = 0
+	r[19] = r[10]
+	// 0018: imp sub 22, 0001
+	r[0] = 0
+	n = 0x0001
+	k[machine.EX], r[22] = machine.Word((uint32(r[22])-uint32(n))>>16), machine.Word(uint32(r[22])-uint32(n))
+	// 001a: cmp 22, 0
+	r[0] = 0
+	k[machine.EX] = r[22] - r[0]
+	// 001b: jgt -7
+	r[0] = 0
+	if k[machine.EX] > 0 {
+		*cy += 9
+		k[machine.PC] = 0x0014
+		return 7
+	}
+	*cy += 8
+	k[machine.PC] = 0x001c
+	return 7
+}
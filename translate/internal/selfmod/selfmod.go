@@ -0,0 +1,148 @@
+// Code generated by translate. DO NOT EDIT.
+
+// Package selfmod is an RHMRM image translated to Go.
+package selfmod
+
+import (
+	"github.com/niksaak/rhmrm/machine"
+)
+
+// Translation runs the image.
+var Translation = &machine.Translation{
+	Text: text,
+	Blocks: []machine.Block{
+		{Addr: 0x0000, Size: 2, Steps: 1, Cycles: 2, Run: b0000},
+		{Addr: 0x0002, Size: 14, Steps: 9, Cycles: 16, Run: b0002},
+		{Addr: 0x0010, Size: 2, Steps: 1, Cycles: 2, Run: b0010},
+		{Addr: 0x0013, Size: 5, Steps: 3, Cycles: 6, Run: b0013},
+		{Addr: 0x001a, Size: 2, Steps: 1, Cycles: 2, Run: b001a},
+	},
+}
+
+var text = []machine.Word{
+	0x1840, 0x0001, 0x1a81, 0x55c0, 0x000b, 0x5500, 0x015a, 0x5840,
+	0x000a, 0x52c4, 0x015a, 0x1a00, 0x0001, 0x1f80, 0x0008, 0xfcf6,
+	0x6040, 0x001a, 0x6049, 0x5040, 0x0009, 0x5840, 0x001b, 0x52c4,
+	0x6049, 0x027c, 0x3200, 0x0005, 0x0809,
+}
+
+// b0000 runs 0000-0001.
+func b0000(c *machine.Core) uint64 {
+	r, k, cy := c.R, c.C, c.Cycles
+	var n machine.Word
+	// 0000: imp mov  3, 0001
+	r[0] = 0
+	n = 0x0001
+	r[3] = n
+	*cy += 2
+	k[machine.PC] = 0x0002
+	return 1
+}
+
+// b0002 runs 0002-000f.
+func b0002(c *machine.Core) uint64 {
+	r, k, cy := c.R, c.C, c.Cycles
+	var n machine.Word
+	// 0002: mov 10, 3
+	r[0] = 0
+	r[10] = r[3]
+	// 0003: imp shl 10, 000b
+	r[0] = 0
+	n = 0x000b
+	k[machine.EX], r[10] = machine.Word((uint32(r[10])<<uint32(n))>>16), machine.Word(uint32(r[10])<<uint32(n))
+	// 0005: imp ior 10, 015a
+	r[0] = 0
+	n = 0x015a
+	r[10] |= n
+	// 0007: imp mov 11, 000a
+	r[0] = 0
+	n = 0x000a
+	r[11] = n
+	// 0009: str 11, 10
+	r[0] = 0
+	*cy += 9
+	k[machine.PC] = 0x000a
+	{
+		w := r[11]
+		ok := c.Store(w, r[10])
+		if !ok || w-0x0002 < 0x000e {
+			k[machine.PC] = 0x000a
+			return 5
+		}
+	}
+	// 000a: inc  5, 0
+	r[0] = 0
+	r[5] += 0
+	// 000b: imp add  3, 0001
+	r[0] = 0
+	n = 0x0001
+	k[machine.EX], r[3] = machine.Word((uint32(r[3])+uint32(n))>>16), machine.Word(uint32(r[3])+uint32(n))
+	// 000d: imp cmp  3, 0008
+	r[0] = 0
+	n = 0x0008
+	k[machine.EX] = r[3] - n
+	// 000f: jne -13
+	r[0] = 0
+	if k[machine.EX] != 0 {
+		*cy += 7
+		k[machine.PC] = 0x0002
+		return 9
+	}
+	*cy += 6
+	k[machine.PC] = 0x0010
+	return 9
+}
+
+// b0010 runs 0010-0011.
+func b0010(c *machine.Core) uint64 {
+	r, k, cy := c.R, c.C, c.Cycles
+	var n machine.Word
+	// 0010: imp mov 12, 001a
+	r[0] = 0
+	n = 0x001a
+	r[12] = n
+	*cy += 2
+	k[machine.PC] = 0x0012
+	return 1
+}
+
+// b0013 runs 0013-0017.
+func b0013(c *machine.Core) uint64 {
+	r, k, cy := c.R, c.C, c.Cycles
+	var n machine.Word
+	// 0013: imp mov 10, 0009
+	r[0] = 0
+	n = 0x0009
+	r[10] = n
+	// 0015: imp mov 11, 001b
+	r[0] = 0
+	n = 0x001b
+	r[11] = n
+	// 0017: str 11, 10
+	r[0] = 0
+	*cy += 6
+	k[machine.PC] = 0x0018
+	{
+		w := r[11]
+		ok := c.Store(w, r[10])
+		if !ok || w-0x0013 < 0x0005 {
+			k[machine.PC] = 0x0018
+			return 3
+		}
+	}
+	k[machine.PC] = 0x0018
+	return 3
+}
+
+// b001a runs 001a-001b.
+func b001a(c *machine.Core) uint64 {
+	r, k, cy := c.R, c.C, c.Cycles
+	var n machine.Word
+	// 001a: imp add  6, 0005
+	r[0] = 0
+	n = 0x0005
+	k[machine.EX], r[6] = machine.Word((uint32(r[6])+uint32(n))>>16), machine.Word(uint32(r[6])+uint32(n))
+	*cy += 2
+	k[machine.PC] = 0x001c
+	return 1
+}
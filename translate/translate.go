@@ -0,0 +1,448 @@
+// Package translate translates RHMRM machine code into Go.
+//
+// A translated image is a Go package declaring Translation, which is passed
+// to Machine.Run to run the image faster. Every basic block of the image is
+// translated into a function executing its instructions exactly as Step
+// does. Instructions which access control registers, send interrupts or
+// jump to addresses in registers are left to the interpreter, as is code
+// changed in memory since the translation.
+package translate
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"go/format"
+	"go/token"
+	"io"
+	"sort"
+
+	"github.com/niksaak/rhmrm/machine"
+)
+
+// Translate writes Go source of package pkg translating text, an image
+// loaded at address zero, to w.
+func Translate(w io.Writer, pkg string, text []machine.Word) error {
+	if len(text) > 0x10000 {
+		return errors.New("translate: image is too large")
+	}
+	if !token.IsIdentifier(pkg) {
+		return fmt.Errorf("translate: bad package name %q", pkg)
+	}
+	t := &translator{text: text, leaders: make(map[int]bool)}
+	t.translate()
+	src, err := format.Source(t.source(pkg))
+	if err != nil {
+		return fmt.Errorf("translate: %v", err)
+	}
+	_, err = w.Write(src)
+	return err
+}
+
+// Kinds of instructions.
+const (
+	plain  = iota // translated, followed by the next instruction
+	jump          // translated, ends a block
+	interp        // left to the interpreter
+)
+
+// insn is an instruction of the image.
+type insn struct {
+	addr int
+	i    machine.Instruction
+	n    machine.Word // immediate word
+	size int
+}
+
+// op returns opcode of the instruction, or IMP subopcode.
+func (in insn) op() (op machine.Word, imp bool) {
+	if in.i.Op() == machine.OP_IMP {
+		return in.i.A(), true
+	}
+	return in.i.Op(), false
+}
+
+// Operations translated, with Go statements of their semantics. The first
+// operand is the register a, the second is register b or the immediate.
+var ops = map[machine.Word]string{
+	machine.OP_MOV: "%[1]s = %[2]s",
+
+	machine.OP_ADD: "%[3]s, %[1]s = machine.Word((uint32(%[1]s) + uint32(%[2]s)) >> 16), machine.Word(uint32(%[1]s) + uint32(%[2]s))",
+	machine.OP_ADX: "%[3]s, %[1]s = machine.Word((uint32(%[1]s) + uint32(%[2]s) + uint32(%[3]s)) >> 16), machine.Word(uint32(%[1]s) + uint32(%[2]s) + uint32(%[3]s))",
+	machine.OP_SUB: "%[3]s, %[1]s = machine.Word((uint32(%[1]s) - uint32(%[2]s)) >> 16), machine.Word(uint32(%[1]s) - uint32(%[2]s))",
+	machine.OP_SBX: "%[3]s, %[1]s = machine.Word((uint32(%[1]s) - uint32(%[2]s) + uint32(%[3]s)) >> 16), machine.Word(uint32(%[1]s) - uint32(%[2]s) + uint32(%[3]s))",
+	machine.OP_MUL: "%[3]s, %[1]s = machine.Word((uint32(%[1]s) * uint32(%[2]s)) >> 16), machine.Word(uint32(%[1]s) * uint32(%[2]s))",
+	machine.OP_MLI: "%[3]s, %[1]s = machine.Word((int32(%[1]s) * int32(%[2]s)) >> 16), machine.Word(int32(%[1]s) * int32(%[2]s))",
+	machine.OP_DIV: "if %[2]s == 0 { %[1]s, %[3]s = 0xffff, 0 } else { %[3]s, %[1]s = machine.Word(uint32(%[1]s) << 16 / uint32(%[2]s)), machine.Word((uint32(%[1]s) << 16 / uint32(%[2]s)) >> 16) }",
+	machine.OP_DVI: "if %[2]s == 0 { %[1]s, %[3]s = 0xffff, 0 } else { %[3]s, %[1]s = machine.Word(int32(%[1]s) << 16 / int32(%[2]s)), machine.Word((int32(%[1]s) << 16 / int32(%[2]s)) >> 16) }",
+	machine.OP_MOD: "if %[2]s == 0 { %[1]s = 0 } else { %[1]s %%= %[2]s }",
+	machine.OP_MDI: "if %[2]s == 0 { %[1]s = 0 } else { %[1]s = machine.Word(int16(%[1]s) %% int16(%[2]s)) }",
+	machine.OP_INC: "%[1]s += %[2]s",
+	machine.OP_GBS: "%[1]s = machine.Word(bits.Len16(uint16(%[2]s)))",
+
+	machine.OP_AND: "%[1]s &= %[2]s",
+	machine.OP_IOR: "%[1]s |= %[2]s",
+	machine.OP_XOR: "%[1]s ^= %[2]s",
+	machine.OP_BIC: "%[1]s &^= %[2]s",
+	machine.OP_SHL: "%[3]s, %[1]s = machine.Word((uint32(%[1]s) << uint32(%[2]s)) >> 16), machine.Word(uint32(%[1]s) << uint32(%[2]s))",
+	machine.OP_ASR: "%[3]s, %[1]s = machine.Word(int32(%[1]s) << 16 >> uint32(%[2]s)), machine.Word((int32(%[1]s) << 16 >> uint32(%[2]s)) >> 16)",
+	machine.OP_SHR: "%[3]s, %[1]s = machine.Word(uint32(%[1]s) << 16 >> uint32(%[2]s)), machine.Word((uint32(%[1]s) << 16 >> uint32(%[2]s)) >> 16)",
+	machine.OP_ROL: "%[1]s = machine.Word(uint32(%[1]s) << uint32(%[2]s)) | machine.Word((uint32(%[1]s) << uint32(%[2]s)) >> 16)",
+	machine.OP_ROR: "%[1]s = machine.Word(uint32(%[1]s) << 16 >> uint32(%[2]s)) | machine.Word((uint32(%[1]s) << 16 >> uint32(%[2]s)) >> 16)",
+
+	machine.OP_TST: "%[3]s = %[1]s & %[2]s",
+	machine.OP_TEQ: "%[3]s = %[1]s ^ %[2]s",
+	machine.OP_CMP: "%[3]s = %[1]s - %[2]s",
+	machine.OP_CMN: "%[3]s = %[1]s + %[2]s",
+}
+
+// imps are IMP operations translated like ops.
+var imps = map[machine.Word]string{
+	machine.IMP_MOV: ops[machine.OP_MOV],
+
+	machine.IMP_ADD: ops[machine.OP_ADD],
+	machine.IMP_ADX: ops[machine.OP_ADX],
+	machine.IMP_SUB: ops[machine.OP_SUB],
+	machine.IMP_SBX: ops[machine.OP_SBX],
+	machine.IMP_MUL: ops[machine.OP_MUL],
+	machine.IMP_MLI: ops[machine.OP_MLI],
+	machine.IMP_DIV: ops[machine.OP_DIV],
+	machine.IMP_DVI: ops[machine.OP_DVI],
+	machine.IMP_MOD: ops[machine.OP_MOD],
+	machine.IMP_MDI: ops[machine.OP_MDI],
+	machine.IMP_INC: ops[machine.OP_INC],
+
+	machine.IMP_AND: ops[machine.OP_AND],
+	machine.IMP_IOR: ops[machine.OP_IOR],
+	machine.IMP_XOR: ops[machine.OP_XOR],
+	machine.IMP_BIC: ops[machine.OP_BIC],
+	machine.IMP_SHL: ops[machine.OP_SHL],
+	machine.IMP_ASR: ops[machine.OP_ASR],
+	machine.IMP_SHR: ops[machine.OP_SHR],
+	machine.IMP_ROL: ops[machine.OP_ROL],
+	machine.IMP_ROR: ops[machine.OP_ROR],
+
+	machine.IMP_TST: ops[machine.OP_TST],
+	machine.IMP_TEQ: ops[machine.OP_TEQ],
+	machine.IMP_CMP: ops[machine.OP_CMP],
+	machine.IMP_CMN: ops[machine.OP_CMN],
+}
+
+// Conditions of relative jumps, as the interpreter tests EX.
+var conds = map[machine.Word]string{
+	machine.OP_JMP: "",
+	machine.OP_JLT: "k[machine.EX] < 0",
+	machine.OP_JLE: "k[machine.EX] <= 0",
+	machine.OP_JGT: "k[machine.EX] > 0",
+	machine.OP_JGE: "k[machine.EX] >= 0",
+	machine.OP_JEQ: "k[machine.EX] == 0",
+	machine.OP_JNE: "k[machine.EX] != 0",
+}
+
+// kind returns kind of the instruction.
+func (in insn) kind() int {
+	op, imp := in.op()
+	switch {
+	case imp && op == machine.IMP_SRL:
+		return jump
+	case imp && (op == machine.IMP_STR || op == machine.IMP_PSH):
+		return plain
+	case imp && imps[op] != "":
+		return plain
+	case imp:
+		return interp
+	}
+	switch op {
+	case machine.OP_STR, machine.OP_PSH, machine.OP_LOA, machine.OP_POP,
+		machine.OP_MOM:
+		return plain
+	}
+	if _, ok := conds[op]; ok {
+		return jump
+	}
+	if ops[op] != "" {
+		return plain
+	}
+	return interp
+}
+
+// target returns address a jump goes to, if it is known.
+func (in insn) target() (int, bool) {
+	op, imp := in.op()
+	switch {
+	case imp && op == machine.IMP_SRL:
+		return int(in.n), true
+	case imp:
+		return 0, false
+	}
+	if _, ok := conds[op]; ok {
+		return int(machine.Word(in.addr) + in.i.Cs()), true
+	}
+	return 0, false
+}
+
+// translator translates an image.
+type translator struct {
+	text    []machine.Word
+	leaders map[int]bool // addresses blocks start at
+	work    []int        // leaders to translate
+	blocks  []*block
+	bits    bool // math/bits is used
+}
+
+// block is a translated basic block.
+type block struct {
+	addr, size int
+	steps      int
+	cycles     uint64
+	imm        bool // n holds immediate words
+	code       bytes.Buffer
+}
+
+// at returns instruction at address a. Instructions without immediate
+// words in the image are left to the interpreter.
+func (t *translator) at(a int) (in insn, ok bool) {
+	in = insn{addr: a, i: machine.Instruction(t.text[a])}
+	in.size = int(in.i.Size())
+	if in.size == 2 {
+		if a+1 >= len(t.text) {
+			return in, false
+		}
+		in.n = t.text[a+1]
+	}
+	return in, in.kind() != interp
+}
+
+// leader marks address a as the start of a block.
+func (t *translator) leader(a int) {
+	if a < len(t.text) && !t.leaders[a] {
+		t.leaders[a] = true
+		t.work = append(t.work, a)
+	}
+}
+
+// translate finds basic blocks of the image and translates them. Blocks
+// start at the image start, at targets of jumps and after instructions
+// ending blocks, found sweeping through the image and its blocks.
+func (t *translator) translate() {
+	t.leader(0)
+	for a := 0; a < len(t.text); {
+		in, ok := t.at(a)
+		if !ok || in.kind() == jump {
+			t.leader(a + in.size)
+		}
+		if to, ok := in.target(); ok {
+			t.leader(to)
+		}
+		a += in.size
+	}
+	for len(t.work) > 0 {
+		a := t.work[len(t.work)-1]
+		t.work = t.work[:len(t.work)-1]
+		if b := t.block(a); b != nil {
+			t.blocks = append(t.blocks, b)
+		}
+	}
+	sort.Slice(t.blocks, func(i, j int) bool {
+		return t.blocks[i].addr < t.blocks[j].addr
+	})
+}
+
+// block translates block starting at address a, or returns nil if its
+// first instruction is left to the interpreter.
+func (t *translator) block(a int) *block {
+	var ins []insn
+	for pc := a; ; {
+		in, ok := t.at(pc)
+		if !ok {
+			t.leader(pc + in.size)
+			if len(ins) == 0 {
+				return nil
+			}
+			break
+		}
+		ins = append(ins, in)
+		pc += in.size
+		if in.kind() == jump {
+			if to, ok := in.target(); ok {
+				t.leader(to)
+			}
+			t.leader(pc)
+			break
+		}
+		if pc >= len(t.text) || t.leaders[pc] {
+			break
+		}
+	}
+	b := &block{addr: a}
+	last := ins[len(ins)-1]
+	b.size = last.addr + last.size - a
+	b.steps = len(ins)
+	for _, in := range ins {
+		b.cycles += in.i.Cycles()
+	}
+	if _, imp := last.op(); !imp && last.kind() == jump {
+		b.cycles += machine.BRANCH_PENALTY
+	}
+	t.emit(b, ins)
+	return b
+}
+
+// emitter writes code of a block.
+type emitter struct {
+	*block
+	pending uint64 // cycles not yet added
+}
+
+func (e *emitter) printf(format string, args ...interface{}) {
+	fmt.Fprintf(&e.code, format, args...)
+	e.code.WriteByte('\n')
+}
+
+// flush adds cycles taken so far.
+func (e *emitter) flush() {
+	if e.pending != 0 {
+		e.printf("*cy += %d", e.pending)
+		e.pending = 0
+	}
+}
+
+// exit returns from the block after n instructions with PC at pc.
+func (e *emitter) exit(pc int, n int) {
+	e.flush()
+	e.printf("k[machine.PC] = 0x%04x", uint16(pc))
+	e.printf("return %d", n)
+}
+
+// access writes a memory access by instruction number n, which ends the
+// block if ok is false, or the address stored into is within the block.
+func (e *emitter) access(in insn, n int, code, ok, addr string) {
+	e.pending += in.i.Cycles()
+	e.flush()
+	e.printf("k[machine.PC] = 0x%04x", uint16(in.addr+1))
+	e.printf("{")
+	e.printf("%s", code)
+	if addr == "" {
+		e.printf("if !%s {", ok)
+	} else {
+		e.printf("if !%s || %s-0x%04x < 0x%04x {", ok, addr, e.addr, e.size)
+	}
+	e.exit(in.addr+in.size, n+1)
+	e.printf("}")
+	e.printf("}")
+}
+
+// emit writes code of instructions ins of block b.
+func (t *translator) emit(b *block, ins []insn) {
+	e := &emitter{block: b}
+	for n, in := range ins {
+		op, imp := in.op()
+		a, x := fmt.Sprintf("r[%d]", in.i.A()), fmt.Sprintf("r[%d]", in.i.B())
+		if imp {
+			a, x = x, fmt.Sprintf("0x%04x", uint16(in.n))
+		}
+		if imp {
+			e.printf("// %04x: %s, %04x", in.addr, in.i, uint16(in.n))
+		} else {
+			e.printf("// %04x: %s", in.addr, in.i)
+		}
+		e.printf("r[0] = 0")
+		switch {
+		case imp && op == machine.IMP_STR:
+			e.access(in, n, fmt.Sprintf("w := %s\nok := c.Store(w, %s)", a, x),
+				"ok", "w")
+		case imp && op == machine.IMP_PSH:
+			e.access(in, n, fmt.Sprintf("%s--\nw := %s\nok := c.Store(w, %s)", a, a, x),
+				"ok", "w")
+		case imp && op == machine.IMP_SRL:
+			e.pending += in.i.Cycles()
+			e.printf("%s = 0x%04x", a, uint16(in.addr+1))
+			e.exit(int(in.n), n+1)
+		case imp:
+			e.pending += in.i.Cycles()
+			e.imm = true
+			e.printf("n = %s", x)
+			e.printf(imps[op], a, "n", "k[machine.EX]")
+		case op == machine.OP_STR:
+			e.access(in, n, fmt.Sprintf("w := %s\nok := c.Store(w, %s)", a, x),
+				"ok", "w")
+		case op == machine.OP_PSH:
+			e.access(in, n, fmt.Sprintf("%s--\nw := %s\nok := c.Store(w, %s)", a, a, x),
+				"ok", "w")
+		case op == machine.OP_LOA:
+			e.access(in, n, fmt.Sprintf("w, ok := c.Load(%s)\n%s = w", x, a),
+				"ok", "")
+		case op == machine.OP_POP:
+			e.access(in, n, fmt.Sprintf("w, ok := c.Load(%s)\n%s = w\n%s++", x, a, x),
+				"ok", "")
+		case op == machine.OP_MOM:
+			e.access(in, n, fmt.Sprintf("w, ok := c.Load(%s)\nv := %s\nok = c.Store(v, w) && ok\n%s++\n%s++", x, a, a, x),
+				"ok", "v")
+		case in.kind() == jump:
+			e.pending += in.i.Cycles()
+			to, _ := in.target()
+			if cond := conds[op]; cond != "" {
+				e.printf("if %s {", cond)
+				pending := e.pending
+				e.pending += machine.BRANCH_PENALTY
+				e.exit(to, n+1)
+				e.pending = pending
+				e.printf("}")
+				e.exit(in.addr+in.size, n+1)
+			} else {
+				e.pending += machine.BRANCH_PENALTY
+				e.exit(to, n+1)
+			}
+		default:
+			e.pending += in.i.Cycles()
+			if op == machine.OP_INC || op == machine.OP_ROL ||
+				op == machine.OP_ROR {
+				x = fmt.Sprintf("%d", in.i.B())
+			}
+			t.bits = t.bits || op == machine.OP_GBS
+			e.printf(ops[op], a, x, "k[machine.EX]")
+		}
+	}
+	if last := ins[len(ins)-1]; last.kind() != jump {
+		e.exit(last.addr+last.size, len(ins))
+	}
+}
+
+// source returns unformatted source of package pkg.
+func (t *translator) source(pkg string) []byte {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "// Code generated by translate. DO NOT EDIT.\n\n")
+	fmt.Fprintf(&buf, "// Package %s is an RHMRM image translated to Go.\n", pkg)
+	fmt.Fprintf(&buf, "package %s\n\nimport (\n", pkg)
+	if t.bits {
+		fmt.Fprintf(&buf, "%q\n\n", "math/bits")
+	}
+	fmt.Fprintf(&buf, "%q\n)\n\n", "github.com/niksaak/rhmrm/machine")
+	fmt.Fprintf(&buf, "// Translation runs the image.\n")
+	fmt.Fprintf(&buf, "var Translation = &machine.Translation{\n")
+	fmt.Fprintf(&buf, "Text: text,\nBlocks: []machine.Block{\n")
+	for _, b := range t.blocks {
+		fmt.Fprintf(&buf, "{Addr: 0x%04x, Size: %d, Steps: %d, Cycles: %d, Run: b%04x},\n",
+			b.addr, b.size, b.steps, b.cycles, b.addr)
+	}
+	fmt.Fprintf(&buf, "},\n}\n\nvar text = []machine.Word{")
+	for i, w := range t.text {
+		if i%8 == 0 {
+			buf.WriteString("\n")
+		}
+		fmt.Fprintf(&buf, "0x%04x, ", uint16(w))
+	}
+	fmt.Fprintf(&buf, "\n}\n")
+	for _, b := range t.blocks {
+		fmt.Fprintf(&buf, "\n// b%04x runs %04x-%04x.\n", b.addr, b.addr,
+			b.addr+b.size-1)
+		fmt.Fprintf(&buf, "func b%04x(c *machine.Core) uint64 {\n", b.addr)
+		fmt.Fprintf(&buf, "r, k, cy := c.R, c.C, c.Cycles\n")
+		if b.imm {
+			fmt.Fprintf(&buf, "var n machine.Word\n")
+		}
+		buf.Write(b.code.Bytes())
+		fmt.Fprintf(&buf, "}\n")
+	}
+	return buf.Bytes()
+}
@@ -0,0 +1,235 @@
+package translate_test
+
+import (
+	"bytes"
+	"context"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/niksaak/rhmrm/asm/compiler"
+	"github.com/niksaak/rhmrm/asm/parser"
+	"github.com/niksaak/rhmrm/machine"
+	"github.com/niksaak/rhmrm/translate"
+	"github.com/niksaak/rhmrm/translate/internal/devices"
+	"github.com/niksaak/rhmrm/translate/internal/divzero"
+	"github.com/niksaak/rhmrm/translate/internal/fib"
+	"github.com/niksaak/rhmrm/translate/internal/selfmod"
+)
+
+var update = flag.Bool("update", false, "rewrite translated images")
+
+// uart is a memory-mapped device. Loads count, stores raise interrupts.
+type uart struct {
+	n machine.Word
+}
+
+func (u *uart) Load(m *machine.Machine, i machine.Word) machine.Word {
+	u.n++
+	return u.n
+}
+
+func (u *uart) Store(m *machine.Machine, i, w machine.Word) {
+	m.HWInterrupt(w)
+}
+
+// ticker raises interrupt 42 on every hardware interrupt sent to it.
+type ticker struct{}
+
+func (ticker) Info() machine.DeviceInfo {
+	return machine.DeviceInfo{Vendor: 0x7e57, ID: 0x71c4, Version: 1}
+}
+
+func (ticker) Interrupt(m *machine.Machine) {
+	m.HWInterrupt(42)
+}
+
+// images are programs in testdata translated into packages in internal.
+var images = []struct {
+	name        string
+	translation *machine.Translation
+	devices     bool // uart at ff00, ticker as device 0
+}{
+	{"fib", fib.Translation, false},
+	{"selfmod", selfmod.Translation, false},
+	{"devices", devices.Translation, true},
+	{"divzero", divzero.Translation, false},
+}
+
+// assemble assembles file path.
+func assemble(t *testing.T, path string) []machine.Word {
+	src, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := new(compiler.Compiler).Init(0)
+	text, err := c.Compile(parser.ParseFile(src, path))
+	if err != nil {
+		t.Fatalf("%s: %v", path, err)
+	}
+	return text
+}
+
+func TestTranslate(t *testing.T) {
+	for _, img := range images {
+		text := assemble(t, filepath.Join("testdata", img.name+".rhm"))
+		var buf bytes.Buffer
+		if err := translate.Translate(&buf, img.name, text); err != nil {
+			t.Fatalf("%s: %v", img.name, err)
+		}
+		path := filepath.Join("internal", img.name, img.name+".go")
+		if *update {
+			if err := ioutil.WriteFile(path, buf.Bytes(), 0666); err != nil {
+				t.Fatal(err)
+			}
+			continue
+		}
+		src, err := ioutil.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(src, buf.Bytes()) {
+			t.Errorf("%s is out of date, run go test -update", path)
+		}
+	}
+}
+
+func TestTranslateErrors(t *testing.T) {
+	var buf bytes.Buffer
+	if translate.Translate(&buf, "a-b", nil) == nil {
+		t.Error("translated into bad package")
+	}
+	if translate.Translate(&buf, "big", make([]machine.Word, 0x10001)) == nil {
+		t.Error("translated too large image")
+	}
+}
+
+// mk_machine returns machine in supervisor mode with text loaded.
+func mk_machine(text []machine.Word, devices bool) *machine.Machine {
+	m := new(machine.Machine)
+	(*machine.FlagsRegister)(m.C(machine.FL)).SetS(true)
+	m.Load(text)
+	if devices {
+		m.Map(0xff00, 1, new(uart))
+		m.Attach(ticker{})
+	}
+	return m
+}
+
+// snapshot returns snapshot of m.
+func snapshot(t *testing.T, m *machine.Machine) []byte {
+	var buf bytes.Buffer
+	if err := m.Snapshot(&buf, false); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// same_state reports whether registers and memory of m and n are the same.
+func same_state(m, n *machine.Machine) bool {
+	for i := machine.Word(0); i < 32; i++ {
+		if *m.R(i) != *n.R(i) {
+			return false
+		}
+	}
+	for i := machine.Word(0); i < 8; i++ {
+		if *m.C(i) != *n.C(i) {
+			return false
+		}
+	}
+	for i := 0; i < 0x10000; i++ {
+		if *m.Mem(machine.Word(i)) != *n.Mem(machine.Word(i)) {
+			return false
+		}
+	}
+	return m.Cycles() == n.Cycles() &&
+		m.PendingInterrupts() == n.PendingInterrupts()
+}
+
+// counted returns copy of tr counting blocks run into n.
+func counted(tr *machine.Translation, n *int) *machine.Translation {
+	c := &machine.Translation{Text: tr.Text}
+	for _, b := range tr.Blocks {
+		run := b.Run
+		b.Run = func(c *machine.Core) uint64 {
+			*n++
+			return run(c)
+		}
+		c.Blocks = append(c.Blocks, b)
+	}
+	return c
+}
+
+// fits reports whether any block of tr fits in budget of opts.
+func fits(tr *machine.Translation, opts machine.RunOptions) bool {
+	for _, b := range tr.Blocks {
+		if (opts.Steps == 0 || b.Steps <= opts.Steps) &&
+			(opts.Cycles == 0 || b.Cycles < opts.Cycles) {
+			return true
+		}
+	}
+	return false
+}
+
+func TestTranslation(t *testing.T) {
+	budgets := []machine.RunOptions{
+		{}, {Steps: 1}, {Steps: 2}, {Steps: 7}, {Steps: 100},
+		{Cycles: 1}, {Cycles: 3}, {Cycles: 10}, {Cycles: 250},
+	}
+	for _, img := range images {
+		for _, opts := range budgets {
+			name := fmt.Sprintf("%s/%d/%d", img.name, opts.Steps, opts.Cycles)
+			var blocks int
+			m := mk_machine(img.translation.Text, img.devices)
+			n := mk_machine(img.translation.Text, img.devices)
+			topts := opts
+			topts.Translation = counted(img.translation, &blocks)
+			for i := 0; ; i++ {
+				r := m.Run(context.Background(), topts)
+				want := n.Run(context.Background(), opts)
+				if r.String() != want.String() {
+					t.Fatalf("%s: run %d: %v, want %v", name, i, r, want)
+				}
+				if !same_state(m, n) {
+					t.Fatalf("%s: run %d: states differ", name, i)
+				}
+				if r.Kind != machine.StopSteps && r.Kind != machine.StopCycles {
+					if r.Kind != machine.StopInterrupt || r.Message != 9 {
+						t.Fatalf("%s: stopped with %v", name, r)
+					}
+					break
+				}
+			}
+			if !bytes.Equal(snapshot(t, m), snapshot(t, n)) {
+				t.Errorf("%s: snapshots differ", name)
+			}
+			if blocks == 0 && fits(img.translation, opts) {
+				t.Errorf("%s: no blocks run", name)
+			}
+		}
+	}
+}
+
+func TestTranslationResults(t *testing.T) {
+	m := mk_machine(fib.Translation.Text, false)
+	r := m.Run(context.Background(),
+		machine.RunOptions{Translation: fib.Translation})
+	if r.Kind != machine.StopInterrupt {
+		t.Fatalf("fib stopped with %v", r)
+	}
+	a, b := machine.Word(0), machine.Word(1)
+	for i := machine.Word(1); i <= 25; i++ {
+		if v := *m.Mem(0x1000 - i); v != a {
+			t.Fatalf("fib(%d) is %v, want %v", i-1, v, a)
+		}
+		a, b = b, a+b
+	}
+	m = mk_machine(selfmod.Translation.Text, false)
+	m.Run(context.Background(),
+		machine.RunOptions{Translation: selfmod.Translation})
+	if s3, s4 := *m.R(machine.S + 3), *m.R(machine.S + 4); s3 != 28 || s4 != 14 {
+		t.Errorf("selfmod s3 and s4 are %v and %v, want 28 and 14", s3, s4)
+	}
+}